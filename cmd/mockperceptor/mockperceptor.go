@@ -70,6 +70,12 @@ func (mr *MockPerceptorResponder) GetModel() (*api.Model, error) {
 	return &api.Model{}, nil
 }
 
+// GetFailedScans .....
+func (mr *MockPerceptorResponder) GetFailedScans() api.FailedScans {
+	log.Info("GetFailedScans")
+	return api.FailedScans{Images: nil}
+}
+
 // AddPod .....
 func (mr *MockPerceptorResponder) AddPod(pod api.Pod) error {
 	log.Infof("AddPod")
@@ -115,7 +121,7 @@ func (mr *MockPerceptorResponder) UpdateAllImages(allImages api.AllImages) error
 }
 
 // GetNextImage .....
-func (mr *MockPerceptorResponder) GetNextImage() api.NextImage {
+func (mr *MockPerceptorResponder) GetNextImage(request api.NextImageRequest) api.NextImage {
 	log.Info("GetNextImage")
 	start := time.Now().String()
 	imageSpec := api.ImageSpec{
@@ -126,13 +132,14 @@ func (mr *MockPerceptorResponder) GetNextImage() api.NextImage {
 		Domain:                      "",
 		Port:                        8443,
 		User:                        "mock-username",
-		Password:                    "mock-password",
+		ScanToken:                   start,
 		BlackDuckProjectName:        "string",
 		BlackDuckProjectVersionName: "string",
 		BlackDuckScanName:           start,
 		Priority:                    1,
 	}
-	return api.NextImage{ImageSpec: &imageSpec}
+	lease := api.Lease{ID: start, Sha: imageSpec.Sha, Expiry: time.Now().Add(5 * time.Minute)}
+	return api.NextImage{ImageSpec: &imageSpec, Lease: &lease}
 }
 
 // PostFinishScan .....
@@ -141,9 +148,39 @@ func (mr *MockPerceptorResponder) PostFinishScan(job api.FinishedScanClientJob)
 	return nil
 }
 
+// Heartbeat .....
+func (mr *MockPerceptorResponder) Heartbeat(heartbeat api.Heartbeat) (*api.Lease, error) {
+	log.Infof("Heartbeat")
+	return &api.Lease{ID: heartbeat.LeaseID, Expiry: time.Now().Add(5 * time.Minute)}, nil
+}
+
+// ExchangeScanToken .....
+func (mr *MockPerceptorResponder) ExchangeScanToken(exchange api.ScanTokenExchange) (*api.ScanCredentials, error) {
+	log.Infof("ExchangeScanToken")
+	return &api.ScanCredentials{
+		Scheme:      "https",
+		Domain:      "",
+		Port:        8443,
+		BearerToken: "mock-bearer-token",
+		Expiry:      time.Now().Add(5 * time.Minute)}, nil
+}
+
+// RegisterScanner .....
+func (mr *MockPerceptorResponder) RegisterScanner(registration api.ScannerRegistration) error {
+	log.Infof("RegisterScanner")
+	return nil
+}
+
+// GetScanners .....
+func (mr *MockPerceptorResponder) GetScanners() api.Scanners {
+	log.Info("GetScanners")
+	return api.Scanners{Scanners: nil}
+}
+
 // PostCommand ...
-func (mr *MockPerceptorResponder) PostCommand(command *api.PostCommand) {
+func (mr *MockPerceptorResponder) PostCommand(command *api.PostCommand) error {
 	// TODO
+	return nil
 }
 
 // NotFound .....
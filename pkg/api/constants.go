@@ -27,8 +27,11 @@ package api
 // curl -X GET http://perceptor:3001/metrics
 const (
 	// perceptor-scanner paths
-	NextImagePath    = "nextimage"
-	FinishedScanPath = "finishedscan"
+	NextImagePath       = "nextimage"
+	FinishedScanPath    = "finishedscan"
+	HeartbeatPath       = "heartbeat"
+	ScannersPath        = "scanners"
+	ScanCredentialsPath = "scancredentials"
 	// perceiver paths
 	PodPath         = "pod"
 	ImagePath       = "image"
@@ -36,5 +39,6 @@ const (
 	AllImagesPath   = "allimages"
 	AllPodsPath     = "allpods"
 	// Internal
+	FailedScansPath         = "failedscans"
 	ConcurrentScanLimitPath = "concurrentscanlimit"
 )
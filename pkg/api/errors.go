@@ -0,0 +1,44 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package api
+
+// NotFoundError is returned by a Responder when a request refers to
+// something which doesn't exist, so that it can be answered with a 404
+// rather than a 400.
+type NotFoundError struct {
+	Message string
+}
+
+func (err *NotFoundError) Error() string {
+	return err.Message
+}
+
+// LeaseError is returned by a Responder when a scanner presents a scan
+// lease which doesn't exist, has expired, or isn't for the image it
+// claims, so that it can be answered with a 409 rather than a 400.
+type LeaseError struct {
+	Message string
+}
+
+func (err *LeaseError) Error() string {
+	return err.Message
+}
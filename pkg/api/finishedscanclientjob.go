@@ -25,4 +25,6 @@ package api
 type FinishedScanClientJob struct {
 	ImageSpec *ImageSpec
 	Err       string
+	// LeaseID must be the ID of the lease that came with the image
+	LeaseID string
 }
@@ -29,6 +29,8 @@ type Image struct {
 	Priority                *int
 	BlackDuckProjectName    string
 	BlackDuckProjectVersion string
+	// ScannerPool, if set, restricts the image to scanners in that pool
+	ScannerPool string
 }
 
 // NewImage .....
@@ -21,7 +21,9 @@ under the License.
 
 package api
 
-// ImageSpec stores the Image specification
+// ImageSpec stores the Image specification.
+// Password is only filled in in the legacy credential mode; otherwise, the
+// scanner exchanges ScanToken for the Black Duck credentials for this scan.
 type ImageSpec struct {
 	Repository                  string
 	Tag                         string
@@ -31,6 +33,7 @@ type ImageSpec struct {
 	Port                        int
 	User                        string
 	Password                    string
+	ScanToken                   string
 	BlackDuckProjectName        string
 	BlackDuckProjectVersionName string
 	BlackDuckScanName           string
@@ -0,0 +1,38 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package api
+
+import "time"
+
+// Lease is handed to a scanner along with the image it's to scan.  The
+// scanner must renew it with heartbeats before it expires, and present it
+// when it posts the finished scan.
+type Lease struct {
+	ID     string
+	Sha    string
+	Expiry time.Time
+}
+
+// Heartbeat renews a lease
+type Heartbeat struct {
+	LeaseID string
+}
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -84,6 +85,11 @@ func (mr *MockResponder) GetModel() (*Model, error) {
 	return &Model{}, nil
 }
 
+// GetFailedScans .....
+func (mr *MockResponder) GetFailedScans() FailedScans {
+	return FailedScans{Images: []FailedScan{}}
+}
+
 // perceiver
 
 // AddPod .....
@@ -196,7 +202,7 @@ func (mr *MockResponder) UpdateAllImages(allImages AllImages) error {
 // scanner
 
 // GetNextImage .....
-func (mr *MockResponder) GetNextImage() NextImage {
+func (mr *MockResponder) GetNextImage(request NextImageRequest) NextImage {
 	mr.NextImageCounter++
 	imageSpec := ImageSpec{
 		BlackDuckProjectName:        fmt.Sprintf("mock-perceptor-%d", mr.NextImageCounter),
@@ -205,7 +211,11 @@ func (mr *MockResponder) GetNextImage() NextImage {
 		Repository:                  "abc/def/ghi",
 		Tag:                         "latest",
 		Sha:                         "123abc456def"}
-	return NextImage{ImageSpec: &imageSpec}
+	lease := Lease{
+		ID:     fmt.Sprintf("mock-perceptor-lease-%d", mr.NextImageCounter),
+		Sha:    imageSpec.Sha,
+		Expiry: time.Now().Add(5 * time.Minute)}
+	return NextImage{ImageSpec: &imageSpec, Lease: &lease}
 }
 
 // PostFinishScan .....
@@ -214,11 +224,38 @@ func (mr *MockResponder) PostFinishScan(job FinishedScanClientJob) error {
 	return nil
 }
 
+// Heartbeat .....
+func (mr *MockResponder) Heartbeat(heartbeat Heartbeat) (*Lease, error) {
+	return &Lease{ID: heartbeat.LeaseID, Expiry: time.Now().Add(5 * time.Minute)}, nil
+}
+
+// ExchangeScanToken .....
+func (mr *MockResponder) ExchangeScanToken(exchange ScanTokenExchange) (*ScanCredentials, error) {
+	return &ScanCredentials{
+		Scheme:      "https",
+		Domain:      "mock-hub",
+		Port:        443,
+		BearerToken: "mock-bearer-token",
+		Expiry:      time.Now().Add(5 * time.Minute)}, nil
+}
+
+// RegisterScanner .....
+func (mr *MockResponder) RegisterScanner(registration ScannerRegistration) error {
+	log.Infof("register scanner: %+v", registration)
+	return nil
+}
+
+// GetScanners .....
+func (mr *MockResponder) GetScanners() Scanners {
+	return Scanners{Scanners: map[string]*ModelScanner{}}
+}
+
 // internal use
 
 // PostCommand ...
-func (mr *MockResponder) PostCommand(command *PostCommand) {
+func (mr *MockResponder) PostCommand(command *PostCommand) error {
 	// TODO
+	return nil
 }
 
 // errors
@@ -31,6 +31,7 @@ type Model struct {
 	CoreModel  *CoreModel
 	Config     *ModelConfig
 	Scheduler  *ModelScanScheduler
+	Scanners   map[string]*ModelScanner
 }
 
 // ModelScanScheduler ...
@@ -43,6 +44,58 @@ type CoreModel struct {
 	Images           map[string]*ModelImageInfo
 	ImageScanQueue   []map[string]interface{}
 	ImageTransitions []*ModelImageTransition
+	ImagePrunes      []*ModelImagePrune
+	Rescans          []*ModelRescan
+	FilteredPods     map[string]*ModelFilteredPod
+	FilteredImages   map[string]*ModelFilteredImage
+	// NamespaceQueues is keyed by namespace; "" is for images which aren't
+	// in any pod
+	NamespaceQueues map[string]*ModelNamespaceQueue
+	FairShare       bool
+	Leases          map[string]*ModelLease
+}
+
+// ModelScanner .....
+type ModelScanner struct {
+	Version            string
+	Capabilities       []string
+	TimeOfRegistration string
+	LastSeen           string
+	// InFlightJobs is a map of image sha to the time the scan started
+	InFlightJobs       map[string]string
+	JobsCompleted      int
+	JobsFailed         int
+	AverageJobDuration ModelTime
+}
+
+// ModelLease .....
+type ModelLease struct {
+	Sha                 string
+	HubURL              string
+	ScannerID           string
+	Expiry              string
+	TimeOfLastHeartbeat string
+}
+
+// ModelNamespaceQueue .....
+type ModelNamespaceQueue struct {
+	Depth         int
+	InFlight      int
+	Weight        int
+	InFlightQuota int
+}
+
+// ModelFilteredPod is a pod that's been filtered out, and won't be scanned
+type ModelFilteredPod struct {
+	Pod    *Pod
+	Reason string
+}
+
+// ModelFilteredImage is an image that's been filtered out, and won't be scanned
+type ModelFilteredImage struct {
+	Repository string
+	Tag        string
+	Reason     string
 }
 
 // ModelImageTransition .....
@@ -54,6 +107,25 @@ type ModelImageTransition struct {
 	Time string
 }
 
+// ModelImagePrune .....
+type ModelImagePrune struct {
+	Sha                 string
+	ScanStatus          string
+	TimeOfLastReference string
+	Time                string
+}
+
+// ModelRescan .....
+type ModelRescan struct {
+	Sha       string
+	PodName   string
+	Namespace string
+	Priority  *int
+	Requester string
+	Time      string
+	Shas      []string
+}
+
 // ModelHost ...
 type ModelHost struct {
 	Scheme              string
@@ -61,6 +133,18 @@ type ModelHost struct {
 	Port                int
 	User                string
 	ConcurrentScanLimit int
+	Timings             *ModelHubTimings
+}
+
+// ModelHubTimings ...
+type ModelHubTimings struct {
+	ScanCompletionPause    ModelTime
+	FetchUnknownScansPause ModelTime
+	FetchAllScansPause     ModelTime
+	FullSyncPause          ModelTime
+	GetMetricsPause        ModelTime
+	LoginPause             ModelTime
+	RefreshScanThreshold   ModelTime
 }
 
 // ModelBlackDuckConfig ...
@@ -68,14 +152,55 @@ type ModelBlackDuckConfig struct {
 	Hosts           []*ModelHost
 	ClientTimeout   ModelTime
 	TLSVerification bool
+	CredentialMode  string
+	Timings         *ModelHubTimings
+}
+
+// ModelFilterConfig ...
+type ModelFilterConfig struct {
+	IncludeNamespaces   []string
+	ExcludeNamespaces   []string
+	IncludeRepositories []string
+	ExcludeRepositories []string
+}
+
+// ModelRoutingRule ...
+type ModelRoutingRule struct {
+	Repository string
+	Pool       string
+}
+
+// ModelPriorityRule ...
+type ModelPriorityRule struct {
+	Name       string
+	Namespace  string
+	Repository string
+	Tag        string
+	Source     string
+	Priority   int
+}
+
+// ModelFairShareConfig ...
+type ModelFairShareConfig struct {
+	Enabled              bool
+	DefaultWeight        int
+	Weights              map[string]int
+	DefaultInFlightQuota int
+	InFlightQuotas       map[string]int
 }
 
 // ModelConfig .....
 type ModelConfig struct {
-	Timings   *ModelTimings
-	BlackDuck *ModelBlackDuckConfig
-	Port      int
-	LogLevel  string
+	Timings         *ModelTimings
+	BlackDuck       *ModelBlackDuckConfig
+	Filter          *ModelFilterConfig
+	PriorityRules   []*ModelPriorityRule
+	RoutingRules    []*ModelRoutingRule
+	FairShare       *ModelFairShareConfig
+	Port            int
+	LogLevel        string
+	DataDirectory   string
+	MaxScanAttempts int
 }
 
 // ModelTime ...
@@ -99,20 +224,43 @@ func NewModelTime(duration time.Duration) *ModelTime {
 
 // ModelTimings ...
 type ModelTimings struct {
-	CheckForStalledScansPause ModelTime
-	StalledScanClientTimeout  ModelTime
-	ModelMetricsPause         ModelTime
-	UnknownImagePause         ModelTime
+	CheckForStalledScansPause  ModelTime
+	StalledScanClientTimeout   ModelTime
+	ModelMetricsPause          ModelTime
+	UnknownImagePause          ModelTime
+	ModelSnapshotPause         ModelTime
+	PruneOrphanedImagesPause   ModelTime
+	OrphanedImageGracePeriod   ModelTime
+	RetryFailedScansPause      ModelTime
+	FailedScanRetryBaseDelay   ModelTime
+	FailedScanRetryMaxDelay    ModelTime
+	PriorityAgingInterval      ModelTime
+	ScanLease                  ModelTime
+	CheckForExpiredLeasesPause ModelTime
+	CheckForHubOutagesPause    ModelTime
+	HubOutageRequeue           ModelTime
+	RefreshScansPause          ModelTime
+	ExpireScannersPause        ModelTime
+	ScannerTimeout             ModelTime
 }
 
 // ModelImageInfo .....
 type ModelImageInfo struct {
 	ScanStatus             string
 	TimeOfLastStatusChange string
+	TimeOfLastReference    string
 	ScanResults            interface{}
 	ImageSha               string
 	RepoTags               []*ModelRepoTag
 	Priority               int
+	EffectivePriority      int
+	RequestedPriority      int
+	PriorityRule           string
+	ScannerPool            string
+	HubURL                 string
+	FailureCount           int
+	LastError              string
+	TimeOfLastFailure      string
 }
 
 // ModelRepoTag ...
@@ -127,6 +275,21 @@ type ModelCircuitBreaker struct {
 	NextCheckTime       *time.Time
 	MaxBackoffDuration  ModelTime
 	ConsecutiveFailures int
+	// RetryAfter is set while requests are held off after a 429
+	RetryAfter *time.Time
+	// ErrorCounts is keyed by error class
+	ErrorCounts map[string]int
+	// Policy is the effective policy, after defaults are applied
+	Policy *ModelCircuitBreakerPolicy
+}
+
+// ModelCircuitBreakerPolicy .....
+type ModelCircuitBreakerPolicy struct {
+	BaseDelay        ModelTime
+	MaxDelay         ModelTime
+	Jitter           float64
+	FailureThreshold int
+	HalfOpenProbes   int
 }
 
 // ModelBlackDuck describes a Black Duck client model
@@ -142,7 +305,17 @@ type ModelBlackDuck struct {
 	Errors         []string
 	Status         string
 	CircuitBreaker *ModelCircuitBreaker
-	Host           string
+	// RateLimiter is nil if requests to the Black Duck aren't limited
+	RateLimiter *ModelRateLimiter
+	Host        string
+}
+
+// ModelRateLimiter .....
+type ModelRateLimiter struct {
+	RequestsPerSecond float64
+	Burst             int
+	MaxInFlight       int
+	InFlight          int
 }
 
 // ModelCodeLocation ...
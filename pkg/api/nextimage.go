@@ -24,6 +24,7 @@ package api
 // NextImage .....
 type NextImage struct {
 	ImageSpec *ImageSpec
+	Lease     *Lease
 }
 
 // NewNextImage .....
@@ -21,8 +21,21 @@ under the License.
 
 package api
 
-// PostCommand handles commands.  The values aren't important; only
-// the presence or absence of a key matters.
+// PostCommand handles commands.  For ResetCircuitBreaker, the value isn't
+// important; only the presence or absence of the key matters.
 type PostCommand struct {
 	ResetCircuitBreaker *bool
+	Rescan              *RescanCommand
+}
+
+// RescanCommand puts images back into the scan queue.  Exactly one of Sha,
+// PodName (the pod's qualified name, "<namespace>/<name>") and Namespace
+// should be set.  If Priority is nil, images keep their current priority.
+// Requester identifies who asked for the rescan, for auditing.
+type RescanCommand struct {
+	Sha       string
+	PodName   string
+	Namespace string
+	Priority  *int
+	Requester string
 }
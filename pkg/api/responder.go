@@ -28,6 +28,7 @@ import (
 // Responder interface stores all the methods corresponding to Perceptor api
 type Responder interface {
 	GetModel() (*Model, error)
+	GetFailedScans() FailedScans
 
 	// perceiver
 	AddPod(pod Pod) error
@@ -39,11 +40,15 @@ type Responder interface {
 	UpdateAllImages(allImages AllImages) error
 
 	// scanner
-	GetNextImage() NextImage
+	GetNextImage(request NextImageRequest) NextImage
 	PostFinishScan(job FinishedScanClientJob) error
+	Heartbeat(heartbeat Heartbeat) (*Lease, error)
+	ExchangeScanToken(exchange ScanTokenExchange) (*ScanCredentials, error)
+	RegisterScanner(registration ScannerRegistration) error
+	GetScanners() Scanners
 
 	// internal use
-	PostCommand(commands *PostCommand)
+	PostCommand(commands *PostCommand) error
 
 	// errors
 	NotFound(w http.ResponseWriter, r *http.Request)
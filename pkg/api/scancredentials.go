@@ -0,0 +1,46 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package api
+
+import "time"
+
+// ScanTokenExchange is a scanner's request to trade the scan token it was
+// handed along with an image for the Black Duck credentials it needs to scan
+// that image.
+type ScanTokenExchange struct {
+	ScanToken string
+}
+
+// ScanCredentials are good for a single scan: each scan token can only be
+// exchanged once, and the credentials shouldn't be used after Expiry, which
+// is the expiry of the lease on the image.
+// BearerToken is issued for a Black Duck user whose roles only allow
+// scanning; User and Password are only filled in by legacy responders.
+type ScanCredentials struct {
+	Scheme      string
+	Domain      string
+	Port        int
+	User        string
+	Password    string
+	BearerToken string
+	Expiry      time.Time
+}
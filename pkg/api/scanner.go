@@ -0,0 +1,48 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package api
+
+// ScannerRegistration is sent by a scanner when it starts up.  Capabilities
+// are free-form labels describing what the scanner is able to do.
+type ScannerRegistration struct {
+	ID           string
+	Version      string
+	Capabilities []string
+}
+
+// NextImageRequest is the optional body of a request for the next image.
+// Scanners which leave out their ID aren't tracked.  Scanners in a Pool get
+// images routed to that pool, as well as images which aren't routed
+// anywhere; scanners without one only get the latter.  If WaitSeconds is
+// positive, and there's nothing to scan, the request waits up to that long
+// for an image instead of returning an empty NextImage right away.
+type NextImageRequest struct {
+	ScannerID   string
+	Pool        string
+	WaitSeconds int
+}
+
+// Scanners lists the scanners which have registered, or asked for images,
+// keyed by scanner ID.
+type Scanners struct {
+	Scanners map[string]*ModelScanner
+}
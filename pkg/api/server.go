@@ -53,6 +53,22 @@ func SetupHTTPServer(responder Responder) {
 		}
 	})
 
+	http.HandleFunc("/failedscans", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			failedScans := responder.GetFailedScans()
+			jsonBytes, err := json.MarshalIndent(failedScans, "", "  ")
+			if err != nil {
+				responder.Error(w, r, err, 500)
+				return
+			}
+			header := w.Header()
+			header.Set(http.CanonicalHeaderKey("content-type"), "application/json")
+			fmt.Fprint(w, string(jsonBytes))
+		} else {
+			responder.NotFound(w, r)
+		}
+	})
+
 	// for receiving data from perceiver
 	http.HandleFunc("/pod", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -184,7 +200,15 @@ func SetupHTTPServer(responder Responder) {
 				responder.Error(w, r, err, 400)
 				return
 			}
-			responder.PostCommand(&commands)
+			err = responder.PostCommand(&commands)
+			if _, ok := err.(*NotFoundError); ok {
+				responder.Error(w, r, err, 404)
+				return
+			} else if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			fmt.Fprint(w, "")
 		} else {
 			responder.NotFound(w, r)
 		}
@@ -193,7 +217,21 @@ func SetupHTTPServer(responder Responder) {
 	// for providing data to scanners
 	http.HandleFunc("/nextimage", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
-			nextImage := responder.GetNextImage()
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			// the body is optional, for scanners which don't register
+			var request NextImageRequest
+			if len(body) > 0 {
+				err = json.Unmarshal(body, &request)
+				if err != nil {
+					responder.Error(w, r, err, 400)
+					return
+				}
+			}
+			nextImage := responder.GetNextImage(request)
 			jsonBytes, err := json.MarshalIndent(nextImage, "", "  ")
 			if err != nil {
 				responder.Error(w, r, err, 500)
@@ -220,10 +258,114 @@ func SetupHTTPServer(responder Responder) {
 				responder.Error(w, r, err, 400)
 				return
 			}
-			responder.PostFinishScan(scanResults)
+			err = responder.PostFinishScan(scanResults)
+			if _, ok := err.(*LeaseError); ok {
+				responder.Error(w, r, err, 409)
+				return
+			} else if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
 			fmt.Fprint(w, "")
 		} else {
 			responder.NotFound(w, r)
 		}
 	})
+
+	http.HandleFunc("/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			var heartbeat Heartbeat
+			err = json.Unmarshal(body, &heartbeat)
+			if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			lease, err := responder.Heartbeat(heartbeat)
+			if err != nil {
+				responder.Error(w, r, err, 409)
+				return
+			}
+			jsonBytes, err := json.MarshalIndent(lease, "", "  ")
+			if err != nil {
+				responder.Error(w, r, err, 500)
+				return
+			}
+			header := w.Header()
+			header.Set(http.CanonicalHeaderKey("content-type"), "application/json")
+			fmt.Fprint(w, string(jsonBytes))
+		} else {
+			responder.NotFound(w, r)
+		}
+	})
+
+	http.HandleFunc("/scancredentials", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			var exchange ScanTokenExchange
+			err = json.Unmarshal(body, &exchange)
+			if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			credentials, err := responder.ExchangeScanToken(exchange)
+			if err != nil {
+				responder.Error(w, r, err, 403)
+				return
+			}
+			jsonBytes, err := json.MarshalIndent(credentials, "", "  ")
+			if err != nil {
+				responder.Error(w, r, err, 500)
+				return
+			}
+			header := w.Header()
+			header.Set(http.CanonicalHeaderKey("content-type"), "application/json")
+			fmt.Fprint(w, string(jsonBytes))
+		} else {
+			responder.NotFound(w, r)
+		}
+	})
+
+	http.HandleFunc("/scanners", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			scanners := responder.GetScanners()
+			jsonBytes, err := json.MarshalIndent(scanners, "", "  ")
+			if err != nil {
+				responder.Error(w, r, err, 500)
+				return
+			}
+			header := w.Header()
+			header.Set(http.CanonicalHeaderKey("content-type"), "application/json")
+			fmt.Fprint(w, string(jsonBytes))
+		case "POST":
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			var registration ScannerRegistration
+			err = json.Unmarshal(body, &registration)
+			if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			err = responder.RegisterScanner(registration)
+			if err != nil {
+				responder.Error(w, r, err, 400)
+				return
+			}
+			fmt.Fprint(w, "")
+		default:
+			responder.NotFound(w, r)
+		}
+	})
 }
@@ -22,6 +22,8 @@ under the License.
 package core
 
 import (
+	"fmt"
+
 	"github.com/blackducksoftware/perceptor/pkg/api"
 	"github.com/blackducksoftware/perceptor/pkg/core/model"
 )
@@ -38,7 +40,9 @@ func APIImageToCoreImage(apiImage api.Image) (*model.Image, error) {
 	if apiImage.Priority != nil {
 		priority = *apiImage.Priority
 	}
-	return model.NewImage(apiImage.Repository, apiImage.Tag, sha, priority, apiImage.BlackDuckProjectName, apiImage.BlackDuckProjectVersion), nil
+	image := model.NewImage(apiImage.Repository, apiImage.Tag, sha, priority, apiImage.BlackDuckProjectName, apiImage.BlackDuckProjectVersion)
+	image.ScannerPool = apiImage.ScannerPool
+	return image, nil
 }
 
 // APIContainerToCoreContainer .....
@@ -65,3 +69,33 @@ func APIPodToCorePod(apiPod api.Pod) (*model.Pod, error) {
 	}
 	return model.NewPod(apiPod.Name, apiPod.UID, apiPod.Namespace, containers), nil
 }
+
+// APIRescanCommandToCoreRescan .....
+func APIRescanCommandToCoreRescan(command api.RescanCommand) (*model.Rescan, error) {
+	targets := 0
+	for _, target := range []string{command.Sha, command.PodName, command.Namespace} {
+		if target != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return nil, fmt.Errorf("expected exactly one of Sha, PodName and Namespace, found %d", targets)
+	}
+	rescan := &model.Rescan{
+		PodName:   command.PodName,
+		Namespace: command.Namespace,
+		Priority:  command.Priority,
+		Requester: command.Requester,
+	}
+	if command.Sha != "" {
+		sha, err := model.NewDockerImageSha(command.Sha)
+		if err != nil {
+			return nil, err
+		}
+		rescan.Sha = sha
+	}
+	if rescan.Requester == "" {
+		rescan.Requester = "unknown"
+	}
+	return rescan, nil
+}
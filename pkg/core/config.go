@@ -24,38 +24,289 @@ package core
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"time"
 
 	"github.com/blackducksoftware/perceptor/pkg/api"
+	m "github.com/blackducksoftware/perceptor/pkg/core/model"
+	"github.com/blackducksoftware/perceptor/pkg/hub"
 	log "github.com/sirupsen/logrus"
 )
 
-// Host configures the Black Duck hosts
+// Host configures the Black Duck hosts.  If APIToken is set, it's used to
+// authenticate instead of User and Password.  Scan tokens are exchanged for
+// bearer tokens issued for ScanAPIToken, so it should belong to a Black Duck
+// user whose roles only allow scanning.
 type Host struct {
 	Scheme              string
 	Domain              string // it can be domain name or ip address
 	Port                int
 	User                string
 	Password            string
+	APIToken            string
+	ScanAPIToken        string
 	ConcurrentScanLimit int
+	CircuitBreaker      *CircuitBreakerConfig
+	RateLimit           *RateLimitConfig
+	// CodeLocationPageSize is how many code locations are fetched per request
+	// when syncing with the host; 0 means hub.DefaultCodeLocationPageSize
+	CodeLocationPageSize int
+	// Timings override BlackDuckConfig.Timings for this host
+	Timings *HubTimings
+}
+
+func (host *Host) hubOptions() *hub.Options {
+	return &hub.Options{
+		Username:             host.User,
+		Password:             host.Password,
+		APIToken:             host.APIToken,
+		ConcurrentScanLimit:  host.ConcurrentScanLimit,
+		Timings:              host.Timings.hubTimings(),
+		CircuitBreakerPolicy: host.CircuitBreaker.policy(),
+		RateLimitPolicy:      host.RateLimit.policy(),
+		CodeLocationPageSize: host.CodeLocationPageSize,
+	}
+}
+
+// CircuitBreakerConfig configures the circuit breaker of a Black Duck host.
+// Fields which aren't set take their defaults from
+// hub.DefaultCircuitBreakerPolicy.
+type CircuitBreakerConfig struct {
+	BaseDelaySeconds int
+	MaxDelaySeconds  int
+	// Jitter is a fraction between 0 and 1; 0 turns jitter off
+	Jitter           *float64
+	FailureThreshold int
+	HalfOpenProbes   int
+}
+
+func (config *CircuitBreakerConfig) policy() *hub.CircuitBreakerPolicy {
+	if config == nil {
+		return nil
+	}
+	policy := &hub.CircuitBreakerPolicy{
+		BaseDelay:        time.Duration(config.BaseDelaySeconds) * time.Second,
+		MaxDelay:         time.Duration(config.MaxDelaySeconds) * time.Second,
+		Jitter:           hub.DefaultCircuitBreakerPolicy.Jitter,
+		FailureThreshold: config.FailureThreshold,
+		HalfOpenProbes:   config.HalfOpenProbes,
+	}
+	if config.Jitter != nil {
+		policy.Jitter = *config.Jitter
+	}
+	return policy
+}
+
+// RateLimitConfig limits the requests to a Black Duck host.  If it's nil, or
+// all its fields are 0, requests aren't limited.
+type RateLimitConfig struct {
+	RequestsPerSecond float64
+	Burst             int
+	MaxInFlight       int
+}
+
+func (config *RateLimitConfig) policy() *hub.RateLimitPolicy {
+	if config == nil {
+		return nil
+	}
+	return &hub.RateLimitPolicy{
+		RequestsPerSecond: config.RequestsPerSecond,
+		Burst:             config.Burst,
+		MaxInFlight:       config.MaxInFlight,
+	}
+}
+
+// HubTimings configures the pauses between the regular jobs run against a
+// Black Duck host.  Fields which aren't set take their defaults from
+// hub.DefaultTimings.
+type HubTimings struct {
+	ScanCompletionPauseSeconds    int
+	FetchUnknownScansPauseSeconds int
+	FetchAllScansPauseSeconds     int
+	FullSyncPauseMinutes          int
+	GetMetricsPauseSeconds        int
+	LoginPauseMinutes             int
+	RefreshScanThresholdMinutes   int
+}
+
+// withDefaults returns a copy of the timings, with the fields which aren't set
+// taken from `defaults`.  It returns nil if neither is set.
+func (t *HubTimings) withDefaults(defaults *HubTimings) *HubTimings {
+	if t == nil && defaults == nil {
+		return nil
+	}
+	merged := &HubTimings{}
+	if defaults != nil {
+		*merged = *defaults
+	}
+	if t == nil {
+		return merged
+	}
+	overrides := []struct {
+		value  int
+		target *int
+	}{
+		{t.ScanCompletionPauseSeconds, &merged.ScanCompletionPauseSeconds},
+		{t.FetchUnknownScansPauseSeconds, &merged.FetchUnknownScansPauseSeconds},
+		{t.FetchAllScansPauseSeconds, &merged.FetchAllScansPauseSeconds},
+		{t.FullSyncPauseMinutes, &merged.FullSyncPauseMinutes},
+		{t.GetMetricsPauseSeconds, &merged.GetMetricsPauseSeconds},
+		{t.LoginPauseMinutes, &merged.LoginPauseMinutes},
+		{t.RefreshScanThresholdMinutes, &merged.RefreshScanThresholdMinutes},
+	}
+	for _, override := range overrides {
+		if override.value > 0 {
+			*override.target = override.value
+		}
+	}
+	return merged
+}
+
+// hubTimings converts the timings for the hub package, falling back to
+// hub.DefaultTimings for the fields which aren't set.
+func (t *HubTimings) hubTimings() *hub.Timings {
+	if t == nil {
+		t = &HubTimings{}
+	}
+	duration := func(value int, unit time.Duration, defaultDuration time.Duration) time.Duration {
+		if value <= 0 {
+			return defaultDuration
+		}
+		return time.Duration(value) * unit
+	}
+	defaults := hub.DefaultTimings
+	return &hub.Timings{
+		ScanCompletionPause:    duration(t.ScanCompletionPauseSeconds, time.Second, defaults.ScanCompletionPause),
+		FetchUnknownScansPause: duration(t.FetchUnknownScansPauseSeconds, time.Second, defaults.FetchUnknownScansPause),
+		FetchAllScansPause:     duration(t.FetchAllScansPauseSeconds, time.Second, defaults.FetchAllScansPause),
+		FullSyncPause:          duration(t.FullSyncPauseMinutes, time.Minute, defaults.FullSyncPause),
+		GetMetricsPause:        duration(t.GetMetricsPauseSeconds, time.Second, defaults.GetMetricsPause),
+		LoginPause:             duration(t.LoginPauseMinutes, time.Minute, defaults.LoginPause),
+		RefreshScanThreshold:   duration(t.RefreshScanThresholdMinutes, time.Minute, defaults.RefreshScanThreshold),
+	}
+}
+
+func (t *HubTimings) model() *api.ModelHubTimings {
+	timings := t.hubTimings()
+	return &api.ModelHubTimings{
+		ScanCompletionPause:    *api.NewModelTime(timings.ScanCompletionPause),
+		FetchUnknownScansPause: *api.NewModelTime(timings.FetchUnknownScansPause),
+		FetchAllScansPause:     *api.NewModelTime(timings.FetchAllScansPause),
+		FullSyncPause:          *api.NewModelTime(timings.FullSyncPause),
+		GetMetricsPause:        *api.NewModelTime(timings.GetMetricsPause),
+		LoginPause:             *api.NewModelTime(timings.LoginPause),
+		RefreshScanThreshold:   *api.NewModelTime(timings.RefreshScanThreshold),
+	}
 }
 
 // BlackDuckConfig handles BlackDuck-specific configuration
 type BlackDuckConfig struct {
 	ConnectionsEnvironmentVariableName string
-	TLSVerification                    bool
+	// ConnectionsFilePath, if set, is read instead of the environment
+	// variable, so that hosts can be changed by updating a mounted secret
+	ConnectionsFilePath string
+	TLSVerification     bool
+	// CredentialMode is one of "scanToken" (the default) or "legacy"
+	CredentialMode string
+	// Timings apply to every host, unless the host overrides them
+	Timings *HubTimings
+}
+
+const (
+	// credentialModeScanToken hands scanners a single-use scan token with
+	// each image, which they exchange for a bearer token issued for the
+	// host's ScanAPIToken.  Every host must have a ScanAPIToken.
+	credentialModeScanToken = "scanToken"
+	// credentialModeLegacy hands scanners the Black Duck password with each
+	// image, for scanners which don't know about scan tokens.
+	credentialModeLegacy = "legacy"
+)
+
+// connectionStrings reads the JSON-encoded Black Duck hosts from the
+// connections file if there is one, or else from the environment variable.
+func (config *BlackDuckConfig) connectionStrings() (string, error) {
+	if config.ConnectionsFilePath != "" {
+		bytes, err := ioutil.ReadFile(config.ConnectionsFilePath)
+		if err != nil {
+			return "", fmt.Errorf("cannot find Black Duck hosts: %s", err.Error())
+		}
+		return string(bytes), nil
+	}
+	connectionStrings, ok := os.LookupEnv(config.ConnectionsEnvironmentVariableName)
+	if !ok {
+		return "", fmt.Errorf("cannot find Black Duck hosts: environment variable %s not found", config.ConnectionsEnvironmentVariableName)
+	}
+	return connectionStrings, nil
+}
+
+// checkHostCredentials makes sure that scanners can be handed credentials
+// for every host in the configured credential mode.
+func (config *BlackDuckConfig) checkHostCredentials(hosts map[string]*Host) error {
+	credentialMode, err := config.credentialMode()
+	if err != nil {
+		return err
+	}
+	if credentialMode != credentialModeScanToken {
+		return nil
+	}
+	for name, host := range hosts {
+		if host.ScanAPIToken == "" {
+			return fmt.Errorf("Black Duck host %s has no scan API token, which the %s credential mode requires", name, credentialModeScanToken)
+		}
+	}
+	return nil
+}
+
+// credentialMode returns the configured credential mode, which defaults to
+// scan tokens.
+func (config *BlackDuckConfig) credentialMode() (string, error) {
+	switch config.CredentialMode {
+	case "", credentialModeScanToken:
+		return credentialModeScanToken, nil
+	case credentialModeLegacy:
+		return credentialModeLegacy, nil
+	}
+	return "", fmt.Errorf("invalid credential mode %s: expected %s or %s", config.CredentialMode, credentialModeScanToken, credentialModeLegacy)
 }
 
 // Timings stores all timings configuration that is used for various operations
 type Timings struct {
-	CheckForStalledScansPauseHours int
-	StalledScanClientTimeoutHours  int
-	ModelMetricsPauseSeconds       int
-	UnknownImagePauseMilliseconds  int
-	ClientTimeoutMilliseconds      int
+	CheckForStalledScansPauseHours    int
+	StalledScanClientTimeoutHours     int
+	ModelMetricsPauseSeconds          int
+	UnknownImagePauseMilliseconds     int
+	ClientTimeoutMilliseconds         int
+	ModelSnapshotPauseSeconds         int
+	PruneOrphanedImagesPauseMinutes   int
+	OrphanedImageGracePeriodMinutes   int
+	RetryFailedScansPauseSeconds      int
+	FailedScanRetryBaseSeconds        int
+	FailedScanRetryMaxMinutes         int
+	PriorityAgingIntervalMinutes      int
+	ScanLeaseSeconds                  int
+	CheckForExpiredLeasesPauseSeconds int
+	CheckForHubOutagesPauseSeconds    int
+	HubOutageRequeueMinutes           int
+	RefreshScansPauseMinutes          int
+	ExpireScannersPauseMinutes        int
+	ScannerTimeoutMinutes             int
 }
 
+const (
+	defaultModelSnapshotPause         = 5 * time.Minute
+	defaultPruneOrphanedImagesPause   = 15 * time.Minute
+	defaultOrphanedImageGracePeriod   = 24 * time.Hour
+	defaultRetryFailedScansPause      = 30 * time.Second
+	defaultScanLease                  = 5 * time.Minute
+	defaultCheckForExpiredLeasesPause = 30 * time.Second
+	defaultCheckForHubOutagesPause    = 1 * time.Minute
+	defaultHubOutageRequeue           = 30 * time.Minute
+	defaultRefreshScansPause          = 5 * time.Minute
+	defaultExpireScannersPause        = 5 * time.Minute
+	defaultScannerTimeout             = 1 * time.Hour
+)
+
 // ClientTimeout returns the Black Duck client timeout
 func (t *Timings) ClientTimeout() time.Duration {
 	return time.Duration(t.ClientTimeoutMilliseconds) * time.Millisecond
@@ -81,36 +332,298 @@ func (t *Timings) UnknownImagePause() time.Duration {
 	return time.Duration(t.UnknownImagePauseMilliseconds) * time.Millisecond
 }
 
+// ModelSnapshotPause returns an interval to pause between writing snapshots
+// of the model.  It defaults to 5 minutes if not set.
+func (t *Timings) ModelSnapshotPause() time.Duration {
+	if t.ModelSnapshotPauseSeconds <= 0 {
+		return defaultModelSnapshotPause
+	}
+	return time.Duration(t.ModelSnapshotPauseSeconds) * time.Second
+}
+
+// PruneOrphanedImagesPause returns an interval to pause between looking for
+// orphaned images to prune.  It defaults to 15 minutes if not set.
+func (t *Timings) PruneOrphanedImagesPause() time.Duration {
+	if t.PruneOrphanedImagesPauseMinutes <= 0 {
+		return defaultPruneOrphanedImagesPause
+	}
+	return time.Duration(t.PruneOrphanedImagesPauseMinutes) * time.Minute
+}
+
+// OrphanedImageGracePeriod returns how long an image must go without being
+// referenced before it's pruned.  It defaults to 24 hours if not set.
+func (t *Timings) OrphanedImageGracePeriod() time.Duration {
+	if t.OrphanedImageGracePeriodMinutes <= 0 {
+		return defaultOrphanedImageGracePeriod
+	}
+	return time.Duration(t.OrphanedImageGracePeriodMinutes) * time.Minute
+}
+
+// RetryFailedScansPause returns an interval to pause between looking for
+// failed scans to retry.  It defaults to 30 seconds if not set.
+func (t *Timings) RetryFailedScansPause() time.Duration {
+	if t.RetryFailedScansPauseSeconds <= 0 {
+		return defaultRetryFailedScansPause
+	}
+	return time.Duration(t.RetryFailedScansPauseSeconds) * time.Second
+}
+
+// FailedScanRetryBaseDelay returns how long to wait before retrying an image
+// after its first failed scan.  It defaults to DefaultScanRetryPolicy's if not set.
+func (t *Timings) FailedScanRetryBaseDelay() time.Duration {
+	if t.FailedScanRetryBaseSeconds <= 0 {
+		return m.DefaultScanRetryPolicy.BaseDelay
+	}
+	return time.Duration(t.FailedScanRetryBaseSeconds) * time.Second
+}
+
+// FailedScanRetryMaxDelay returns the longest time to wait before retrying
+// a failed scan.  It defaults to DefaultScanRetryPolicy's if not set.
+func (t *Timings) FailedScanRetryMaxDelay() time.Duration {
+	if t.FailedScanRetryMaxMinutes <= 0 {
+		return m.DefaultScanRetryPolicy.MaxDelay
+	}
+	return time.Duration(t.FailedScanRetryMaxMinutes) * time.Minute
+}
+
+// PriorityAgingInterval returns how long an image must wait in the scan
+// queue for its priority to go up by 1.  Aging is off if it's not set.
+func (t *Timings) PriorityAgingInterval() time.Duration {
+	if t.PriorityAgingIntervalMinutes <= 0 {
+		return 0
+	}
+	return time.Duration(t.PriorityAgingIntervalMinutes) * time.Minute
+}
+
+// ScanLease returns how long a scanner's lease on an image lasts, unless
+// it's renewed by a heartbeat.  It defaults to 5 minutes if not set.
+func (t *Timings) ScanLease() time.Duration {
+	if t.ScanLeaseSeconds <= 0 {
+		return defaultScanLease
+	}
+	return time.Duration(t.ScanLeaseSeconds) * time.Second
+}
+
+// CheckForExpiredLeasesPause returns an interval to pause between looking
+// for expired scan leases.  It defaults to 30 seconds if not set.
+func (t *Timings) CheckForExpiredLeasesPause() time.Duration {
+	if t.CheckForExpiredLeasesPauseSeconds <= 0 {
+		return defaultCheckForExpiredLeasesPause
+	}
+	return time.Duration(t.CheckForExpiredLeasesPauseSeconds) * time.Second
+}
+
+// CheckForHubOutagesPause returns an interval to pause between looking for
+// hubs which have been down for too long.  It defaults to 1 minute if not
+// set.
+func (t *Timings) CheckForHubOutagesPause() time.Duration {
+	if t.CheckForHubOutagesPauseSeconds <= 0 {
+		return defaultCheckForHubOutagesPause
+	}
+	return time.Duration(t.CheckForHubOutagesPauseSeconds) * time.Second
+}
+
+// HubOutageRequeue returns how long a hub can be down before the scans
+// assigned to it are moved back into the scan queue.  It defaults to 30
+// minutes if not set.
+func (t *Timings) HubOutageRequeue() time.Duration {
+	if t.HubOutageRequeueMinutes <= 0 {
+		return defaultHubOutageRequeue
+	}
+	return time.Duration(t.HubOutageRequeueMinutes) * time.Minute
+}
+
+// RefreshScansPause returns an interval to pause between looking for
+// completed scans whose results are due for a refresh; how old results may
+// get is each hub's RefreshScanThreshold.  It defaults to 5 minutes if not
+// set.
+func (t *Timings) RefreshScansPause() time.Duration {
+	if t.RefreshScansPauseMinutes <= 0 {
+		return defaultRefreshScansPause
+	}
+	return time.Duration(t.RefreshScansPauseMinutes) * time.Minute
+}
+
+// ExpireScannersPause returns an interval to pause between looking for
+// scanners which haven't been seen for a while.  It defaults to 5 minutes if
+// not set.
+func (t *Timings) ExpireScannersPause() time.Duration {
+	if t.ExpireScannersPauseMinutes <= 0 {
+		return defaultExpireScannersPause
+	}
+	return time.Duration(t.ExpireScannersPauseMinutes) * time.Minute
+}
+
+// ScannerTimeout returns how long a scanner can go without being seen
+// before it's forgotten.  It defaults to 1 hour if not set.
+func (t *Timings) ScannerTimeout() time.Duration {
+	if t.ScannerTimeoutMinutes <= 0 {
+		return defaultScannerTimeout
+	}
+	return time.Duration(t.ScannerTimeoutMinutes) * time.Minute
+}
+
 // PerceptorConfig stores the perceptor configuration
 type PerceptorConfig struct {
 	Timings     *Timings
 	UseMockMode bool
 	Port        int
+	// DataDirectory is where the model is persisted across restarts.
+	// If empty, the model is kept only in memory.
+	DataDirectory string
+	// MaxScanAttempts is how many times an image's scan may fail before
+	// it's no longer retried.
+	MaxScanAttempts int
+}
+
+// FilterConfig decides which pods -- by namespace -- and which images -- by
+// repository -- get scanned.  Patterns are globs, such as "openshift-*", or
+// regular expressions prefixed with "regex:"; environment variables take
+// comma-separated lists of patterns.  Anything matching an exclude
+// pattern is filtered out; if there are include patterns, anything that
+// doesn't match one of them is filtered out too.
+type FilterConfig struct {
+	IncludeNamespaces   []string
+	ExcludeNamespaces   []string
+	IncludeRepositories []string
+	ExcludeRepositories []string
+}
+
+// PriorityRuleConfig sets the priority of images which match all of its
+// non-empty fields.  Namespace, Repository and Tag are patterns, just like
+// in FilterConfig.  Source is "Pod" for images in running pods, or
+// "ImageStream" for images which were added on their own.
+type PriorityRuleConfig struct {
+	Name       string
+	Namespace  string
+	Repository string
+	Tag        string
+	Source     string
+	Priority   int
+}
+
+// RoutingRuleConfig sends images whose repository matches to a pool of
+// scanners.  Repository is a pattern, just like in FilterConfig.
+type RoutingRuleConfig struct {
+	Repository string
+	Pool       string
+}
+
+// FairShareConfig turns on fair sharing of scanners between namespaces, so
+// that one namespace with lots of new images can't keep all the scanners
+// busy.  Namespaces without a weight or in-flight quota get the defaults.
+// An in-flight quota of 0 means there's no limit.
+type FairShareConfig struct {
+	Enabled              bool
+	DefaultWeight        int
+	Weights              map[string]int
+	DefaultInFlightQuota int
+	InFlightQuotas       map[string]int
 }
 
 // Config stores the input perceptor configuration
 type Config struct {
 	BlackDuck *BlackDuckConfig
 	Perceptor *PerceptorConfig
-	LogLevel  string
+	Filter    *FilterConfig
+	FairShare *FairShareConfig
+	// PriorityRules are checked in order, and the first one that matches an
+	// image sets its priority.  Images which don't match any rule keep the
+	// priority they were sent with.
+	PriorityRules []*PriorityRuleConfig
+	// RoutingRules are checked in order, and the first one that matches an
+	// image decides which scanner pool it goes to, unless it was sent with
+	// a pool of its own.
+	RoutingRules []*RoutingRuleConfig
+	LogLevel     string
 }
 
-// getModelBlackDuckHosts will get the list of Black Duck hosts
-func (config *Config) getModelBlackDuckHosts() ([]*api.ModelHost, error) {
-	connectionStrings, ok := os.LookupEnv(config.BlackDuck.ConnectionsEnvironmentVariableName)
-	if !ok {
-		return nil, fmt.Errorf("cannot find Black Duck hosts: environment variable %s not found", config.BlackDuck.ConnectionsEnvironmentVariableName)
+// filter returns the configured filter, or nil if there isn't one.
+func (config *Config) filter() (*m.Filter, error) {
+	if config.Filter == nil {
+		return nil, nil
+	}
+	return m.NewFilter(config.Filter.IncludeNamespaces, config.Filter.ExcludeNamespaces, config.Filter.IncludeRepositories, config.Filter.ExcludeRepositories)
+}
+
+// priorityPolicy returns the configured rules for computing images' priorities.
+func (config *Config) priorityPolicy() (*m.PriorityPolicy, error) {
+	rules := []*m.PriorityRule{}
+	for _, ruleConfig := range config.PriorityRules {
+		rule, err := m.NewPriorityRule(ruleConfig.Name, ruleConfig.Namespace, ruleConfig.Repository, ruleConfig.Tag, m.ImageSource(ruleConfig.Source), ruleConfig.Priority)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return m.NewPriorityPolicy(rules), nil
+}
+
+// routingPolicy returns the configured rules for routing images to scanner
+// pools.
+func (config *Config) routingPolicy() (*m.RoutingPolicy, error) {
+	rules := []*m.RoutingRule{}
+	for _, ruleConfig := range config.RoutingRules {
+		rule, err := m.NewRoutingRule(ruleConfig.Repository, ruleConfig.Pool)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return m.NewRoutingPolicy(rules), nil
+}
+
+// fairSharePolicy returns nil if fair sharing is turned off.
+func (config *Config) fairSharePolicy() *m.FairSharePolicy {
+	if config.FairShare == nil || !config.FairShare.Enabled {
+		return nil
+	}
+	return &m.FairSharePolicy{
+		DefaultWeight:        config.FairShare.DefaultWeight,
+		Weights:              config.FairShare.Weights,
+		DefaultInFlightQuota: config.FairShare.DefaultInFlightQuota,
+		InFlightQuotas:       config.FairShare.InFlightQuotas,
+	}
+}
+
+// scanRetryPolicy returns the configured policy for retrying failed scans,
+// filling in defaults for anything that isn't set.
+func (config *Config) scanRetryPolicy() *m.ScanRetryPolicy {
+	if config.Perceptor == nil {
+		return m.DefaultScanRetryPolicy
+	}
+	maxAttempts := config.Perceptor.MaxScanAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = m.DefaultScanRetryPolicy.MaxAttempts
+	}
+	timings := config.Perceptor.Timings
+	if timings == nil {
+		timings = &Timings{}
 	}
+	return &m.ScanRetryPolicy{
+		BaseDelay:   timings.FailedScanRetryBaseDelay(),
+		MaxDelay:    timings.FailedScanRetryMaxDelay(),
+		MaxAttempts: maxAttempts,
+	}
+}
 
-	blackduckHosts := map[string]*api.ModelHost{}
-	err := json.Unmarshal([]byte(connectionStrings), &blackduckHosts)
+// getModelBlackDuckHosts will get the list of Black Duck hosts
+func (config *Config) getModelBlackDuckHosts() ([]*api.ModelHost, error) {
+	blackduckHosts, err := getBlackDuckHosts(config)
 	if err != nil {
-		return nil, fmt.Errorf("unable to unmarshall Black Duck hosts due to %+v", err)
+		return nil, err
 	}
 
 	hosts := []*api.ModelHost{}
 	for _, host := range blackduckHosts {
-		hosts = append(hosts, host)
+		hosts = append(hosts, &api.ModelHost{
+			Scheme:              host.Scheme,
+			Domain:              host.Domain,
+			Port:                host.Port,
+			User:                host.User,
+			ConcurrentScanLimit: host.ConcurrentScanLimit,
+			Timings:             host.Timings.model(),
+		})
 	}
 
 	return hosts, nil
@@ -122,19 +635,82 @@ func (config *Config) model() (*api.ModelConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	var filter *api.ModelFilterConfig
+	if config.Filter != nil {
+		filter = &api.ModelFilterConfig{
+			IncludeNamespaces:   config.Filter.IncludeNamespaces,
+			ExcludeNamespaces:   config.Filter.ExcludeNamespaces,
+			IncludeRepositories: config.Filter.IncludeRepositories,
+			ExcludeRepositories: config.Filter.ExcludeRepositories,
+		}
+	}
+	priorityRules := []*api.ModelPriorityRule{}
+	for _, rule := range config.PriorityRules {
+		priorityRules = append(priorityRules, &api.ModelPriorityRule{
+			Name:       rule.Name,
+			Namespace:  rule.Namespace,
+			Repository: rule.Repository,
+			Tag:        rule.Tag,
+			Source:     rule.Source,
+			Priority:   rule.Priority,
+		})
+	}
+	routingRules := []*api.ModelRoutingRule{}
+	for _, rule := range config.RoutingRules {
+		routingRules = append(routingRules, &api.ModelRoutingRule{
+			Repository: rule.Repository,
+			Pool:       rule.Pool,
+		})
+	}
+	var fairShare *api.ModelFairShareConfig
+	if config.FairShare != nil {
+		fairShare = &api.ModelFairShareConfig{
+			Enabled:              config.FairShare.Enabled,
+			DefaultWeight:        config.FairShare.DefaultWeight,
+			Weights:              config.FairShare.Weights,
+			DefaultInFlightQuota: config.FairShare.DefaultInFlightQuota,
+			InFlightQuotas:       config.FairShare.InFlightQuotas,
+		}
+	}
+	credentialMode, err := config.BlackDuck.credentialMode()
+	if err != nil {
+		return nil, err
+	}
 	return &api.ModelConfig{
 		BlackDuck: &api.ModelBlackDuckConfig{
 			Hosts:           hosts,
 			ClientTimeout:   *api.NewModelTime(config.Perceptor.Timings.ClientTimeout()),
 			TLSVerification: config.BlackDuck.TLSVerification,
+			CredentialMode:  credentialMode,
+			Timings:         config.BlackDuck.Timings.model(),
 		},
-		LogLevel: config.LogLevel,
-		Port:     config.Perceptor.Port,
+		Filter:          filter,
+		PriorityRules:   priorityRules,
+		RoutingRules:    routingRules,
+		FairShare:       fairShare,
+		LogLevel:        config.LogLevel,
+		Port:            config.Perceptor.Port,
+		DataDirectory:   config.Perceptor.DataDirectory,
+		MaxScanAttempts: config.scanRetryPolicy().MaxAttempts,
 		Timings: &api.ModelTimings{
-			CheckForStalledScansPause: *api.NewModelTime(config.Perceptor.Timings.CheckForStalledScansPause()),
-			ModelMetricsPause:         *api.NewModelTime(config.Perceptor.Timings.ModelMetricsPause()),
-			StalledScanClientTimeout:  *api.NewModelTime(config.Perceptor.Timings.StalledScanClientTimeout()),
-			UnknownImagePause:         *api.NewModelTime(config.Perceptor.Timings.UnknownImagePause()),
+			CheckForStalledScansPause:  *api.NewModelTime(config.Perceptor.Timings.CheckForStalledScansPause()),
+			ModelMetricsPause:          *api.NewModelTime(config.Perceptor.Timings.ModelMetricsPause()),
+			StalledScanClientTimeout:   *api.NewModelTime(config.Perceptor.Timings.StalledScanClientTimeout()),
+			UnknownImagePause:          *api.NewModelTime(config.Perceptor.Timings.UnknownImagePause()),
+			ModelSnapshotPause:         *api.NewModelTime(config.Perceptor.Timings.ModelSnapshotPause()),
+			PruneOrphanedImagesPause:   *api.NewModelTime(config.Perceptor.Timings.PruneOrphanedImagesPause()),
+			OrphanedImageGracePeriod:   *api.NewModelTime(config.Perceptor.Timings.OrphanedImageGracePeriod()),
+			RetryFailedScansPause:      *api.NewModelTime(config.Perceptor.Timings.RetryFailedScansPause()),
+			FailedScanRetryBaseDelay:   *api.NewModelTime(config.Perceptor.Timings.FailedScanRetryBaseDelay()),
+			FailedScanRetryMaxDelay:    *api.NewModelTime(config.Perceptor.Timings.FailedScanRetryMaxDelay()),
+			PriorityAgingInterval:      *api.NewModelTime(config.Perceptor.Timings.PriorityAgingInterval()),
+			ScanLease:                  *api.NewModelTime(config.Perceptor.Timings.ScanLease()),
+			CheckForExpiredLeasesPause: *api.NewModelTime(config.Perceptor.Timings.CheckForExpiredLeasesPause()),
+			CheckForHubOutagesPause:    *api.NewModelTime(config.Perceptor.Timings.CheckForHubOutagesPause()),
+			HubOutageRequeue:           *api.NewModelTime(config.Perceptor.Timings.HubOutageRequeue()),
+			RefreshScansPause:          *api.NewModelTime(config.Perceptor.Timings.RefreshScansPause()),
+			ExpireScannersPause:        *api.NewModelTime(config.Perceptor.Timings.ExpireScannersPause()),
+			ScannerTimeout:             *api.NewModelTime(config.Perceptor.Timings.ScannerTimeout()),
 		},
 	}, nil
 }
@@ -70,14 +70,48 @@ func (cm *ConfigManager) GetConfig() (*Config, error) {
 
 		viper.BindEnv("Perceptor.Port")
 		viper.BindEnv("Perceptor.UseMockMode")
+		viper.BindEnv("Perceptor.DataDirectory")
+		viper.BindEnv("Perceptor.MaxScanAttempts")
 		viper.BindEnv("Perceptor.Timings.CheckForStalledScansPauseHours")
 		viper.BindEnv("Perceptor.Timings.ModelMetricsPauseSeconds")
 		viper.BindEnv("Perceptor.Timings.StalledScanClientTimeoutHours")
 		viper.BindEnv("Perceptor.Timings.UnknownImagePauseMilliseconds")
 		viper.BindEnv("Perceptor.Timings.ClientTimeoutMilliseconds")
+		viper.BindEnv("Perceptor.Timings.ModelSnapshotPauseSeconds")
+		viper.BindEnv("Perceptor.Timings.PruneOrphanedImagesPauseMinutes")
+		viper.BindEnv("Perceptor.Timings.OrphanedImageGracePeriodMinutes")
+		viper.BindEnv("Perceptor.Timings.RetryFailedScansPauseSeconds")
+		viper.BindEnv("Perceptor.Timings.FailedScanRetryBaseSeconds")
+		viper.BindEnv("Perceptor.Timings.FailedScanRetryMaxMinutes")
+		viper.BindEnv("Perceptor.Timings.PriorityAgingIntervalMinutes")
+		viper.BindEnv("Perceptor.Timings.ScanLeaseSeconds")
+		viper.BindEnv("Perceptor.Timings.CheckForExpiredLeasesPauseSeconds")
+		viper.BindEnv("Perceptor.Timings.CheckForHubOutagesPauseSeconds")
+		viper.BindEnv("Perceptor.Timings.HubOutageRequeueMinutes")
+		viper.BindEnv("Perceptor.Timings.RefreshScansPauseMinutes")
+		viper.BindEnv("Perceptor.Timings.ExpireScannersPauseMinutes")
+		viper.BindEnv("Perceptor.Timings.ScannerTimeoutMinutes")
 
 		viper.BindEnv("Blackduck.ConnectionsEnvironmentVariableName")
+		viper.BindEnv("Blackduck.ConnectionsFilePath")
 		viper.BindEnv("Blackduck.TLSVerification")
+		viper.BindEnv("Blackduck.CredentialMode")
+		viper.BindEnv("Blackduck.Timings.ScanCompletionPauseSeconds")
+		viper.BindEnv("Blackduck.Timings.FetchUnknownScansPauseSeconds")
+		viper.BindEnv("Blackduck.Timings.FetchAllScansPauseSeconds")
+		viper.BindEnv("Blackduck.Timings.FullSyncPauseMinutes")
+		viper.BindEnv("Blackduck.Timings.GetMetricsPauseSeconds")
+		viper.BindEnv("Blackduck.Timings.LoginPauseMinutes")
+		viper.BindEnv("Blackduck.Timings.RefreshScanThresholdMinutes")
+
+		viper.BindEnv("Filter.IncludeNamespaces")
+		viper.BindEnv("Filter.ExcludeNamespaces")
+		viper.BindEnv("Filter.IncludeRepositories")
+		viper.BindEnv("Filter.ExcludeRepositories")
+
+		viper.BindEnv("FairShare.Enabled")
+		viper.BindEnv("FairShare.DefaultWeight")
+		viper.BindEnv("FairShare.DefaultInFlightQuota")
 
 		viper.BindEnv("LogLevel")
 
@@ -34,5 +34,6 @@ func TestModel(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunTestPerceptor()
 	RunTestMetrics()
+	RunTestHubManager()
 	RunSpecs(t, "core suite")
 }
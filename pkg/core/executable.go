@@ -78,7 +78,7 @@ func RunPerceptor(configPath string) {
 	}
 
 	manager := NewHubManager(newHub, stop)
-	scanScheduler := &ScanScheduler{HubManager: manager}
+	scanScheduler := NewScanScheduler(manager)
 	perceptor, err := NewPerceptor(config, config.Perceptor.Timings, scanScheduler, manager)
 	if err != nil {
 		log.Errorf("unable to instantiate percepter: %s", err.Error())
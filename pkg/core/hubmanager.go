@@ -23,6 +23,7 @@ package core
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
 	"time"
 
@@ -33,28 +34,28 @@ import (
 
 var commonMistakesRegex = regexp.MustCompile("(http|://|:\\d+)")
 
-type hubClientCreator func(scheme string, host string, port int, username string, password string, concurrentScanLimit int) (*hub.Hub, error)
+type hubClientCreator func(host *Host) (*hub.Hub, error)
 
 // createMockHubClient creates the mock Black Duck client
-func createMockHubClient(scheme string, host string, port int, username string, password string, concurrentScanLimit int) (*hub.Hub, error) {
+func createMockHubClient(host *Host) (*hub.Hub, error) {
 	mockRawClient := hub.NewMockRawClient(false, []string{})
-	return hub.NewHub(username, password, host, concurrentScanLimit, mockRawClient, hub.DefaultTimings), nil
+	return hub.NewHub(host.Domain, mockRawClient, host.hubOptions()), nil
 }
 
 // createHubClient creates the Black Duck http client
 func createHubClient(httpTimeout time.Duration) hubClientCreator {
-	return func(scheme string, host string, port int, username string, password string, concurrentScanLimit int) (*hub.Hub, error) {
-		potentialProblems := commonMistakesRegex.FindAllString(host, -1)
+	return func(host *Host) (*hub.Hub, error) {
+		potentialProblems := commonMistakesRegex.FindAllString(host.Domain, -1)
 		if len(potentialProblems) > 0 {
-			log.Warnf("Hub host %s may be invalid, potential problems are: %s", host, potentialProblems)
+			log.Warnf("Hub host %s may be invalid, potential problems are: %s", host.Domain, potentialProblems)
 		}
-		baseURL := fmt.Sprintf("%s://%s:%d", scheme, host, port)
+		baseURL := fmt.Sprintf("%s://%s:%d", host.Scheme, host.Domain, host.Port)
 		log.Debugf("creating Black Duck client with base URL: %s", baseURL)
-		rawClient, err := hubclient.NewWithSession(baseURL, hubclient.HubClientDebugTimings, httpTimeout)
+		rawClient, err := hub.NewTokenClient(baseURL, hubclient.HubClientDebugTimings, httpTimeout)
 		if err != nil {
 			return nil, err
 		}
-		return hub.NewHub(username, password, host, concurrentScanLimit, rawClient, hub.DefaultTimings), nil
+		return hub.NewHub(host.Domain, rawClient, host.hubOptions()), nil
 	}
 }
 
@@ -64,80 +65,243 @@ type Update struct {
 	Update hub.Update
 }
 
+// HubEventType distinguishes hubs being added to the HubManager from hubs
+// being removed from it.
+type HubEventType int
+
+// .....
+const (
+	HubAdded   HubEventType = iota
+	HubRemoved HubEventType = iota
+)
+
+// String .....
+func (t HubEventType) String() string {
+	switch t {
+	case HubAdded:
+		return "HubAdded"
+	case HubRemoved:
+		return "HubRemoved"
+	}
+	panic(fmt.Errorf("invalid HubEventType value: %d", int(t)))
+}
+
+// HubEvent is published whenever a hub is added to or removed from the
+// HubManager.  A hub which is recreated -- because its scheme or port changed
+// -- produces a HubRemoved followed by a HubAdded.
+type HubEvent struct {
+	Type   HubEventType
+	HubURL string
+}
+
+type hubManagerAction struct {
+	name  string
+	apply func() error
+}
+
 // HubManagerInterface includes all methods related to setup the Black Duck
 type HubManagerInterface interface {
 	SetHubs(hubs map[string]*Host)
+	SetClientTimeout(timeout time.Duration)
 	HubClients() map[string]*hub.Hub
 	StartScanClient(hubURL string, scanName string) error
 	FinishScanClient(hubURL string, scanName string, err error) error
 	ScanResults() map[string]map[string]*hub.Scan
 	Updates() <-chan *Update
+	Events() <-chan *HubEvent
 }
 
-// HubManager stores the Black Duck Manager configuration
+// HubManager stores the Black Duck Manager configuration.  Its state is only
+// touched from its action loop, so it's safe for concurrent use.
 type HubManager struct {
 	newHub hubClientCreator
 	//
 	stop    <-chan struct{}
 	updates chan *Update
+	events  chan *HubEvent
+	publish chan *HubEvent
+	actions chan *hubManagerAction
 	//
-	hubs                  map[string]*hub.Hub
-	didFetchScanResults   chan *hub.ScanResults
-	didFetchCodeLocations chan []string
+	hubs map[string]*hub.Hub
+	// hosts is the configuration each hub was created or last updated with
+	hosts map[string]Host
+	// clientTimeout, if set, overrides the timeout hubs were created with
+	clientTimeout time.Duration
 }
 
 // NewHubManager returns the new Black Duck Manager configuration
 func NewHubManager(newHub hubClientCreator, stop <-chan struct{}) *HubManager {
-	// TODO needs to be made concurrent-safe
-	return &HubManager{
-		newHub:                newHub,
-		stop:                  stop,
-		updates:               make(chan *Update),
-		hubs:                  map[string]*hub.Hub{},
-		didFetchScanResults:   make(chan *hub.ScanResults),
-		didFetchCodeLocations: make(chan []string)}
+	hm := &HubManager{
+		newHub:  newHub,
+		stop:    stop,
+		updates: make(chan *Update),
+		events:  make(chan *HubEvent),
+		publish: make(chan *HubEvent),
+		actions: make(chan *hubManagerAction),
+		hubs:    map[string]*hub.Hub{},
+		hosts:   map[string]Host{}}
+	// action processing
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case action := <-hm.actions:
+				recordEvent("hubManager", action.name)
+				err := action.apply()
+				if err != nil {
+					log.Errorf("while processing hub manager action %s: %s", action.name, err.Error())
+				}
+			}
+		}
+	}()
+	go hm.publishEvents()
+	return hm
 }
 
-// SetHubs setup the Black Duck
-func (hm *HubManager) SetHubs(hubs map[string]*Host) {
-	hubsToCreate := map[string]bool{}
-	for hubURL := range hubs {
-		if _, ok := hm.hubs[hubURL]; !ok {
-			hubsToCreate[hubURL] = true
+// send hands `action` to the action loop, returning false if the HubManager
+// has been stopped.
+func (hm *HubManager) send(action *hubManagerAction) bool {
+	select {
+	case <-hm.stop:
+		return false
+	case hm.actions <- action:
+		return true
+	}
+}
+
+// publishEvents queues up events from the action loop, so that it never
+// blocks on slow consumers, and hands them out in order.
+func (hm *HubManager) publishEvents() {
+	pending := []*HubEvent{}
+	for {
+		var events chan *HubEvent
+		var next *HubEvent
+		if len(pending) > 0 {
+			events = hm.events
+			next = pending[0]
+		}
+		select {
+		case <-hm.stop:
+			return
+		case event := <-hm.publish:
+			pending = append(pending, event)
+		case events <- next:
+			pending = pending[1:]
 		}
 	}
-	// 1. create new hubs
-	// TODO handle retries and failures intelligently
-	go func() {
-		for host := range hubsToCreate {
-			if _, ok := hm.hubs[host]; !ok {
-				hub := hubs[host]
-				err := hm.create(hub.Scheme, hub.Domain, hub.Port, hub.User, hub.Password, hub.ConcurrentScanLimit)
-				if err != nil {
-					log.Errorf("unable to create Hub client for %s: %s", hub.Domain, err.Error())
+}
+
+func (hm *HubManager) didChangeHub(eventType HubEventType, hubURL string) {
+	log.Infof("%s: %s", eventType.String(), hubURL)
+	select {
+	case <-hm.stop:
+	case hm.publish <- &HubEvent{Type: eventType, HubURL: hubURL}:
+	}
+}
+
+// SetHubs creates hubs which are new, stops hubs which were removed, and
+// applies changes to the credentials, concurrent scan limits and circuit
+// breaker policies of the rest.
+func (hm *HubManager) SetHubs(hubs map[string]*Host) {
+	done := make(chan struct{})
+	ok := hm.send(&hubManagerAction{"setHubs", func() error {
+		defer close(done)
+		errs := []error{}
+		// 1. delete removed hubs
+		for hubURL := range hm.hubs {
+			if _, ok := hubs[hubURL]; !ok {
+				hm.delete(hubURL)
+			}
+		}
+		// 2. update or recreate existing hubs, and create new ones
+		// TODO handle retries and failures intelligently
+		for hubURL, host := range hubs {
+			if _, ok := hm.hubs[hubURL]; ok {
+				if hm.updateHub(hubURL, host) {
+					continue
 				}
+				log.Infof("recreating hub %s, since its scheme or port changed", hubURL)
+				hm.delete(hubURL)
+			}
+			err := hm.create(hubURL, host)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("unable to create Hub client for %s: %s", host.Domain, err.Error()))
 			}
 		}
-	}()
-	// 2. delete removed hubs
-	for hubURL, hub := range hm.hubs {
-		if _, ok := hubs[hubURL]; !ok {
-			hub.Stop()
-			delete(hm.hubs, hubURL)
+		if len(errs) > 0 {
+			return fmt.Errorf("%+v", errs)
 		}
+		return nil
+	}})
+	if ok {
+		<-done
+	}
+}
+
+// updateHub applies changes in `host` to the live hub, keeping its model.
+// It returns false if the hub has to be recreated instead.
+func (hm *HubManager) updateHub(hubURL string, host *Host) bool {
+	previous := hm.hosts[hubURL]
+	if previous.Scheme != host.Scheme || previous.Port != host.Port {
+		return false
+	}
+	hub := hm.hubs[hubURL]
+	if previous.User != host.User || previous.Password != host.Password || previous.APIToken != host.APIToken {
+		log.Infof("updating credentials of hub %s", hubURL)
+		hub.SetCredentials(host.User, host.Password, host.APIToken)
+	}
+	if previous.ConcurrentScanLimit != host.ConcurrentScanLimit {
+		hub.SetConcurrentScanLimit(host.ConcurrentScanLimit)
+	}
+	if !reflect.DeepEqual(previous.CircuitBreaker, host.CircuitBreaker) {
+		log.Infof("updating circuit breaker policy of hub %s", hubURL)
+		hub.SetCircuitBreakerPolicy(host.CircuitBreaker.policy())
 	}
+	if !reflect.DeepEqual(previous.RateLimit, host.RateLimit) {
+		log.Infof("updating rate limit of hub %s", hubURL)
+		hub.SetRateLimitPolicy(host.RateLimit.policy())
+	}
+	if previous.CodeLocationPageSize != host.CodeLocationPageSize {
+		hub.SetCodeLocationPageSize(host.CodeLocationPageSize)
+	}
+	if !reflect.DeepEqual(previous.Timings, host.Timings) {
+		log.Infof("updating timings of hub %s", hubURL)
+		hub.SetTimings(host.Timings.hubTimings())
+	}
+	hm.hosts[hubURL] = *host
+	return true
+}
+
+// SetClientTimeout changes the timeout of the requests to every hub,
+// including hubs which are created later.
+func (hm *HubManager) SetClientTimeout(timeout time.Duration) {
+	hm.send(&hubManagerAction{"setClientTimeout", func() error {
+		if timeout == hm.clientTimeout {
+			return nil
+		}
+		hm.clientTimeout = timeout
+		for _, hub := range hm.hubs {
+			hub.SetTimeout(timeout)
+		}
+		return nil
+	}})
 }
 
 // create creates the Black Duck instance
-func (hm *HubManager) create(scheme string, host string, port int, username string, password string, concurrentScanLimit int) error {
-	if _, ok := hm.hubs[host]; ok {
-		return fmt.Errorf("cannot create hub %s: already exists", host)
+func (hm *HubManager) create(hubURL string, host *Host) error {
+	if _, ok := hm.hubs[hubURL]; ok {
+		return fmt.Errorf("cannot create hub %s: already exists", hubURL)
 	}
-	hubClient, err := hm.newHub(scheme, host, port, username, password, concurrentScanLimit)
+	hubClient, err := hm.newHub(host)
 	if err != nil {
 		return err
 	}
-	hm.hubs[host] = hubClient
+	if hm.clientTimeout > 0 {
+		hubClient.SetTimeout(hm.clientTimeout)
+	}
+	hm.hubs[hubURL] = hubClient
+	hm.hosts[hubURL] = *host
 	go func() {
 		stop := hubClient.StopCh()
 		updates := hubClient.Updates()
@@ -146,28 +310,70 @@ func (hm *HubManager) create(scheme string, host string, port int, username stri
 			case <-stop:
 				return
 			case nextUpdate := <-updates:
-				hm.updates <- &Update{HubURL: host, Update: nextUpdate}
+				select {
+				case <-stop:
+					return
+				case hm.updates <- &Update{HubURL: hubURL, Update: nextUpdate}:
+				}
 			}
 		}
 	}()
+	hm.didChangeHub(HubAdded, hubURL)
 	return nil
 }
 
+// delete stops the hub and forgets about it
+func (hm *HubManager) delete(hubURL string) {
+	hm.hubs[hubURL].Stop()
+	delete(hm.hubs, hubURL)
+	delete(hm.hosts, hubURL)
+	hm.didChangeHub(HubRemoved, hubURL)
+}
+
 // Updates returns a read-only channel of the combined update stream of each hub.
 func (hm *HubManager) Updates() <-chan *Update {
 	return hm.updates
 }
 
-// HubClients returns the list of Black Duck instance
+// Events returns a read-only channel of hubs being added and removed, in the
+// order it happened.
+func (hm *HubManager) Events() <-chan *HubEvent {
+	return hm.events
+}
+
+// HubClients returns a snapshot of the Black Duck instances.  Hubs in the
+// snapshot may be removed -- and stopped -- at any time, after which they
+// answer queries with zero values.
 func (hm *HubManager) HubClients() map[string]*hub.Hub {
-	return hm.hubs
+	ch := make(chan map[string]*hub.Hub)
+	ok := hm.send(&hubManagerAction{"getHubClients", func() error {
+		hubs := map[string]*hub.Hub{}
+		for hubURL, hub := range hm.hubs {
+			hubs[hubURL] = hub
+		}
+		ch <- hubs
+		return nil
+	}})
+	if !ok {
+		return map[string]*hub.Hub{}
+	}
+	return <-ch
+}
+
+// getHub returns the hub for `hubURL`, or an error if there isn't one.
+func (hm *HubManager) getHub(hubURL string) (*hub.Hub, error) {
+	hub, ok := hm.HubClients()[hubURL]
+	if !ok {
+		return nil, fmt.Errorf("hub %s not found", hubURL)
+	}
+	return hub, nil
 }
 
 // StartScanClient starts the Black Duck client
 func (hm *HubManager) StartScanClient(hubURL string, scanName string) error {
-	hub, ok := hm.hubs[hubURL]
-	if !ok {
-		return fmt.Errorf("hub %s not found", hubURL)
+	hub, err := hm.getHub(hubURL)
+	if err != nil {
+		return err
 	}
 	hub.StartScanClient(scanName)
 	return nil
@@ -176,18 +382,20 @@ func (hm *HubManager) StartScanClient(hubURL string, scanName string) error {
 // FinishScanClient tells the appropriate hub client to start polling for
 // scan completion.
 func (hm *HubManager) FinishScanClient(hubURL string, scanName string, scanErr error) error {
-	hub, ok := hm.hubs[hubURL]
-	if !ok {
-		return fmt.Errorf("hub %s not found", hubURL)
+	hub, err := hm.getHub(hubURL)
+	if err != nil {
+		return err
 	}
 	hub.FinishScanClient(scanName, scanErr)
 	return nil
 }
 
-// ScanResults returns the scan results
+// ScanResults returns the scan results of a snapshot of the hubs.  The hubs
+// are queried outside of the action loop, since each may take a while to
+// answer.
 func (hm *HubManager) ScanResults() map[string]map[string]*hub.Scan {
 	allScanResults := map[string]map[string]*hub.Scan{}
-	for hubURL, hub := range hm.hubs {
+	for hubURL, hub := range hm.HubClients() {
 		// TODO could cache to avoid blocking
 		allScanResults[hubURL] = <-hub.ScanResults()
 	}
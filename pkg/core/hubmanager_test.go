@@ -0,0 +1,209 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+	m "github.com/blackducksoftware/perceptor/pkg/core/model"
+	"github.com/blackducksoftware/perceptor/pkg/hub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func receiveHubEvents(events <-chan *HubEvent, count int) map[HubEvent]int {
+	received := map[HubEvent]int{}
+	for i := 0; i < count; i++ {
+		var event *HubEvent
+		Eventually(events, 5*time.Second).Should(Receive(&event))
+		received[*event]++
+	}
+	return received
+}
+
+func RunTestHubManager() {
+	Describe("HubManager", func() {
+		It("should publish hubs being added and removed", func() {
+			stop := make(chan struct{})
+			defer close(stop)
+			manager := NewHubManager(createMockHubClient, stop)
+			events := manager.Events()
+
+			manager.SetHubs(map[string]*Host{
+				"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1},
+				"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1},
+			})
+			Expect(receiveHubEvents(events, 2)).To(Equal(map[HubEvent]int{
+				{Type: HubAdded, HubURL: "hub1"}: 1,
+				{Type: HubAdded, HubURL: "hub2"}: 1,
+			}))
+			Expect(manager.HubClients()).To(HaveLen(2))
+
+			// removing hub1, and changing the port of hub2
+			manager.SetHubs(map[string]*Host{"hub2": {Scheme: "https", Domain: "hub2", Port: 443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1}})
+			Expect(receiveHubEvents(events, 3)).To(Equal(map[HubEvent]int{
+				{Type: HubRemoved, HubURL: "hub1"}: 1,
+				{Type: HubRemoved, HubURL: "hub2"}: 1,
+				{Type: HubAdded, HubURL: "hub2"}:   1,
+			}))
+			Consistently(events, 200*time.Millisecond).ShouldNot(Receive())
+			Expect(manager.HubClients()).To(HaveLen(1))
+		})
+
+		It("should answer with zero values for hubs which were removed", func() {
+			stop := make(chan struct{})
+			defer close(stop)
+			manager := NewHubManager(createMockHubClient, stop)
+			manager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1}})
+			hub1 := manager.HubClients()["hub1"]
+			Expect(hub1.ConcurrentScanLimit()).To(Equal(1))
+
+			manager.SetHubs(map[string]*Host{})
+			Expect(hub1.ConcurrentScanLimit()).To(Equal(0))
+			Expect(<-hub1.InProgressScans()).To(BeEmpty())
+			Expect(manager.StartScanClient("hub1", image1.Sha)).NotTo(BeNil())
+		})
+
+		It("should apply circuit breaker policies to live hubs", func() {
+			stop := make(chan struct{})
+			defer close(stop)
+			manager := NewHubManager(createMockHubClient, stop)
+			manager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1}})
+			hub1 := manager.HubClients()["hub1"]
+			policy := (<-hub1.Model()).CircuitBreaker.Policy
+			Expect(policy.FailureThreshold).To(Equal(hub.DefaultCircuitBreakerPolicy.FailureThreshold))
+			Expect(policy.Jitter).To(Equal(hub.DefaultCircuitBreakerPolicy.Jitter))
+
+			noJitter := 0.0
+			manager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1, CircuitBreaker: &CircuitBreakerConfig{
+				MaxDelaySeconds:  60,
+				Jitter:           &noJitter,
+				FailureThreshold: 5,
+				HalfOpenProbes:   2,
+			}}})
+			Expect(manager.HubClients()["hub1"]).To(BeIdenticalTo(hub1))
+			policy = (<-hub1.Model()).CircuitBreaker.Policy
+			Expect(policy.BaseDelay.Seconds).To(Equal(hub.DefaultCircuitBreakerPolicy.BaseDelay.Seconds()))
+			Expect(policy.MaxDelay.Seconds).To(Equal(float64(60)))
+			Expect(policy.Jitter).To(Equal(float64(0)))
+			Expect(policy.FailureThreshold).To(Equal(5))
+			Expect(policy.HalfOpenProbes).To(Equal(2))
+		})
+
+		It("should update the rate limit of a hub without recreating it", func() {
+			stop := make(chan struct{})
+			defer close(stop)
+			manager := NewHubManager(createMockHubClient, stop)
+			manager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1}})
+			hub1 := manager.HubClients()["hub1"]
+			Expect((<-hub1.Model()).RateLimiter).To(BeNil())
+
+			manager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1, RateLimit: &RateLimitConfig{
+				RequestsPerSecond: 5,
+				MaxInFlight:       3,
+			}}})
+			Expect(manager.HubClients()["hub1"]).To(BeIdenticalTo(hub1))
+			rateLimiter := (<-hub1.Model()).RateLimiter
+			Expect(rateLimiter.RequestsPerSecond).To(Equal(float64(5)))
+			Expect(rateLimiter.Burst).To(Equal(1))
+			Expect(rateLimiter.MaxInFlight).To(Equal(3))
+
+			manager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1}})
+			Expect((<-hub1.Model()).RateLimiter).To(BeNil())
+		})
+
+		It("should handle concurrent hub churn under scan load", func() {
+			pcp := newPerceptor()
+			images := []api.Image{image1, image2, image3, image4, image5}
+			pcp.UpdateAllImages(api.AllImages{Images: images})
+			hostSets := []map[string]*Host{
+				{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 2}},
+				{
+					"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 1},
+					"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 2},
+				},
+				{"hub2": {Scheme: "https", Domain: "hub2", Port: 443, User: "mock-username", Password: "mock-password", ConcurrentScanLimit: 3}},
+				{},
+			}
+
+			stopLoad := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 40; i++ {
+					pcp.hubManager.SetHubs(hostSets[i%len(hostSets)])
+					time.Sleep(10 * time.Millisecond)
+				}
+				close(stopLoad)
+			}()
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stopLoad:
+						return
+					default:
+					}
+					next := pcp.GetNextImage(api.NextImageRequest{})
+					if next.Lease == nil {
+						continue
+					}
+					pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: next.ImageSpec, LeaseID: next.Lease.ID})
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stopLoad:
+						return
+					default:
+					}
+					pcp.hubManager.ScanResults()
+					for _, hub := range pcp.hubManager.HubClients() {
+						hub.ConcurrentScanLimit()
+						<-hub.Model()
+					}
+				}
+			}()
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			Eventually(done, 30*time.Second).Should(BeClosed())
+
+			// once the churn settles, the images can still be scanned
+			pcp.hubManager.SetHubs(hostSets[1])
+			Eventually(func() int {
+				next := pcp.GetNextImage(api.NextImageRequest{})
+				if next.Lease != nil {
+					pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: next.ImageSpec, LeaseID: next.Lease.ID})
+				}
+				return len(pcp.model.GetImages(m.ScanStatusInQueue)) + len(pcp.model.GetImages(m.ScanStatusRunningScanClient))
+			}, 10*time.Second, 50*time.Millisecond).Should(Equal(0))
+		})
+	})
+}
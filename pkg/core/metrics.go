@@ -47,6 +47,7 @@ var imagePolicyViolationsGauge *prometheus.GaugeVec
 var imageVulnerabilitiesGauge *prometheus.GaugeVec
 
 var eventCounter *prometheus.CounterVec
+var timingChangeCounter *prometheus.CounterVec
 
 // prometheus' terminology is so confusing ... a histogram isn't a histogram.  sometimes.
 var statusHistogram *prometheus.GaugeVec
@@ -55,13 +56,18 @@ func recordEvent(subsystem string, name string) {
 	eventCounter.With(prometheus.Labels{"subsystem": subsystem, "name": name}).Inc()
 }
 
+func recordTimingChange(name string) {
+	timingChangeCounter.With(prometheus.Labels{"name": name}).Inc()
+}
+
 func recordModelMetrics(modelMetrics *model.Metrics) {
 	keys := []model.ScanStatus{
 		model.ScanStatusUnknown,
 		model.ScanStatusInQueue,
 		model.ScanStatusRunningScanClient,
 		model.ScanStatusRunningHubScan,
-		model.ScanStatusComplete}
+		model.ScanStatusComplete,
+		model.ScanStatusFailed}
 	for _, key := range keys {
 		val := modelMetrics.ScanStatusCounts[key]
 		status := fmt.Sprintf("image_status_%s", key.String())
@@ -70,6 +76,7 @@ func recordModelMetrics(modelMetrics *model.Metrics) {
 
 	statusGauge.With(prometheus.Labels{"name": "number_of_pods"}).Set(float64(modelMetrics.NumberOfPods))
 	statusGauge.With(prometheus.Labels{"name": "number_of_images"}).Set(float64(modelMetrics.NumberOfImages))
+	statusGauge.With(prometheus.Labels{"name": "max_queue_wait_seconds"}).Set(modelMetrics.MaxQueueWait.Seconds())
 
 	// number of containers per pod (as a histgram, but not a prometheus histogram ???)
 	for numberOfContainers, numberOfPods := range modelMetrics.ContainerCounts {
@@ -149,6 +156,30 @@ func recordGetScanResults() {
 	handledHTTPRequest.With(prometheus.Labels{"path": "scanresults", "method": "GET", "code": "200"}).Inc()
 }
 
+func recordWaitingNextImageRequests(count int) {
+	statusGauge.With(prometheus.Labels{"name": "waiting_next_image_requests"}).Set(float64(count))
+}
+
+func recordHeartbeat() {
+	handledHTTPRequest.With(prometheus.Labels{"path": "heartbeat", "method": "POST", "code": "200"}).Inc()
+}
+
+func recordExchangeScanToken() {
+	handledHTTPRequest.With(prometheus.Labels{"path": "scancredentials", "method": "POST", "code": "200"}).Inc()
+}
+
+func recordRegisterScanner() {
+	handledHTTPRequest.With(prometheus.Labels{"path": "scanners", "method": "POST", "code": "200"}).Inc()
+}
+
+func recordGetScanners() {
+	handledHTTPRequest.With(prometheus.Labels{"path": "scanners", "method": "GET", "code": "200"}).Inc()
+}
+
+func recordGetFailedScans() {
+	handledHTTPRequest.With(prometheus.Labels{"path": "failedscans", "method": "GET", "code": "200"}).Inc()
+}
+
 // unsuccessful http requests received
 
 func recordHTTPNotFound(request *http.Request) {
@@ -245,4 +276,12 @@ func init() {
 		Help:      "various events happening in perceptor core",
 	}, []string{"subsystem", "name"})
 	prometheus.MustRegister(eventCounter)
+
+	timingChangeCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "timing_changes",
+		Help:      "timings which were changed by a config reload",
+	}, []string{"name"})
+	prometheus.MustRegister(timingChangeCounter)
 }
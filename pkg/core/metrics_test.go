@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -56,10 +57,18 @@ func RunTestMetrics() {
 				PodStatus:             map[string]int{"zzz": 8},
 				PodVulnerabilities:    map[int]int{9: 1},
 				ScanStatusCounts:      map[m.ScanStatus]int{m.ScanStatusComplete: 31},
+				MaxQueueWait:          90 * time.Second,
 			})
 			recordGetScanResults()
+			recordGetFailedScans()
 			recordPostFinishedScan()
+			recordHeartbeat()
+			recordExchangeScanToken()
+			recordWaitingNextImageRequests(2)
+			recordRegisterScanner()
+			recordGetScanners()
 			recordEvent("um", "found hub")
+			recordTimingChange("UnknownImagePause")
 			Expect(1).To(Equal(1))
 		})
 	})
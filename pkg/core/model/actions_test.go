@@ -61,7 +61,10 @@ func checkModelEquality(m1 *Model, m2 *Model) {
 	ji2, err := json.Marshal(m2.Images)
 	Expect(err).To(BeNil())
 	Expect(ji1).To(Equal(ji2))
-	Expect(m1.ImageScanQueue).To(Equal(m2.ImageScanQueue))
+	// the queues record when each item was added, so compare everything else
+	Expect(m1.ImageScanQueue.Dump()).To(Equal(m2.ImageScanQueue.Dump()))
+	Expect(m1.ImageScanQueue.Values()).To(Equal(m2.ImageScanQueue.Values()))
+	Expect(m1.ImageScanQueue.AgingInterval()).To(Equal(m2.ImageScanQueue.AgingInterval()))
 	Expect(m1.Pods).To(Equal(m2.Pods))
 }
 
@@ -109,6 +112,7 @@ func RunActionTests() {
 			imageInfo := NewImageInfo(testImage, &RepoTag{Repository: "image1", Tag: ""})
 			imageInfo.ScanStatus = ScanStatusUnknown
 			imageInfo.TimeOfLastStatusChange = actual.Images[testSha].TimeOfLastStatusChange
+			imageInfo.TimeOfLastReference = actual.Images[testSha].TimeOfLastReference
 			expected.Images[testSha] = imageInfo
 			//
 			checkModelEquality(actual, &expected)
@@ -127,6 +131,7 @@ func RunActionTests() {
 			imageInfo := NewImageInfo(testImage, &RepoTag{Repository: "image1", Tag: ""})
 			imageInfo.ScanStatus = ScanStatusUnknown
 			imageInfo.TimeOfLastStatusChange = actual.Images[testSha].TimeOfLastStatusChange
+			imageInfo.TimeOfLastReference = actual.Images[testSha].TimeOfLastReference
 			expected.Images[testSha] = imageInfo
 			//
 			checkModelEquality(actual, &expected)
@@ -168,7 +173,7 @@ func RunActionTests() {
 		It("no image available", func() {
 			// actual
 			actual := NewModel()
-			nextImage, err := actual.getNextImageFromScanQueue()
+			nextImage, err := actual.getNextImageFromScanQueue("")
 			Expect(err).To(BeNil())
 			// expected: front image removed from scan queue, status and time of image changed
 			expected := NewModel()
@@ -183,13 +188,14 @@ func RunActionTests() {
 			model.addImage(image1)
 			model.setImageScanStatus(image1.Sha, ScanStatusInQueue)
 
-			nextImage, err := model.getNextImageFromScanQueue()
+			nextImage, err := model.getNextImageFromScanQueue("")
 			Expect(err).To(BeNil())
 
 			expected := NewModel()
 			expected.addImage(image1)
 			expected.setImageScanStatus(image1.Sha, ScanStatusInQueue)
 			expected.Images[sha1].TimeOfLastStatusChange = model.Images[sha1].TimeOfLastStatusChange
+			expected.Images[sha1].TimeOfLastReference = model.Images[sha1].TimeOfLastReference
 
 			Expect(*nextImage).To(Equal(image1))
 			checkModelEquality(model, expected)
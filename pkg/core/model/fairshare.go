@@ -0,0 +1,250 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/util"
+)
+
+// FairSharePolicy shares scanners between namespaces.  Each namespace gets
+// its own scan queue, and the queues take turns in weighted round-robin
+// order: a namespace with weight 2 gets twice as many scans as one with
+// weight 1, as long as both have images waiting.  A namespace may also be
+// limited to a number of scans in flight at once.
+//
+// An image which is in several namespaces belongs to just one of them -- the
+// first one, alphabetically, at the time it's queued -- so that it's only
+// queued and counted once.  Images which aren't in any pod belong to the
+// "" namespace.
+type FairSharePolicy struct {
+	DefaultWeight        int
+	Weights              map[string]int
+	DefaultInFlightQuota int
+	InFlightQuotas       map[string]int
+}
+
+// Weight returns the weight of the namespace, which is at least 1.
+func (policy *FairSharePolicy) Weight(namespace string) int {
+	weight, ok := policy.Weights[namespace]
+	if !ok {
+		weight = policy.DefaultWeight
+	}
+	if weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// InFlightQuota returns the maximum number of scans in flight at once for
+// the namespace, or 0 if there's no limit.
+func (policy *FairSharePolicy) InFlightQuota(namespace string) int {
+	quota, ok := policy.InFlightQuotas[namespace]
+	if !ok {
+		quota = policy.DefaultInFlightQuota
+	}
+	if quota < 0 {
+		return 0
+	}
+	return quota
+}
+
+// fairShareState implements the weighted round-robin as stride scheduling:
+// every namespace has a pass, which goes up by 1/weight each time it gets a
+// scan, and the namespace with the lowest pass goes next.  A namespace's
+// pass never falls behind the pass of the most recent scan, so namespaces
+// can't bank turns while they have nothing queued.
+type fairShareState struct {
+	passes      map[string]float64
+	virtualTime float64
+}
+
+func newFairShareState() *fairShareState {
+	return &fairShareState{passes: map[string]float64{}}
+}
+
+func (state *fairShareState) pass(namespace string) float64 {
+	pass := state.passes[namespace]
+	if pass < state.virtualTime {
+		return state.virtualTime
+	}
+	return pass
+}
+
+func (state *fairShareState) charge(namespace string, weight int) {
+	pass := state.pass(namespace)
+	state.virtualTime = pass
+	state.passes[namespace] = pass + 1/float64(weight)
+}
+
+// Model methods
+
+// referenceImageNamespace records that a pod in `namespace` uses an image.
+// An image goes in the queue of the first namespace, alphabetically, of the
+// pods which use it; once queued, it stays in that namespace's queue.
+func (model *Model) referenceImageNamespace(sha DockerImageSha, namespace string) {
+	if current, ok := model.imageNamespaces[sha]; ok && current != "" && current <= namespace {
+		return
+	}
+	if model.ImageScanQueue.HasKey(string(sha)) {
+		return
+	}
+	model.imageNamespaces[sha] = namespace
+}
+
+func (model *Model) namespaceQueue(namespace string) *util.PriorityQueue {
+	queue, ok := model.namespaceQueues[namespace]
+	if !ok {
+		queue = util.NewPriorityQueue()
+		queue.SetAgingInterval(model.ImageScanQueue.AgingInterval())
+		model.namespaceQueues[namespace] = queue
+	}
+	return queue
+}
+
+// inFlightScans counts the images being scanned, by namespace.
+func (model *Model) inFlightScans() map[string]int {
+	inFlight := map[string]int{}
+	for sha, imageInfo := range model.Images {
+		switch imageInfo.ScanStatus {
+		case ScanStatusRunningScanClient, ScanStatusRunningHubScan:
+			inFlight[model.imageNamespaces[sha]]++
+		}
+	}
+	return inFlight
+}
+
+// nextFairShareNamespace returns the namespace whose turn it is, skipping
+// namespaces which are at their in-flight quota.  It returns false if no
+// namespace is eligible.
+func (model *Model) nextFairShareNamespace() (string, bool) {
+	namespaces := model.fairShareNamespaces()
+	if len(namespaces) == 0 {
+		return "", false
+	}
+	return namespaces[0], true
+}
+
+// fairShareNamespaces returns the namespaces which aren't at their in-flight
+// quota, in the order of their turns.
+func (model *Model) fairShareNamespaces() []string {
+	inFlight := model.inFlightScans()
+	namespaces := []string{}
+	for namespace, queue := range model.namespaceQueues {
+		if queue.IsEmpty() {
+			continue
+		}
+		quota := model.fairSharePolicy.InFlightQuota(namespace)
+		if quota > 0 && inFlight[namespace] >= quota {
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	sort.SliceStable(namespaces, func(i int, j int) bool {
+		if model.fairShare.pass(namespaces[i]) != model.fairShare.pass(namespaces[j]) {
+			return model.fairShare.pass(namespaces[i]) < model.fairShare.pass(namespaces[j])
+		}
+		return namespaces[i] < namespaces[j]
+	})
+	return namespaces
+}
+
+func (model *Model) getNextImageFromFairShareQueues(pool string) (*Image, error) {
+	matcher := model.scannerPoolMatcher(pool)
+	for _, namespace := range model.fairShareNamespaces() {
+		first := model.namespaceQueues[namespace].PeekMatching(matcher)
+		if first == nil {
+			continue
+		}
+		sha, ok := first.(DockerImageSha)
+		if !ok {
+			return nil, fmt.Errorf("expected type DockerImageSha from priority queue for namespace %s, got %s", namespace, reflect.TypeOf(first))
+		}
+		image := model.unsafeGet(sha).Image()
+		return &image, nil
+	}
+	return nil, nil
+}
+
+// setFairSharePolicy turns fair sharing on, or off if `policy` is nil.
+func (model *Model) setFairSharePolicy(policy *FairSharePolicy) {
+	model.fairSharePolicy = policy
+	model.didMakeImagesAvailable()
+}
+
+// namespaceQueueDepths returns the number of queued images in each namespace.
+func (model *Model) namespaceQueueDepths() map[string]int {
+	depths := map[string]int{}
+	for namespace, queue := range model.namespaceQueues {
+		depths[namespace] = queue.Size()
+	}
+	return depths
+}
+
+// the per-namespace queues shadow the global scan queue
+
+func (model *Model) addToScanQueues(sha DockerImageSha, priority int, addedAt time.Time) error {
+	err := model.ImageScanQueue.AddAt(string(sha), priority, sha, addedAt)
+	if err != nil {
+		return err
+	}
+	namespace := model.imageNamespaces[sha]
+	err = model.namespaceQueue(namespace).AddAt(string(sha), priority, sha, addedAt)
+	if err != nil {
+		return err
+	}
+	model.didMakeImagesAvailable()
+	return nil
+}
+
+func (model *Model) setScanQueuesPriority(sha DockerImageSha, priority int) error {
+	err := model.ImageScanQueue.Set(string(sha), priority)
+	if err != nil {
+		return err
+	}
+	return model.namespaceQueue(model.imageNamespaces[sha]).Set(string(sha), priority)
+}
+
+func (model *Model) removeFromScanQueues(sha DockerImageSha) error {
+	_, err := model.ImageScanQueue.Remove(string(sha))
+	if err != nil {
+		return err
+	}
+	namespace := model.imageNamespaces[sha]
+	queue := model.namespaceQueue(namespace)
+	_, err = queue.Remove(string(sha))
+	if queue.IsEmpty() {
+		delete(model.namespaceQueues, namespace)
+	}
+	return err
+}
+
+func (model *Model) setScanQueuesAgingInterval(agingInterval time.Duration) {
+	model.ImageScanQueue.SetAgingInterval(agingInterval)
+	for _, queue := range model.namespaceQueues {
+		queue.SetAgingInterval(agingInterval)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	regexPatternPrefix = "regex:"
+)
+
+// Pattern matches namespaces or repositories.  It's a glob -- where `*`
+// matches any sequence of characters, including `/`, and `?` matches any
+// single character -- unless it's prefixed with "regex:", in which case
+// the remainder is a regular expression.  Globs must match the whole value,
+// while regular expressions may match any part of it unless anchored.
+type Pattern struct {
+	source string
+	regex  *regexp.Regexp
+}
+
+// NewPattern .....
+func NewPattern(source string) (*Pattern, error) {
+	var expr string
+	if strings.HasPrefix(source, regexPatternPrefix) {
+		expr = strings.TrimPrefix(source, regexPatternPrefix)
+	} else {
+		expr = globToRegex(source)
+	}
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid pattern %s", source)
+	}
+	return &Pattern{source: source, regex: regex}, nil
+}
+
+func globToRegex(glob string) string {
+	var buffer strings.Builder
+	buffer.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			buffer.WriteString(".*")
+		case '?':
+			buffer.WriteString(".")
+		default:
+			buffer.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buffer.WriteString("$")
+	return buffer.String()
+}
+
+// Matches .....
+func (pattern *Pattern) Matches(value string) bool {
+	return pattern.regex.MatchString(value)
+}
+
+func (pattern *Pattern) String() string {
+	return pattern.source
+}
+
+// filterRules admits a value if it matches at least one include pattern --
+// or there aren't any include patterns -- and doesn't match any exclude
+// pattern.
+type filterRules struct {
+	include []*Pattern
+	exclude []*Pattern
+}
+
+func newFilterRules(include []string, exclude []string) (*filterRules, error) {
+	rules := &filterRules{include: []*Pattern{}, exclude: []*Pattern{}}
+	for _, source := range include {
+		pattern, err := NewPattern(source)
+		if err != nil {
+			return nil, err
+		}
+		rules.include = append(rules.include, pattern)
+	}
+	for _, source := range exclude {
+		pattern, err := NewPattern(source)
+		if err != nil {
+			return nil, err
+		}
+		rules.exclude = append(rules.exclude, pattern)
+	}
+	return rules, nil
+}
+
+// reason returns why `value` isn't admitted, or "" if it is.
+func (rules *filterRules) reason(kind string, value string) string {
+	for _, pattern := range rules.exclude {
+		if pattern.Matches(value) {
+			return fmt.Sprintf("%s %s matches exclude pattern %s", kind, value, pattern)
+		}
+	}
+	if len(rules.include) == 0 {
+		return ""
+	}
+	for _, pattern := range rules.include {
+		if pattern.Matches(value) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s %s doesn't match any include pattern", kind, value)
+}
+
+// Filter decides which pods -- by namespace -- and which images -- by
+// repository -- are let into the model.  A nil Filter admits everything.
+type Filter struct {
+	namespaces   *filterRules
+	repositories *filterRules
+}
+
+// NewFilter .....
+func NewFilter(includeNamespaces []string, excludeNamespaces []string, includeRepositories []string, excludeRepositories []string) (*Filter, error) {
+	namespaces, err := newFilterRules(includeNamespaces, excludeNamespaces)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create namespace filter")
+	}
+	repositories, err := newFilterRules(includeRepositories, excludeRepositories)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create repository filter")
+	}
+	return &Filter{namespaces: namespaces, repositories: repositories}, nil
+}
+
+// podReason returns why `pod` is filtered out, or "" if it isn't.
+func (filter *Filter) podReason(pod Pod) string {
+	if filter == nil {
+		return ""
+	}
+	return filter.namespaces.reason("namespace", pod.Namespace)
+}
+
+// imageReason returns why `image` is filtered out, or "" if it isn't.
+func (filter *Filter) imageReason(image Image) string {
+	if filter == nil {
+		return ""
+	}
+	return filter.repositories.reason("repository", image.Repository)
+}
+
+// FilteredPod is a pod which was sent to the model, but isn't scanned.
+type FilteredPod struct {
+	Pod    Pod
+	Reason string
+}
+
+// FilteredImage is an image which was sent to the model, but isn't scanned.
+type FilteredImage struct {
+	Image  Image
+	Reason string
+}
+
+// Model methods
+
+// setFilter replaces the model's filter and re-applies it to everything
+// that's already in the model:
+//  - pods which are now filtered out are moved to FilteredPods
+//  - pods and images which are no longer filtered out are added
+//  - images which aren't in any pod, and whose repository is now filtered
+//    out, are moved to FilteredImages
+// Images which are no longer needed are removed, unless they're being
+// scanned or already have scan results.
+func (model *Model) setFilter(filter *Filter) error {
+	model.filter = filter
+	errs := []error{}
+	candidates := map[DockerImageSha]bool{}
+	for podName, pod := range model.Pods {
+		reason := filter.podReason(pod)
+		if reason == "" {
+			continue
+		}
+		model.removePod(podName)
+		model.FilteredPods[podName] = &FilteredPod{Pod: pod, Reason: reason}
+		for _, cont := range pod.Containers {
+			candidates[cont.Image.Sha] = true
+		}
+		log.Infof("filtered out pod %s: %s", podName, reason)
+	}
+	for podName, filteredPod := range model.FilteredPods {
+		if filter.podReason(filteredPod.Pod) != "" {
+			continue
+		}
+		log.Infof("pod %s is no longer filtered out", podName)
+		err := model.addPod(filteredPod.Pod)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for sha, filteredImage := range model.FilteredImages {
+		if filter.imageReason(filteredImage.Image) != "" {
+			continue
+		}
+		log.Infof("image %s is no longer filtered out", sha)
+		err := model.addImage(filteredImage.Image)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	imagesInPod := model.imagesInPods()
+	for sha, imageInfo := range model.Images {
+		if imagesInPod[sha] {
+			continue
+		}
+		image := imageInfo.Image()
+		reason := filter.imageReason(image)
+		if reason == "" {
+			continue
+		}
+		model.FilteredImages[sha] = &FilteredImage{Image: image, Reason: reason}
+		candidates[sha] = true
+		log.Infof("filtered out image %s: %s", sha, reason)
+	}
+	for sha := range candidates {
+		if !imagesInPod[sha] {
+			err := model.removeFilteredImage(sha)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		// images which are kept may have lost the pods behind their priority
+		err := model.recomputePriority(sha)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return combineErrors("setFilter", errs)
+}
+
+func (model *Model) imagesInPods() map[DockerImageSha]bool {
+	imagesInPod := map[DockerImageSha]bool{}
+	for _, pod := range model.Pods {
+		for _, cont := range pod.Containers {
+			imagesInPod[cont.Image.Sha] = true
+		}
+	}
+	return imagesInPod
+}
+
+// removeFilteredImage removes an image which nobody wants scanned anymore,
+// as long as it hasn't been scanned and isn't in the middle of a scan.
+func (model *Model) removeFilteredImage(sha DockerImageSha) error {
+	imageInfo, ok := model.Images[sha]
+	if !ok {
+		return nil
+	}
+	switch imageInfo.ScanStatus {
+	case ScanStatusUnknown, ScanStatusInQueue, ScanStatusFailed:
+	default:
+		return nil
+	}
+	err := model.leaveState(sha, imageInfo.ScanStatus)
+	if err != nil {
+		return errors.Annotatef(err, "unable to leaveState %s for sha %s", imageInfo.ScanStatus, sha)
+	}
+	log.Debugf("removed filtered image %s in state %s", sha, imageInfo.ScanStatus)
+	return model.deleteImage(sha)
+}
@@ -33,6 +33,9 @@ type Image struct {
 	Priority                int
 	BlackDuckProjectName    string
 	BlackDuckProjectVersion string
+	// ScannerPool, if set, is the only pool of scanners which may scan the
+	// image
+	ScannerPool string
 }
 
 // NewImage returns the image congifurations
@@ -34,12 +34,27 @@ type ImageInfo struct {
 	ScanStatus              ScanStatus
 	TimeOfLastStatusChange  time.Time
 	TimeOfLastRefresh       time.Time
+	TimeOfLastReference     time.Time
 	ScanResults             *hub.ScanResults
 	ImageSha                DockerImageSha
 	RepoTags                []*RepoTag
 	Priority                int
 	BlackDuckProjectName    string
 	BlackDuckProjectVersion string
+	// priority
+	RequestedPriority int
+	PriorityRule      string
+	// routing: the pool the image was sent with, if any, and the pool of
+	// scanners which may scan it
+	RequestedScannerPool string
+	ScannerPool          string
+	// HubURL is the hub which the image's scan was assigned to; it's only
+	// set while the image is in RunningScanClient or RunningHubScan
+	HubURL string
+	// failed scans
+	FailureCount      int
+	LastError         string
+	TimeOfLastFailure time.Time
 }
 
 // NewImageInfo .....
@@ -49,8 +64,13 @@ func NewImageInfo(image Image, repoTag *RepoTag) *ImageInfo {
 		ImageSha:                image.Sha,
 		RepoTags:                []*RepoTag{repoTag},
 		Priority:                image.Priority,
+		RequestedPriority:       image.Priority,
+		PriorityRule:            priorityRuleDefault,
+		RequestedScannerPool:    image.ScannerPool,
+		ScannerPool:             image.ScannerPool,
 		BlackDuckProjectName:    image.BlackDuckProjectName,
 		BlackDuckProjectVersion: image.BlackDuckProjectVersion,
+		TimeOfLastReference:     time.Now(),
 	}
 	imageInfo.setScanStatus(ScanStatusUnknown)
 	return imageInfo
@@ -59,13 +79,18 @@ func NewImageInfo(image Image, repoTag *RepoTag) *ImageInfo {
 func (imageInfo *ImageInfo) setScanStatus(newStatus ScanStatus) {
 	imageInfo.ScanStatus = newStatus
 	imageInfo.TimeOfLastStatusChange = time.Now()
+	if newStatus != ScanStatusRunningScanClient && newStatus != ScanStatusRunningHubScan {
+		imageInfo.HubURL = ""
+	}
 }
 
-// SetPriority ...
-func (imageInfo *ImageInfo) SetPriority(priority int) {
+// SetPriority sets the priority, along with the name of the rule -- or
+// other reason -- that it came from.
+func (imageInfo *ImageInfo) SetPriority(priority int, rule string) {
 	recordSetImagePriority(imageInfo.Priority, priority)
-	log.Debugf("changing priority for %s from %d to %d", imageInfo.ImageSha, imageInfo.Priority, priority)
+	log.Debugf("changing priority for %s from %d to %d (%s)", imageInfo.ImageSha, imageInfo.Priority, priority, rule)
 	imageInfo.Priority = priority
+	imageInfo.PriorityRule = rule
 }
 
 // SetScanResults .....
@@ -74,6 +99,18 @@ func (imageInfo *ImageInfo) SetScanResults(results *hub.ScanResults) {
 	imageInfo.TimeOfLastRefresh = time.Now()
 }
 
+func (imageInfo *ImageInfo) recordFailure(err error) {
+	imageInfo.FailureCount++
+	imageInfo.LastError = err.Error()
+	imageInfo.TimeOfLastFailure = time.Now()
+}
+
+// resetFailures starts counting failed scan attempts from scratch after a
+// successful scan.  LastError and TimeOfLastFailure are kept for the record.
+func (imageInfo *ImageInfo) resetFailures() {
+	imageInfo.FailureCount = 0
+}
+
 // TimeInCurrentScanStatus .....
 func (imageInfo *ImageInfo) TimeInCurrentScanStatus() time.Duration {
 	return time.Now().Sub(imageInfo.TimeOfLastStatusChange)
@@ -82,7 +119,9 @@ func (imageInfo *ImageInfo) TimeInCurrentScanStatus() time.Duration {
 // Image .....
 func (imageInfo *ImageInfo) Image() Image {
 	repoTag := imageInfo.FirstRepoTag()
-	return *NewImage(repoTag.Repository, repoTag.Tag, imageInfo.ImageSha, imageInfo.Priority, imageInfo.BlackDuckProjectName, imageInfo.BlackDuckProjectVersion)
+	image := NewImage(repoTag.Repository, repoTag.Tag, imageInfo.ImageSha, imageInfo.Priority, imageInfo.BlackDuckProjectName, imageInfo.BlackDuckProjectVersion)
+	image.ScannerPool = imageInfo.RequestedScannerPool
+	return *image
 }
 
 // AddRepoTag .....
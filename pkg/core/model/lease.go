@@ -0,0 +1,193 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// ScanLease is a scanner's claim on an image which it's been handed to
+// scan.  The scanner must renew the lease with heartbeats until it's done,
+// and must present the lease when it reports that the scan is finished.
+// If the lease expires first, the scanner is presumed dead, and the image
+// goes back into the scan queue.
+type ScanLease struct {
+	ID     string
+	Sha    DockerImageSha
+	HubURL string
+	// ScannerID is empty if the scanner didn't identify itself
+	ScannerID string
+	Expiry    time.Time
+	// TimeOfLastHeartbeat is zero until the first heartbeat.
+	TimeOfLastHeartbeat time.Time
+	// ScanToken is empty in the legacy credential mode, in which the scanner
+	// is handed the Black Duck password along with the image.
+	ScanToken string
+	// TimeOfScanTokenExchange is zero until the scan token is exchanged.
+	TimeOfScanTokenExchange time.Time
+}
+
+func (lease *ScanLease) isExpired(now time.Time) bool {
+	return !now.Before(lease.Expiry)
+}
+
+// Leases aren't journaled or snapshotted: when the model is restored, images
+// which were being scanned go back into the queue, so their leases are void.
+
+// startScanClientWithLease starts the scan client for an image, and records
+// the lease that the scanner has been given on it.
+func (model *Model) startScanClientWithLease(lease *ScanLease) error {
+	if _, ok := model.Leases[lease.ID]; ok {
+		return fmt.Errorf("unable to start scan client for image %s: lease %s already exists", lease.Sha, lease.ID)
+	}
+	err := model.startScanClient(lease.Sha)
+	if err != nil {
+		return err
+	}
+	// any older lease on this image belonged to a scanner which has since
+	// lost it, so it mustn't be honored any more
+	for leaseID, oldLease := range model.Leases {
+		if oldLease.Sha == lease.Sha {
+			model.dropLease(leaseID)
+		}
+	}
+	model.Leases[lease.ID] = lease
+	model.Images[lease.Sha].HubURL = lease.HubURL
+	model.scannerDidStartJob(lease.ScannerID, lease.Sha)
+	recordScanLease("granted")
+	return nil
+}
+
+// dropLease removes a lease which wasn't released by its scanner, counting
+// the scanner's job as failed.
+func (model *Model) dropLease(leaseID string) {
+	lease, ok := model.Leases[leaseID]
+	if !ok {
+		return
+	}
+	delete(model.Leases, leaseID)
+	model.scannerDidFinishJob(lease.ScannerID, lease.Sha, false, false)
+}
+
+// validLease returns the lease, or a LeaseError if it doesn't exist, has
+// expired, or is no longer for an image in a scan client.
+func (model *Model) validLease(leaseID string, now time.Time) (*ScanLease, error) {
+	lease, ok := model.Leases[leaseID]
+	if !ok {
+		return nil, &api.LeaseError{Message: fmt.Sprintf("lease %s not found", leaseID)}
+	}
+	if lease.isExpired(now) {
+		return nil, &api.LeaseError{Message: fmt.Sprintf("lease %s expired at %s", leaseID, lease.Expiry)}
+	}
+	imageInfo, ok := model.Images[lease.Sha]
+	if !ok || imageInfo.ScanStatus != ScanStatusRunningScanClient {
+		model.dropLease(leaseID)
+		return nil, &api.LeaseError{Message: fmt.Sprintf("lease %s is for image %s, which is no longer in a scan client", leaseID, lease.Sha)}
+	}
+	return lease, nil
+}
+
+func (model *Model) renewLease(leaseID string, duration time.Duration) (*ScanLease, error) {
+	now := time.Now()
+	lease, err := model.validLease(leaseID, now)
+	if err != nil {
+		recordScanLease("rejected")
+		return nil, err
+	}
+	lease.Expiry = now.Add(duration)
+	lease.TimeOfLastHeartbeat = now
+	model.scannerWasSeen(lease.ScannerID)
+	recordScanLease("renewed")
+	copied := *lease
+	return &copied, nil
+}
+
+// exchangeScanToken finds the valid lease which `scanToken` was issued with,
+// and marks the token as used.  A scan token can only be exchanged once.
+func (model *Model) exchangeScanToken(scanToken string) (*ScanLease, error) {
+	if scanToken == "" {
+		recordScanLease("scan_token_rejected")
+		return nil, fmt.Errorf("no scan token")
+	}
+	for leaseID, lease := range model.Leases {
+		if lease.ScanToken != scanToken {
+			continue
+		}
+		if _, err := model.validLease(leaseID, time.Now()); err != nil {
+			recordScanLease("scan_token_rejected")
+			return nil, err
+		}
+		if !lease.TimeOfScanTokenExchange.IsZero() {
+			recordScanLease("scan_token_rejected")
+			return nil, fmt.Errorf("scan token for lease %s was already exchanged at %s", leaseID, lease.TimeOfScanTokenExchange)
+		}
+		lease.TimeOfScanTokenExchange = time.Now()
+		model.scannerWasSeen(lease.ScannerID)
+		recordScanLease("scan_token_exchanged")
+		copied := *lease
+		return &copied, nil
+	}
+	recordScanLease("scan_token_rejected")
+	return nil, fmt.Errorf("scan token not found")
+}
+
+// releaseLease checks that the lease is valid and for `sha`, and if so,
+// removes it, and records the outcome of the scanner's job.
+func (model *Model) releaseLease(leaseID string, sha DockerImageSha, scanErr error) (*ScanLease, error) {
+	lease, err := model.validLease(leaseID, time.Now())
+	if err != nil {
+		recordScanLease("rejected")
+		return nil, err
+	}
+	if lease.Sha != sha {
+		recordScanLease("rejected")
+		return nil, &api.LeaseError{Message: fmt.Sprintf("lease %s is for image %s, not %s", leaseID, lease.Sha, sha)}
+	}
+	delete(model.Leases, leaseID)
+	model.scannerDidFinishJob(lease.ScannerID, sha, scanErr == nil, true)
+	recordScanLease("released")
+	return lease, nil
+}
+
+// findExpiredLeases removes expired leases, and returns the ones whose
+// image is still in a scan client.
+func (model *Model) findExpiredLeases(now time.Time) []*ScanLease {
+	expired := []*ScanLease{}
+	for leaseID, lease := range model.Leases {
+		if !lease.isExpired(now) {
+			continue
+		}
+		model.dropLease(leaseID)
+		imageInfo, ok := model.Images[lease.Sha]
+		if !ok || imageInfo.ScanStatus != ScanStatusRunningScanClient {
+			continue
+		}
+		log.Warnf("lease %s on image %s from hub %s expired at %s", leaseID, lease.Sha, lease.HubURL, lease.Expiry)
+		recordScanLease("expired")
+		expired = append(expired, lease)
+	}
+	return expired
+}
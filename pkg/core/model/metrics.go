@@ -38,6 +38,17 @@ var statusGauge *prometheus.GaugeVec
 var reducerActivityCounter *prometheus.CounterVec
 var reducerMessageCounter *prometheus.CounterVec
 var setImagePriorityCounter *prometheus.CounterVec
+var persistenceCounter *prometheus.CounterVec
+var prunedImagesCounter *prometheus.CounterVec
+var stalledScanClientCounter prometheus.Counter
+var requeuedHubScanCounter prometheus.Counter
+var expiredLeaseScanCounter prometheus.Counter
+var deletedScanCounter prometheus.Counter
+var scanFailureCounter *prometheus.CounterVec
+var rescannedImagesCounter prometheus.Counter
+var scanLeaseCounter *prometheus.CounterVec
+var scannerEventCounter *prometheus.CounterVec
+var scannerJobDuration prometheus.Histogram
 
 func recordActionError(action string) {
 	actionErrorCounter.With(prometheus.Labels{"action": action}).Inc()
@@ -61,6 +72,52 @@ func recordStateTransition(from ScanStatus, to ScanStatus, isLegal bool) {
 		"legal": fmt.Sprintf("%t", isLegal)}).Inc()
 }
 
+func recordPersistence(operation string, isSuccessful bool) {
+	persistenceCounter.With(prometheus.Labels{
+		"operation": operation,
+		"isSuccess": fmt.Sprintf("%t", isSuccessful)}).Inc()
+}
+
+func recordPrunedImage(status ScanStatus) {
+	prunedImagesCounter.With(prometheus.Labels{"status": status.String()}).Inc()
+}
+
+func recordStalledScanClient() {
+	stalledScanClientCounter.Inc()
+}
+
+func recordExpiredLeaseScan() {
+	expiredLeaseScanCounter.Inc()
+}
+
+func recordRequeuedHubScan() {
+	requeuedHubScanCounter.Inc()
+}
+
+func recordDeletedScan() {
+	deletedScanCounter.Inc()
+}
+
+func recordScanFailure(isParked bool) {
+	scanFailureCounter.With(prometheus.Labels{"isParked": fmt.Sprintf("%t", isParked)}).Inc()
+}
+
+func recordRescan(imageCount int) {
+	rescannedImagesCounter.Add(float64(imageCount))
+}
+
+func recordScanLease(event string) {
+	scanLeaseCounter.With(prometheus.Labels{"event": event}).Inc()
+}
+
+func recordScannerEvent(event string) {
+	scannerEventCounter.With(prometheus.Labels{"event": event}).Inc()
+}
+
+func recordScannerJobDuration(duration time.Duration) {
+	scannerJobDuration.Observe(duration.Seconds())
+}
+
 func recordEvent(event string) {
 	eventsCounter.With(prometheus.Labels{"event": event}).Inc()
 }
@@ -148,4 +205,93 @@ func init() {
 		Help:      "count of the message types processed by the reducer",
 	}, []string{"message"})
 	prometheus.MustRegister(reducerMessageCounter)
+
+	persistenceCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_persistence",
+		Help:      "snapshot and journal operations for persisting the core model to disk",
+	}, []string{"operation", "isSuccess"})
+	prometheus.MustRegister(persistenceCounter)
+
+	prunedImagesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_pruned_images",
+		Help:      "orphaned images removed from the model, by the scan status they were in",
+	}, []string{"status"})
+	prometheus.MustRegister(prunedImagesCounter)
+
+	stalledScanClientCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_stalled_scan_clients",
+		Help:      "images whose scan client stalled, and which were moved back into the scan queue",
+	})
+	prometheus.MustRegister(stalledScanClientCounter)
+
+	requeuedHubScanCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_requeued_hub_scans",
+		Help:      "images whose hub was removed or down for too long, and which were moved back into the scan queue",
+	})
+	prometheus.MustRegister(requeuedHubScanCounter)
+
+	expiredLeaseScanCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_expired_lease_scans",
+		Help:      "images whose scanner's lease expired, and which were moved back into the scan queue",
+	})
+	prometheus.MustRegister(expiredLeaseScanCounter)
+
+	deletedScanCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_deleted_scans",
+		Help:      "completed images whose code location was deleted from the hub, and which were moved back into the scan queue",
+	})
+	prometheus.MustRegister(deletedScanCounter)
+
+	scanFailureCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_scan_failures",
+		Help:      "failed scans, and whether the image was parked because it used up its attempts",
+	}, []string{"isParked"})
+	prometheus.MustRegister(scanFailureCounter)
+
+	rescannedImagesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_rescanned_images",
+		Help:      "images put back into the scan queue by a rescan request",
+	})
+	prometheus.MustRegister(rescannedImagesCounter)
+
+	scanLeaseCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_scan_leases",
+		Help:      "scan leases granted, renewed, released, expired, and rejected because they weren't valid",
+	}, []string{"event"})
+	prometheus.MustRegister(scanLeaseCounter)
+
+	scannerEventCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_scanner_events",
+		Help:      "scanner registrations, and jobs completed and failed by scanners",
+	}, []string{"event"})
+	prometheus.MustRegister(scannerEventCounter)
+
+	scannerJobDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "model_scanner_job_duration",
+		Help:      "tracks how long scanners take to finish jobs, in seconds",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+	})
+	prometheus.MustRegister(scannerJobDuration)
 }
@@ -44,19 +44,86 @@ type Model struct {
 	Images           map[DockerImageSha]*ImageInfo
 	ImageScanQueue   *util.PriorityQueue
 	ImageTransitions []*ImageTransition
+	ImagePrunes      []*ImagePrune
+	Rescans          []*Rescan
+	// Leases is a map of lease ID to lease
+	Leases map[string]*ScanLease
+	// Scanners is a map of scanner ID to scanner
+	Scanners map[string]*Scanner
+	// FilteredPods and FilteredImages are keyed like Pods and Images, but
+	// aren't scanned
+	FilteredPods   map[string]*FilteredPod
+	FilteredImages map[DockerImageSha]*FilteredImage
+	// imagePods indexes Pods by the images they use
+	imagePods map[DockerImageSha]map[string]bool
 	//
-	actions chan *action
+	actions         chan *action
+	persister       *persister
+	scanRetryPolicy *ScanRetryPolicy
+	filter          *Filter
+	priorityPolicy  *PriorityPolicy
+	routingPolicy   *RoutingPolicy
+	// fair sharing: ImageScanQueue, split up by namespace
+	namespaceQueues map[string]*util.PriorityQueue
+	imageNamespaces map[DockerImageSha]string
+	fairSharePolicy *FairSharePolicy
+	fairShare       *fairShareState
+	// imagesAvailable is signalled when there may be new images to scan
+	imagesAvailable chan struct{}
 }
 
 // NewModel .....
 func NewModel() *Model {
-	model := &Model{
+	model := newModel()
+	model.start()
+	return model
+}
+
+// NewPersistentModel restores a model from the snapshot and journal found in
+// `dataDir`, if any, and then records all further changes there.
+func NewPersistentModel(dataDir string) (*Model, error) {
+	persister, err := newPersister(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	model := newModel()
+	model.persister = persister
+	err = model.restore()
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to restore model from %s", dataDir)
+	}
+	// start over from a fresh snapshot and an empty journal
+	err = model.writeSnapshot()
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to write initial snapshot to %s", dataDir)
+	}
+	model.start()
+	return model, nil
+}
+
+func newModel() *Model {
+	return &Model{
 		Pods:             make(map[string]Pod),
 		Images:           make(map[DockerImageSha]*ImageInfo),
 		ImageScanQueue:   util.NewPriorityQueue(),
 		ImageTransitions: []*ImageTransition{},
+		ImagePrunes:      []*ImagePrune{},
+		Rescans:          []*Rescan{},
+		Leases:           map[string]*ScanLease{},
+		Scanners:         map[string]*Scanner{},
+		FilteredPods:     map[string]*FilteredPod{},
+		FilteredImages:   map[DockerImageSha]*FilteredImage{},
+		imagePods:        map[DockerImageSha]map[string]bool{},
 		actions:          make(chan *action, actionChannelSize),
+		imagesAvailable:  make(chan struct{}, 1),
+		scanRetryPolicy:  DefaultScanRetryPolicy,
+		namespaceQueues:  map[string]*util.PriorityQueue{},
+		imageNamespaces:  map[DockerImageSha]string{},
+		fairShare:        newFairShareState(),
 	}
+}
+
+func (model *Model) start() {
 	go func() {
 		stop := time.Now()
 		for {
@@ -88,7 +155,6 @@ func NewModel() *Model {
 			}
 		}
 	}()
-	return model
 }
 
 // Public API
@@ -96,6 +162,7 @@ func NewModel() *Model {
 // AddPod ...
 func (model *Model) AddPod(pod Pod) {
 	model.actions <- &action{"addPod", func() error {
+		model.record(&journalEntry{Action: "addPod", Pod: &pod})
 		return model.addPod(pod)
 	}}
 }
@@ -103,13 +170,16 @@ func (model *Model) AddPod(pod Pod) {
 // UpdatePod ...
 func (model *Model) UpdatePod(pod Pod) {
 	model.actions <- &action{"updatePod", func() error {
+		model.record(&journalEntry{Action: "updatePod", Pod: &pod})
 		return model.addPod(pod)
 	}}
 }
 
-// DeletePod removes the record of a pod, but does not touch its images
+// DeletePod removes the record of a pod, but not its images, whose orphan
+// grace period starts now if this was their last pod
 func (model *Model) DeletePod(podName string) {
 	model.actions <- &action{"deletePod", func() error {
+		model.record(&journalEntry{Action: "deletePod", PodName: podName})
 		return model.deletePod(podName)
 	}}
 }
@@ -117,6 +187,7 @@ func (model *Model) DeletePod(podName string) {
 // SetPods ...
 func (model *Model) SetPods(pods []Pod) {
 	model.actions <- &action{"allPods", func() error {
+		model.record(&journalEntry{Action: "allPods", Pods: pods})
 		return model.allPods(pods)
 	}}
 }
@@ -124,6 +195,7 @@ func (model *Model) SetPods(pods []Pod) {
 // AddImage ...
 func (model *Model) AddImage(image Image) {
 	model.actions <- &action{"addImage", func() error {
+		model.record(&journalEntry{Action: "addImage", Image: &image})
 		return model.addImage(image)
 	}}
 }
@@ -131,14 +203,54 @@ func (model *Model) AddImage(image Image) {
 // SetImages ...
 func (model *Model) SetImages(images []Image) {
 	model.actions <- &action{"allImages", func() error {
+		model.record(&journalEntry{Action: "allImages", Images: images})
 		return model.allImages(images)
 	}}
 }
 
+// FinishLeasedScanJob should be called when the scan client has finished.
+// It returns an error, and doesn't touch the image, unless `leaseID` is a
+// valid lease on the image.
+func (model *Model) FinishLeasedScanJob(leaseID string, image *Image, err error) (*ScanLease, error) {
+	log.Infof("finish scan job with lease %s: %+v, %v", leaseID, image, err)
+	done := make(chan *ScanLease)
+	errCh := make(chan error)
+	model.actions <- &action{"finishLeasedScanJob", func() error {
+		lease, leaseErr := model.releaseLease(leaseID, image.Sha, err)
+		go func() {
+			if leaseErr != nil {
+				errCh <- leaseErr
+			} else {
+				done <- lease
+			}
+		}()
+		if leaseErr != nil {
+			return leaseErr
+		}
+		errString := ""
+		if err != nil {
+			errString = err.Error()
+		}
+		model.record(&journalEntry{Action: "finishScanJob", Image: image, Err: errString})
+		return model.finishRunningScanClient(image, err)
+	}}
+	select {
+	case lease := <-done:
+		return lease, nil
+	case err := <-errCh:
+		return nil, err
+	}
+}
+
 // FinishScanJob should be called when the scan client has finished.
 func (model *Model) FinishScanJob(image *Image, err error) {
 	log.Infof("finish scan job: %+v, %v", image, err)
 	model.actions <- &action{"finishScanJob", func() error {
+		errString := ""
+		if err != nil {
+			errString = err.Error()
+		}
+		model.record(&journalEntry{Action: "finishScanJob", Image: image, Err: errString})
 		return model.finishRunningScanClient(image, err)
 	}}
 }
@@ -148,10 +260,162 @@ func (model *Model) FinishScanJob(image *Image, err error) {
 // - upon startup, when scan results are first fetched
 func (model *Model) ScanDidFinish(sha DockerImageSha, scanResults *hub.ScanResults) {
 	model.actions <- &action{"scanDidFinish", func() error {
+		model.record(&journalEntry{Action: "scanDidFinish", Sha: sha, ScanResults: scanResults})
 		return model.scanDidFinish(sha, scanResults)
 	}}
 }
 
+// PruneOrphanedImages removes images which haven't been referenced by a pod,
+// or added directly, for at least `gracePeriod`.
+func (model *Model) PruneOrphanedImages(gracePeriod time.Duration) {
+	model.actions <- &action{"pruneOrphanedImages", func() error {
+		shas := model.findOrphanedImages(gracePeriod)
+		if len(shas) == 0 {
+			return nil
+		}
+		log.Infof("pruning %d orphaned images", len(shas))
+		model.record(&journalEntry{Action: "pruneImages", Shas: shas})
+		return model.pruneImages(shas)
+	}}
+}
+
+// RetryFailedScans moves failed images whose backoff period has elapsed
+// back into the scan queue.  Images which have used up their attempts are
+// left in the Failed state.
+func (model *Model) RetryFailedScans() {
+	model.actions <- &action{"retryFailedScans", func() error {
+		shas := model.findFailedScansToRetry(time.Now())
+		if len(shas) == 0 {
+			return nil
+		}
+		log.Infof("retrying %d failed scans", len(shas))
+		model.record(&journalEntry{Action: "retryFailedScans", Shas: shas})
+		return model.retryFailedScans(shas)
+	}}
+}
+
+// SetScanRetryPolicy sets how often, and how many times, failed scans are
+// retried.  It takes effect for the next failure or retry.
+func (model *Model) SetScanRetryPolicy(policy *ScanRetryPolicy) {
+	model.actions <- &action{"setScanRetryPolicy", func() error {
+		model.scanRetryPolicy = policy
+		return nil
+	}}
+}
+
+// SetFilter sets which pods and images are let into the model, and re-applies
+// it to everything already in the model.  It isn't journaled: the filter
+// comes from the config, and is set again after every restart.
+func (model *Model) SetFilter(filter *Filter) {
+	model.actions <- &action{"setFilter", func() error {
+		return model.setFilter(filter)
+	}}
+}
+
+// SetPriorityAgingInterval sets how quickly the priority of images goes up
+// while they wait in the scan queue, so that low priority images aren't
+// starved by a steady stream of higher priority ones.  0 turns aging off.
+func (model *Model) SetPriorityAgingInterval(agingInterval time.Duration) {
+	model.actions <- &action{"setPriorityAgingInterval", func() error {
+		model.setScanQueuesAgingInterval(agingInterval)
+		return nil
+	}}
+}
+
+// SetRoutingPolicy replaces the rules for routing images to scanner pools.
+func (model *Model) SetRoutingPolicy(policy *RoutingPolicy) {
+	model.actions <- &action{"setRoutingPolicy", func() error {
+		model.setRoutingPolicy(policy)
+		return nil
+	}}
+}
+
+// SetFairSharePolicy turns on fair sharing of scans between namespaces, or
+// turns it off if `policy` is nil.
+func (model *Model) SetFairSharePolicy(policy *FairSharePolicy) {
+	model.actions <- &action{"setFairSharePolicy", func() error {
+		model.setFairSharePolicy(policy)
+		return nil
+	}}
+}
+
+// SetPriorityPolicy sets the rules for computing images' priorities, and
+// recomputes the priority of every image in the model.  Like SetFilter, it
+// isn't journaled.
+func (model *Model) SetPriorityPolicy(policy *PriorityPolicy) {
+	model.actions <- &action{"setPriorityPolicy", func() error {
+		return model.setPriorityPolicy(policy)
+	}}
+}
+
+// GetFailedScans returns images in the Failed state, along with why they
+// failed and when they'll next be retried.
+func (model *Model) GetFailedScans() api.FailedScans {
+	done := make(chan api.FailedScans)
+	model.actions <- &action{"getFailedScans", func() error {
+		failedScans := failedScans(model)
+		go func() {
+			done <- failedScans
+		}()
+		return nil
+	}}
+	return <-done
+}
+
+// Rescan puts images back into the scan queue, for example after a policy
+// change in the hub.  See `Rescan` for how images are selected.  It returns
+// the number of images matched, which includes any that are skipped because
+// they're already being scanned.
+func (model *Model) Rescan(rescan *Rescan) (int, error) {
+	done := make(chan error)
+	count := 0
+	model.actions <- &action{"rescan", func() error {
+		shas, err := model.findImagesToRescan(rescan)
+		if err == nil {
+			rescan.Time = time.Now()
+			model.record(&journalEntry{Action: "rescan", Rescan: rescan})
+			err = model.rescan(rescan)
+			count = len(shas)
+		}
+		go func() {
+			done <- err
+		}()
+		return err
+	}}
+	err := <-done
+	return count, err
+}
+
+// ScanDidRefresh should be called when the results of a completed scan are
+// re-fetched from the hub, to pick up any newly disclosed vulnerabilities.
+func (model *Model) ScanDidRefresh(sha DockerImageSha, scanResults *hub.ScanResults) {
+	model.actions <- &action{"scanDidRefresh", func() error {
+		model.record(&journalEntry{Action: "scanDidRefresh", Sha: sha, ScanResults: scanResults})
+		return model.scanDidRefresh(sha, scanResults)
+	}}
+}
+
+// RequeueStalledScanClientScans moves images which have been in the
+// RunningScanClient state for longer than `timeout` back into the scan queue,
+// returning their shas.
+func (model *Model) RequeueStalledScanClientScans(timeout time.Duration) []DockerImageSha {
+	done := make(chan []DockerImageSha)
+	model.actions <- &action{"requeueStalledScanClientScans", func() error {
+		shas := model.findStalledScanClientScans(timeout)
+		var err error
+		if len(shas) > 0 {
+			log.Warnf("requeueing %d stalled scan client scans", len(shas))
+			model.record(&journalEntry{Action: "requeueStalledScans", Shas: shas})
+			err = model.requeueStalledScanClientScans(shas)
+		}
+		go func() {
+			done <- shas
+		}()
+		return err
+	}}
+	return <-done
+}
+
 // GetScanResults ...
 func (model *Model) GetScanResults() api.ScanResults {
 	done := make(chan api.ScanResults)
@@ -205,12 +469,13 @@ func (model *Model) GetMetrics() *Metrics {
 	return <-done
 }
 
-// GetNextImage ...
-func (model *Model) GetNextImage() *Image {
+// GetNextImage returns the highest priority image that a scanner in `pool`
+// may scan.  Scanners which aren't in a pool pass "".
+func (model *Model) GetNextImage(pool string) *Image {
 	done := make(chan *Image)
 	model.actions <- &action{"getNextImage", func() error {
-		log.Debugf("looking for next image to scan")
-		image, err := model.getNextImageFromScanQueue()
+		log.Debugf("looking for next image to scan for scanner pool %s", pool)
+		image, err := model.getNextImageFromScanQueue(pool)
 		go func() {
 			done <- image
 		}()
@@ -219,10 +484,203 @@ func (model *Model) GetNextImage() *Image {
 	return <-done
 }
 
+// RequeueHubScans moves images whose scans were assigned to `hubURL` back
+// into the scan queue, so that they can go to a different hub, returning
+// their shas.  It should be called when the hub is removed, or has been down
+// for too long.
+func (model *Model) RequeueHubScans(hubURL string) []DockerImageSha {
+	done := make(chan []DockerImageSha)
+	model.actions <- &action{"requeueHubScans", func() error {
+		shas := model.findHubScans(hubURL)
+		var err error
+		if len(shas) > 0 {
+			log.Warnf("requeueing %d scans from hub %s", len(shas), hubURL)
+			model.record(&journalEntry{Action: "requeueHubScans", Shas: shas})
+			err = model.requeueHubScans(shas)
+		}
+		go func() {
+			done <- shas
+		}()
+		return err
+	}}
+	return <-done
+}
+
+// ScanWasDeleted puts a completed image back into the scan queue, since its
+// code location was deleted from the hub, along with its scan results.
+// Images in any other state are left alone.
+func (model *Model) ScanWasDeleted(sha DockerImageSha) {
+	model.actions <- &action{"scanWasDeleted", func() error {
+		model.record(&journalEntry{Action: "scanWasDeleted", Sha: sha})
+		return model.scanWasDeleted(sha)
+	}}
+}
+
+// StartScanClientWithLease moves the image into the RunningScanClient state,
+// and records the lease the scanner was given on it.
+func (model *Model) StartScanClientWithLease(lease *ScanLease) error {
+	errCh := make(chan error)
+	model.actions <- &action{"startScanClientWithLease", func() error {
+		model.record(&journalEntry{Action: "startScanClient", Sha: lease.Sha})
+		err := model.startScanClientWithLease(lease)
+		go func() {
+			errCh <- err
+		}()
+		return err
+	}}
+	return <-errCh
+}
+
+// ExchangeScanToken returns a copy of the valid lease which `scanToken` was
+// issued with, and marks the token as used.
+func (model *Model) ExchangeScanToken(scanToken string) (*ScanLease, error) {
+	done := make(chan *ScanLease)
+	errCh := make(chan error)
+	model.actions <- &action{"exchangeScanToken", func() error {
+		lease, err := model.exchangeScanToken(scanToken)
+		go func() {
+			if err != nil {
+				errCh <- err
+			} else {
+				done <- lease
+			}
+		}()
+		return err
+	}}
+	select {
+	case lease := <-done:
+		return lease, nil
+	case err := <-errCh:
+		return nil, err
+	}
+}
+
+// RenewLease extends a valid lease to `duration` from now.
+func (model *Model) RenewLease(leaseID string, duration time.Duration) (*ScanLease, error) {
+	done := make(chan *ScanLease)
+	errCh := make(chan error)
+	model.actions <- &action{"renewLease", func() error {
+		lease, err := model.renewLease(leaseID, duration)
+		go func() {
+			if err != nil {
+				errCh <- err
+			} else {
+				done <- lease
+			}
+		}()
+		return err
+	}}
+	select {
+	case lease := <-done:
+		return lease, nil
+	case err := <-errCh:
+		return nil, err
+	}
+}
+
+// RegisterScanner adds a scanner, or updates one which re-registers.
+func (model *Model) RegisterScanner(id string, version string, capabilities []string) error {
+	errCh := make(chan error)
+	model.actions <- &action{"registerScanner", func() error {
+		err := model.registerScanner(id, version, capabilities)
+		go func() {
+			errCh <- err
+		}()
+		return err
+	}}
+	return <-errCh
+}
+
+// ScannerWasSeen records that a scanner has been heard from.
+func (model *Model) ScannerWasSeen(id string) {
+	model.actions <- &action{"scannerWasSeen", func() error {
+		model.scannerWasSeen(id)
+		return nil
+	}}
+}
+
+// ExpireScanners forgets about scanners which haven't been heard from in at
+// least `timeout`.
+func (model *Model) ExpireScanners(timeout time.Duration) {
+	model.actions <- &action{"expireScanners", func() error {
+		ids := model.expireScanners(time.Now(), timeout)
+		if len(ids) > 0 {
+			log.Infof("expired %d scanners which weren't seen for %s: %v", len(ids), timeout, ids)
+		}
+		return nil
+	}}
+}
+
+// GetScanners .....
+func (model *Model) GetScanners() map[string]*api.ModelScanner {
+	done := make(chan map[string]*api.ModelScanner)
+	model.actions <- &action{"getScanners", func() error {
+		scanners := apiScanners(model)
+		go func() {
+			done <- scanners
+		}()
+		return nil
+	}}
+	return <-done
+}
+
+// ExpireLeases puts images whose lease has expired back into the scan
+// queue, and returns their leases, so that the hub capacity they were using
+// can be freed up.
+func (model *Model) ExpireLeases() []*ScanLease {
+	done := make(chan []*ScanLease)
+	model.actions <- &action{"expireLeases", func() error {
+		leases := model.findExpiredLeases(time.Now())
+		go func() {
+			done <- leases
+		}()
+		if len(leases) == 0 {
+			return nil
+		}
+		shas := []DockerImageSha{}
+		for _, lease := range leases {
+			shas = append(shas, lease.Sha)
+		}
+		model.record(&journalEntry{Action: "requeueExpiredLeases", Shas: shas})
+		return model.requeueExpiredLeaseScans(shas)
+	}}
+	return <-done
+}
+
+// GetTimesOfLastRefresh returns when the results of each completed image
+// were last fetched from its hub.
+func (model *Model) GetTimesOfLastRefresh() map[DockerImageSha]time.Time {
+	done := make(chan map[DockerImageSha]time.Time)
+	model.actions <- &action{"getTimesOfLastRefresh", func() error {
+		timesOfLastRefresh := model.timesOfLastRefresh()
+		go func() {
+			done <- timesOfLastRefresh
+		}()
+		return nil
+	}}
+	return <-done
+}
+
+// ImagesAvailable is signalled when images are added to the scan queue, or
+// otherwise become available to scan.  Signals are coalesced: there's only
+// ever one pending, so it's meant for a single consumer.
+func (model *Model) ImagesAvailable() <-chan struct{} {
+	return model.imagesAvailable
+}
+
+// didMakeImagesAvailable signals ImagesAvailable without blocking.
+func (model *Model) didMakeImagesAvailable() {
+	select {
+	case model.imagesAvailable <- struct{}{}:
+	default:
+	}
+}
+
 // StartScanClient ...
 func (model *Model) StartScanClient(sha DockerImageSha) error {
 	errCh := make(chan error)
 	model.actions <- &action{"startScanClient", func() error {
+		model.record(&journalEntry{Action: "startScanClient", Sha: sha})
 		err := model.startScanClient(sha)
 		go func() {
 			errCh <- err
@@ -232,6 +690,14 @@ func (model *Model) StartScanClient(sha DockerImageSha) error {
 	return <-errCh
 }
 
+// Snapshot writes the current state of the model to disk, and clears out the
+// journal.  It does nothing if persistence isn't enabled.
+func (model *Model) Snapshot() {
+	model.actions <- &action{"snapshot", func() error {
+		return model.writeSnapshot()
+	}}
+}
+
 // Package API
 
 // AddPod adds a pod and all the images in a pod to the model.
@@ -242,26 +708,91 @@ func (model *Model) StartScanClient(sha DockerImageSha) error {
 // adding them into the cache.
 func (model *Model) addPod(newPod Pod) error {
 	log.Debugf("about to add pod: UID %s, qualified name %s", newPod.UID, newPod.QualifiedName())
+	oldPod, hadOldPod := model.removePod(newPod.QualifiedName())
+	if hadOldPod {
+		model.dereferencePodImages(oldPod)
+	}
+	if reason := model.filter.podReason(newPod); reason != "" {
+		log.Debugf("filtered out pod %s: %s", newPod.QualifiedName(), reason)
+		model.FilteredPods[newPod.QualifiedName()] = &FilteredPod{Pod: newPod, Reason: reason}
+		if hadOldPod {
+			return model.recomputePodPriorities(oldPod)
+		}
+		return nil
+	}
+	delete(model.FilteredPods, newPod.QualifiedName())
 	if len(newPod.Containers) == 0 {
 		recordEvent("adding pod with 0 containers")
 		log.Warnf("adding pod %s with 0 containers: %+v", newPod.QualifiedName(), newPod)
 	}
+	// the pod goes in first, so that its images' priorities take it into
+	// account
+	model.putPod(newPod)
 	errors := []error{}
 	for _, newCont := range newPod.Containers {
-		err := model.addImage(newCont.Image)
+		err := model.addUnfilteredImage(newCont.Image, newPod.Namespace, ImageSourcePod)
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
+	if hadOldPod {
+		err := model.recomputePodPriorities(oldPod)
 		if err != nil {
 			errors = append(errors, err)
 		}
 	}
 	log.Debugf("done adding containers+images from pod %s -- %s", newPod.UID, newPod.QualifiedName())
-	model.Pods[newPod.QualifiedName()] = newPod
 	return combineErrors("adding pod images", errors)
 }
 
+// putPod adds or replaces a pod, keeping imagePods up to date.
+func (model *Model) putPod(pod Pod) {
+	model.removePod(pod.QualifiedName())
+	podName := pod.QualifiedName()
+	model.Pods[podName] = pod
+	for _, cont := range pod.Containers {
+		pods, ok := model.imagePods[cont.Image.Sha]
+		if !ok {
+			pods = map[string]bool{}
+			model.imagePods[cont.Image.Sha] = pods
+		}
+		pods[podName] = true
+	}
+}
+
+// removePod removes a pod, keeping imagePods up to date.  It returns the pod
+// that was removed, if there was one.
+func (model *Model) removePod(podName string) (Pod, bool) {
+	pod, ok := model.Pods[podName]
+	if !ok {
+		return pod, false
+	}
+	delete(model.Pods, podName)
+	for _, cont := range pod.Containers {
+		pods := model.imagePods[cont.Image.Sha]
+		delete(pods, podName)
+		if len(pods) == 0 {
+			delete(model.imagePods, cont.Image.Sha)
+		}
+	}
+	return pod, true
+}
+
 // AddImage adds an image to the model, adding it to the queue for hub checking.
+// Images whose repository is filtered out are set aside in FilteredImages.
 func (model *Model) addImage(image Image) error {
+	if reason := model.filter.imageReason(image); reason != "" {
+		log.Debugf("filtered out image %s: %s", image.Sha, reason)
+		model.FilteredImages[image.Sha] = &FilteredImage{Image: image, Reason: reason}
+		return nil
+	}
+	delete(model.FilteredImages, image.Sha)
+	return model.addUnfilteredImage(image, "", ImageSourceImageStream)
+}
+
+func (model *Model) addUnfilteredImage(image Image, namespace string, source ImageSource) error {
 	log.Debugf("about to add image %s, priority %d", image.Sha, image.Priority)
-	added, err := model.createImage(image)
+	added, err := model.createImage(image, namespace, source)
 	log.Debugf("added image %s? %t", image.Sha, added)
 	return err
 }
@@ -279,9 +810,10 @@ func (model *Model) scanDidFinish(sha DockerImageSha, scanResults *hub.ScanResul
 			return fmt.Errorf("unexpectedly found nil ScanResults for image %s in state %s", sha, imageInfo.ScanStatus)
 		}
 	} else if scanResults.ScanSummaryStatus() == hub.ScanSummaryStatusSuccess {
-		imageInfo.ScanResults = scanResults
+		imageInfo.SetScanResults(scanResults)
 		switch imageInfo.ScanStatus {
-		case ScanStatusUnknown, ScanStatusInQueue, ScanStatusRunningScanClient, ScanStatusRunningHubScan:
+		case ScanStatusUnknown, ScanStatusInQueue, ScanStatusRunningScanClient, ScanStatusRunningHubScan, ScanStatusFailed:
+			imageInfo.resetFailures()
 			return model.setImageScanStatus(sha, ScanStatusComplete)
 		default: // case ScanStatusComplete:
 			return nil // nothing to do
@@ -290,19 +822,50 @@ func (model *Model) scanDidFinish(sha DockerImageSha, scanResults *hub.ScanResul
 		switch imageInfo.ScanStatus {
 		case ScanStatusUnknown, ScanStatusInQueue:
 			return model.setImageScanStatus(sha, ScanStatusRunningHubScan)
-		default: // case ScanStatusRunningScanClient, ScanStatusRunningHubScan, ScanStatusComplete:
+		default: // case ScanStatusRunningScanClient, ScanStatusRunningHubScan, ScanStatusComplete, ScanStatusFailed:
 			return nil // nothing to do
 		}
 	} else { // hub.ScanSummaryStatusFailure
 		switch imageInfo.ScanStatus {
-		case ScanStatusUnknown, ScanStatusRunningHubScan:
+		case ScanStatusUnknown:
 			return model.setImageScanStatus(sha, ScanStatusInQueue)
-		default: // case ScanStatusInQueue, ScanStatusRunningScanClient, ScanStatusComplete:
+		case ScanStatusRunningHubScan:
+			return model.failScan(sha, fmt.Errorf("hub scan failed"))
+		default: // case ScanStatusInQueue, ScanStatusRunningScanClient, ScanStatusComplete, ScanStatusFailed:
 			return fmt.Errorf("cannot handle scanDidFinish %s for image %s: cannot transition from state %s", imageInfo.ScanStatus, sha, imageInfo.ScanStatus.String())
 		}
 	}
 }
 
+func (model *Model) timesOfLastRefresh() map[DockerImageSha]time.Time {
+	timesOfLastRefresh := map[DockerImageSha]time.Time{}
+	for sha, imageInfo := range model.Images {
+		if imageInfo.ScanStatus == ScanStatusComplete {
+			timesOfLastRefresh[sha] = imageInfo.TimeOfLastRefresh
+		}
+	}
+	return timesOfLastRefresh
+}
+
+// scanDidRefresh updates the results of an image whose scan has already
+// completed.  Images in any other state are left alone.
+func (model *Model) scanDidRefresh(sha DockerImageSha, scanResults *hub.ScanResults) error {
+	imageInfo, ok := model.Images[sha]
+	if !ok {
+		return fmt.Errorf("unable to handle scanDidRefresh for %s: sha not found", sha)
+	}
+	if scanResults == nil {
+		return fmt.Errorf("unable to handle scanDidRefresh for %s: nil ScanResults", sha)
+	}
+	if imageInfo.ScanStatus != ScanStatusComplete {
+		// for example, the image has been requeued for a rescan
+		log.Debugf("ignoring scanDidRefresh for image %s in state %s", sha, imageInfo.ScanStatus)
+		return nil
+	}
+	imageInfo.SetScanResults(scanResults)
+	return nil
+}
+
 // DeleteImage removes an image from the model.
 // WARNING: It should ABSOLUTELY NOT be called for images that are still referenced by one or more pods.
 // WARNING: It should *probably* not be called for images in the ScanStatusRunningScanClient
@@ -312,6 +875,7 @@ func (model *Model) deleteImage(sha DockerImageSha) error {
 		return fmt.Errorf("unable to delete image %s, not found", sha)
 	}
 	delete(model.Images, sha)
+	delete(model.imageNamespaces, sha)
 	return nil
 }
 
@@ -321,7 +885,14 @@ func (model *Model) leaveState(sha DockerImageSha, state ScanStatus) error {
 	switch state {
 	case ScanStatusInQueue:
 		return model.removeImageFromScanQueue(sha)
-	case ScanStatusUnknown, ScanStatusRunningScanClient, ScanStatusRunningHubScan, ScanStatusComplete:
+	case ScanStatusRunningScanClient, ScanStatusRunningHubScan:
+		// under fair sharing, this may bring a namespace back under its
+		// in-flight quota
+		if model.fairSharePolicy != nil {
+			model.didMakeImagesAvailable()
+		}
+		return nil
+	case ScanStatusUnknown, ScanStatusComplete, ScanStatusFailed:
 		return nil
 	default:
 		return fmt.Errorf("leaveState: invalid ScanStatus %d", state)
@@ -332,7 +903,7 @@ func (model *Model) enterState(sha DockerImageSha, state ScanStatus) error {
 	switch state {
 	case ScanStatusInQueue:
 		return model.addImageToScanQueue(sha)
-	case ScanStatusUnknown, ScanStatusRunningScanClient, ScanStatusRunningHubScan, ScanStatusComplete:
+	case ScanStatusUnknown, ScanStatusRunningScanClient, ScanStatusRunningHubScan, ScanStatusComplete, ScanStatusFailed:
 		return nil
 	default:
 		return fmt.Errorf("enterState: invalid ScanStatus %d", state)
@@ -364,36 +935,32 @@ func (model *Model) setImageScanStatusForSha(sha DockerImageSha, newScanStatus S
 	return nil
 }
 
-// createImage adds the image to the model, but not to the scan queue
-func (model *Model) createImage(image Image) (bool, error) {
+// createImage adds the image to the model, but not to the scan queue.
+// The image's priority is recomputed, so `image` must already be in
+// model.Pods if it came from a pod.
+func (model *Model) createImage(image Image, namespace string, source ImageSource) (bool, error) {
+	if namespace != "" {
+		model.referenceImageNamespace(image.Sha, namespace)
+	}
 	imageInfo, ok := model.Images[image.Sha]
 	added := !ok
 	if ok {
-		newPriority, oldPriority := image.Priority, imageInfo.Priority
 		log.Debugf("not adding image %s to model, already have in cache", image.PullSpec())
-		if newPriority <= oldPriority {
-			log.Debugf("not decreasing priority for image %s", image.PullSpec())
-			return added, nil
+		imageInfo.TimeOfLastReference = time.Now()
+		if image.ScannerPool != "" && image.ScannerPool != imageInfo.RequestedScannerPool {
+			imageInfo.RequestedScannerPool = image.ScannerPool
+			imageInfo.ScannerPool = image.ScannerPool
 		}
-		if oldPriority < 0 {
-			log.Debugf("not increasing priority for image %s, old priority was %d", image.PullSpec(), oldPriority)
-			return added, nil
+		if source == ImageSourceImageStream {
+			imageInfo.RequestedPriority = image.Priority
 		}
-		log.Debugf("upgrading priority for image %s to %d", image.PullSpec(), image.Priority)
-		imageInfo.SetPriority(image.Priority)
-		if imageInfo.ScanStatus != ScanStatusInQueue {
-			return added, nil
-		}
-		err := model.setImagePriority(image.Sha, image.Priority)
-		if err != nil {
-			return added, errors.Annotatef(err, "unable to set image %s priority in scan queue to %d", image.Sha, image.Priority)
-		}
-		return added, nil
+	} else {
+		newInfo := NewImageInfo(image, &RepoTag{Repository: image.Repository, Tag: image.Tag})
+		newInfo.ScannerPool = model.routingPolicy.evaluate(image)
+		model.Images[image.Sha] = newInfo
+		log.Debugf("added image %s to model", image.PullSpec())
 	}
-	newInfo := NewImageInfo(image, &RepoTag{Repository: image.Repository, Tag: image.Tag})
-	model.Images[image.Sha] = newInfo
-	log.Debugf("added image %s to model", image.PullSpec())
-	return added, nil
+	return added, model.recomputePriority(image.Sha)
 }
 
 // Be sure that `sha` is in `model.Images` before calling this method
@@ -417,16 +984,15 @@ func (model *Model) addImageToScanQueue(sha DockerImageSha) error {
 	if !ok {
 		return fmt.Errorf("unable to add image %s to scan queue: not found", sha)
 	}
-	return model.ImageScanQueue.Add(string(sha), imageInfo.Priority, sha)
+	return model.addToScanQueues(sha, imageInfo.Priority, time.Now())
 }
 
 func (model *Model) setImagePriority(sha DockerImageSha, newPriority int) error {
-	return model.ImageScanQueue.Set(string(sha), newPriority)
+	return model.setScanQueuesPriority(sha, newPriority)
 }
 
 func (model *Model) removeImageFromScanQueue(sha DockerImageSha) error {
-	_, err := model.ImageScanQueue.Remove(string(sha))
-	return err
+	return model.removeFromScanQueues(sha)
 }
 
 // "Public" methods
@@ -447,10 +1013,15 @@ func (model *Model) setImageScanStatus(sha DockerImageSha, newScanStatus ScanSta
 	return nil
 }
 
-// getNextImageFromScanQueue simply returns the item at the front of the scan queue,
-// non-destructively.
-func (model *Model) getNextImageFromScanQueue() (*Image, error) {
-	first := model.ImageScanQueue.Peek()
+// getNextImageFromScanQueue returns the highest priority item in the scan
+// queue that a scanner in `pool` may scan, non-destructively.  In fair share
+// mode, it's from the queue of the namespace whose turn it is -- or, if
+// there's nothing there for `pool`, the next namespace in line.
+func (model *Model) getNextImageFromScanQueue(pool string) (*Image, error) {
+	if model.fairSharePolicy != nil {
+		return model.getNextImageFromFairShareQueues(pool)
+	}
+	first := model.ImageScanQueue.PeekMatching(model.scannerPoolMatcher(pool))
 	switch sha := first.(type) {
 	case DockerImageSha:
 		image := model.unsafeGet(sha).Image()
@@ -472,24 +1043,132 @@ func (model *Model) startScanClient(sha DockerImageSha) error {
 	if imageInfo.ScanStatus != ScanStatusInQueue {
 		return fmt.Errorf("unable to start scan client for image %s, not in state InQueue", sha)
 	}
-	return model.setImageScanStatus(sha, ScanStatusRunningScanClient)
+	err := model.setImageScanStatus(sha, ScanStatusRunningScanClient)
+	if err != nil {
+		return err
+	}
+	if model.fairSharePolicy != nil {
+		namespace := model.imageNamespaces[sha]
+		model.fairShare.charge(namespace, model.fairSharePolicy.Weight(namespace))
+	}
+	return nil
 }
 
 func (model *Model) finishRunningScanClient(image *Image, scanClientError error) error {
-	imageInfo, ok := model.Images[image.Sha]
-
 	// if we don't have this sha already, we don't need to do anything
-	if !ok {
+	if _, ok := model.Images[image.Sha]; !ok {
 		return fmt.Errorf("finish running scan client -- expected to already have image %s, but did not", string(image.Sha))
 	}
 
-	scanStatus := ScanStatusRunningHubScan
 	if scanClientError != nil {
-		imageInfo.SetPriority(-1)
-		scanStatus = ScanStatusInQueue
+		return model.failScan(image.Sha, scanClientError)
+	}
+
+	return model.setImageScanStatus(image.Sha, ScanStatusRunningHubScan)
+}
+
+// findStalledScanClientScans finds images which have been in the
+// RunningScanClient state for longer than `timeout`.  This can happen if a
+// scanner dies after picking up an image, since then nobody will ever report
+// that the scan finished.
+func (model *Model) findStalledScanClientScans(timeout time.Duration) []DockerImageSha {
+	shas := []DockerImageSha{}
+	for sha, imageInfo := range model.Images {
+		if imageInfo.ScanStatus == ScanStatusRunningScanClient && imageInfo.TimeInCurrentScanStatus() > timeout {
+			shas = append(shas, sha)
+		}
+	}
+	return shas
+}
+
+// requeueStalledScanClientScans puts the images back into the scan queue.
+// A stall isn't counted as a failed scan attempt, and the image keeps its
+// priority, since it's most likely the scanner's fault rather than the
+// image's.
+func (model *Model) requeueStalledScanClientScans(shas []DockerImageSha) error {
+	return model.requeueScanClientScans("requeueStalledScanClientScans", "scan client stalled", shas, recordStalledScanClient)
+}
+
+// requeueExpiredLeaseScans moves images whose scanner's lease expired back
+// into the scan queue.  The image keeps its priority.
+func (model *Model) requeueExpiredLeaseScans(shas []DockerImageSha) error {
+	return model.requeueScanClientScans("requeueExpiredLeaseScans", "lease expired", shas, recordExpiredLeaseScan)
+}
+
+// requeueScanClientScans moves the images which are still in a scan client
+// back into the scan queue, calling `didRequeue` for each one.
+func (model *Model) requeueScanClientScans(name string, reason string, shas []DockerImageSha, didRequeue func()) error {
+	errs := []error{}
+	for _, sha := range shas {
+		imageInfo, ok := model.Images[sha]
+		if !ok || imageInfo.ScanStatus != ScanStatusRunningScanClient {
+			continue
+		}
+		log.Warnf("requeueing image %s after %s in a scan client: %s", sha, imageInfo.TimeInCurrentScanStatus(), reason)
+		err := model.setImageScanStatus(sha, ScanStatusInQueue)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		didRequeue()
 	}
+	return combineErrors(name, errs)
+}
+
+// findHubScans returns the images whose in-flight scans were assigned to
+// `hubURL`.
+func (model *Model) findHubScans(hubURL string) []DockerImageSha {
+	shas := []DockerImageSha{}
+	for sha, imageInfo := range model.Images {
+		if imageInfo.HubURL != hubURL {
+			continue
+		}
+		if imageInfo.ScanStatus == ScanStatusRunningScanClient || imageInfo.ScanStatus == ScanStatusRunningHubScan {
+			shas = append(shas, sha)
+		}
+	}
+	return shas
+}
 
-	return model.setImageScanStatus(image.Sha, scanStatus)
+// requeueHubScans puts images whose scans were lost along with their hub
+// back into the scan queue, voiding any leases on them.  Like a stall, it's
+// not counted as a failed scan attempt, and since it's not the image's
+// fault, the image keeps its priority.
+func (model *Model) requeueHubScans(shas []DockerImageSha) error {
+	errs := []error{}
+	for _, sha := range shas {
+		imageInfo, ok := model.Images[sha]
+		if !ok || (imageInfo.ScanStatus != ScanStatusRunningScanClient && imageInfo.ScanStatus != ScanStatusRunningHubScan) {
+			continue
+		}
+		for leaseID, lease := range model.Leases {
+			if lease.Sha == sha {
+				model.dropLease(leaseID)
+			}
+		}
+		err := model.setImageScanStatus(sha, ScanStatusInQueue)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		recordRequeuedHubScan()
+	}
+	return combineErrors("requeueHubScans", errs)
+}
+
+func (model *Model) scanWasDeleted(sha DockerImageSha) error {
+	imageInfo, ok := model.Images[sha]
+	if !ok || imageInfo.ScanStatus != ScanStatusComplete {
+		// most code locations on the hub aren't from images in the model
+		return nil
+	}
+	log.Infof("requeueing image %s: its code location was deleted from the hub", sha)
+	err := model.setImageScanStatus(sha, ScanStatusInQueue)
+	if err != nil {
+		return err
+	}
+	recordDeletedScan()
+	return nil
 }
 
 func (model *Model) getShas(status ScanStatus) []DockerImageSha {
@@ -503,16 +1182,26 @@ func (model *Model) getShas(status ScanStatus) []DockerImageSha {
 }
 
 func (model *Model) deletePod(podName string) error {
-	_, ok := model.Pods[podName]
+	if _, ok := model.FilteredPods[podName]; ok {
+		delete(model.FilteredPods, podName)
+		return nil
+	}
+	pod, ok := model.removePod(podName)
 	if !ok {
 		return fmt.Errorf("unable to delete pod %s, pod not found", podName)
 	}
-	delete(model.Pods, podName)
-	return nil
+	model.dereferencePodImages(pod)
+	return model.recomputePodPriorities(pod)
 }
 
 func (model *Model) allPods(pods []Pod) error {
+	oldPods := model.Pods
+	for _, pod := range oldPods {
+		model.dereferencePodImages(pod)
+	}
 	model.Pods = map[string]Pod{}
+	model.imagePods = map[DockerImageSha]map[string]bool{}
+	model.FilteredPods = map[string]*FilteredPod{}
 	errors := []error{}
 	for _, pod := range pods {
 		err := model.addPod(pod)
@@ -520,6 +1209,17 @@ func (model *Model) allPods(pods []Pod) error {
 			errors = append(errors, err)
 		}
 	}
+	// images which were only in the old pods need their priorities
+	// recomputed too
+	for podName, pod := range oldPods {
+		if _, ok := model.Pods[podName]; ok {
+			continue
+		}
+		err := model.recomputePodPriorities(pod)
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
 	return combineErrors("allPods", errors)
 }
 
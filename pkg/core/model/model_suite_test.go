@@ -32,6 +32,7 @@ func TestModel(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunActionTests()
 	RunModelTests()
+	RunPersistenceTests()
 	RunTestLegalScanStatusTransitions()
 	RunSpecs(t, "model suite")
 }
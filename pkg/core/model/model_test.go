@@ -25,7 +25,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"time"
 
+	"github.com/blackducksoftware/perceptor/pkg/api"
 	"github.com/blackducksoftware/perceptor/pkg/hub"
 	"github.com/blackducksoftware/perceptor/pkg/util"
 	. "github.com/onsi/ginkgo"
@@ -87,20 +89,23 @@ func RunModelTests() {
 
 		It("Image scan failure, then re-receive perceiver event of image -- what's the priority?", func() {
 			model := removeScanItemModel()
-			image, err := model.getNextImageFromScanQueue()
+			image, err := model.getNextImageFromScanQueue("")
 			Expect(*image).To(Equal(image3))
 			Expect(err).To(BeNil())
 
 			Expect(model.startScanClient(image3.Sha)).To(BeNil())
 			Expect(model.Images[image3.Sha].ScanStatus).To(Equal(ScanStatusRunningScanClient))
+			priority := model.Images[image3.Sha].Priority
 
 			Expect(model.finishRunningScanClient(image, fmt.Errorf("planned failure"))).To(BeNil())
-			Expect(model.Images[image3.Sha].ScanStatus).To(Equal(ScanStatusInQueue))
-			Expect(model.Images[image3.Sha].Priority).To(Equal(-1))
+			Expect(model.Images[image3.Sha].ScanStatus).To(Equal(ScanStatusFailed))
+			Expect(model.Images[image3.Sha].Priority).To(Equal(priority))
+			Expect(model.Images[image3.Sha].FailureCount).To(Equal(1))
+			Expect(model.Images[image3.Sha].LastError).To(Equal("planned failure"))
 
 			model.addImage(image3)
-			Expect(model.Images[image3.Sha].ScanStatus).To(Equal(ScanStatusInQueue))
-			Expect(model.Images[image3.Sha].Priority).To(Equal(-1))
+			Expect(model.Images[image3.Sha].ScanStatus).To(Equal(ScanStatusFailed))
+			Expect(model.Images[image3.Sha].Priority).To(Equal(priority))
 		})
 
 		Describe("Image scan queue operations", func() {
@@ -161,5 +166,680 @@ func RunModelTests() {
 				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusComplete))
 			})
 		})
+
+		Describe("Refreshing scan results", func() {
+			It("updates the results and refresh time of completed images", func() {
+				model := NewModel()
+				Expect(model.addImage(image1)).To(BeNil())
+				results := &hub.ScanResults{
+					ScanSummaries: []hub.ScanSummary{{Status: hub.ScanSummaryStatusSuccess}},
+				}
+				Expect(model.scanDidFinish(sha1, results)).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusComplete))
+				firstRefresh := model.Images[sha1].TimeOfLastRefresh
+				Expect(firstRefresh.IsZero()).To(BeFalse())
+
+				refreshed := &hub.ScanResults{
+					ScanSummaries: []hub.ScanSummary{{Status: hub.ScanSummaryStatusSuccess}},
+					PolicyStatus:  hub.PolicyStatus{OverallStatus: hub.PolicyStatusTypeInViolation},
+				}
+				time.Sleep(10 * time.Millisecond)
+				Expect(model.scanDidRefresh(sha1, refreshed)).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusComplete))
+				Expect(model.Images[sha1].ScanResults).To(Equal(refreshed))
+				Expect(model.Images[sha1].TimeOfLastRefresh.After(firstRefresh)).To(BeTrue())
+				Expect(model.timesOfLastRefresh()).To(Equal(map[DockerImageSha]time.Time{sha1: model.Images[sha1].TimeOfLastRefresh}))
+			})
+		})
+
+		Describe("Deleted code locations", func() {
+			It("requeues completed images whose code location was deleted", func() {
+				model := NewModel()
+				Expect(model.addImage(image1)).To(BeNil())
+				Expect(model.addImage(image2)).To(BeNil())
+				results := &hub.ScanResults{
+					ScanSummaries: []hub.ScanSummary{{Status: hub.ScanSummaryStatusSuccess}},
+				}
+				Expect(model.scanDidFinish(sha1, results)).To(BeNil())
+				Expect(model.scanWasDeleted(sha1)).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusInQueue))
+
+				// images which aren't complete, or aren't in the model, are left alone
+				Expect(model.scanWasDeleted(sha2)).To(BeNil())
+				Expect(model.Images[sha2].ScanStatus).To(Equal(ScanStatusUnknown))
+				Expect(model.scanWasDeleted(DockerImageSha("not-an-image"))).To(BeNil())
+			})
+		})
+
+		Describe("Stalled scan clients", func() {
+			It("requeues images which have been in RunningScanClient for too long", func() {
+				model := removeScanItemModel()
+				Expect(model.startScanClient(sha1)).To(BeNil())
+				Expect(model.startScanClient(sha2)).To(BeNil())
+				model.Images[sha1].TimeOfLastStatusChange = time.Now().Add(-2 * time.Hour)
+				priority := model.Images[sha1].Priority
+
+				shas := model.findStalledScanClientScans(time.Hour)
+				Expect(shas).To(Equal([]DockerImageSha{sha1}))
+				Expect(model.requeueStalledScanClientScans(shas)).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusInQueue))
+				Expect(model.Images[sha1].Priority).To(Equal(priority))
+				Expect(model.ImageScanQueue.HasKey(string(sha1))).To(BeTrue())
+				Expect(model.Images[sha2].ScanStatus).To(Equal(ScanStatusRunningScanClient))
+			})
+		})
+
+		Describe("Scan leases", func() {
+			newLease := func(id string, sha DockerImageSha, expiry time.Time) *ScanLease {
+				return &ScanLease{ID: id, Sha: sha, HubURL: "hub1", Expiry: expiry}
+			}
+
+			It("renews and releases valid leases", func() {
+				model := removeScanItemModel()
+				Expect(model.startScanClientWithLease(newLease("lease1", sha1, time.Now().Add(time.Minute)))).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusRunningScanClient))
+
+				lease, err := model.renewLease("lease1", time.Hour)
+				Expect(err).To(BeNil())
+				Expect(lease.Expiry.After(time.Now().Add(59 * time.Minute))).To(BeTrue())
+				Expect(lease.TimeOfLastHeartbeat.IsZero()).To(BeFalse())
+
+				_, err = model.releaseLease("lease1", sha2, nil)
+				Expect(err).To(BeAssignableToTypeOf(&api.LeaseError{}))
+				_, err = model.releaseLease("lease1", sha1, nil)
+				Expect(err).To(BeNil())
+				Expect(model.Leases).To(BeEmpty())
+				_, err = model.renewLease("lease1", time.Hour)
+				Expect(err).NotTo(BeNil())
+			})
+
+			It("rejects expired leases, and leases replaced by newer ones", func() {
+				model := removeScanItemModel()
+				Expect(model.startScanClientWithLease(newLease("lease1", sha1, time.Now().Add(-time.Second)))).To(BeNil())
+				_, err := model.renewLease("lease1", time.Hour)
+				Expect(err).To(BeAssignableToTypeOf(&api.LeaseError{}))
+
+				Expect(model.requeueExpiredLeaseScans([]DockerImageSha{sha1})).To(BeNil())
+				Expect(model.startScanClientWithLease(newLease("lease2", sha1, time.Now().Add(time.Minute)))).To(BeNil())
+				Expect(model.Leases).To(HaveLen(1))
+				_, err = model.releaseLease("lease1", sha1, nil)
+				Expect(err).NotTo(BeNil())
+			})
+
+			It("exchanges each scan token once, while its lease is valid", func() {
+				model := removeScanItemModel()
+				lease1 := newLease("lease1", sha1, time.Now().Add(time.Minute))
+				lease1.ScanToken = "token1"
+				lease2 := newLease("lease2", sha2, time.Now().Add(-time.Second))
+				lease2.ScanToken = "token2"
+				Expect(model.startScanClientWithLease(lease1)).To(BeNil())
+				Expect(model.startScanClientWithLease(lease2)).To(BeNil())
+
+				_, err := model.exchangeScanToken("")
+				Expect(err).NotTo(BeNil())
+				_, err = model.exchangeScanToken("token3")
+				Expect(err).NotTo(BeNil())
+				_, err = model.exchangeScanToken("token2")
+				Expect(err).NotTo(BeNil())
+				lease, err := model.exchangeScanToken("token1")
+				Expect(err).To(BeNil())
+				Expect(lease.ID).To(Equal("lease1"))
+				Expect(lease.TimeOfScanTokenExchange.IsZero()).To(BeFalse())
+				_, err = model.exchangeScanToken("token1")
+				Expect(err).NotTo(BeNil())
+			})
+
+			It("requeues the scans assigned to a hub", func() {
+				model := removeScanItemModel()
+				Expect(model.startScanClientWithLease(newLease("lease1", sha1, time.Now().Add(time.Minute)))).To(BeNil())
+				lease2 := newLease("lease2", sha2, time.Now().Add(time.Minute))
+				lease2.HubURL = "hub2"
+				Expect(model.startScanClientWithLease(lease2)).To(BeNil())
+				Expect(model.startScanClientWithLease(newLease("lease3", sha3, time.Now().Add(time.Minute)))).To(BeNil())
+				_, err := model.releaseLease("lease3", sha3, nil)
+				Expect(err).To(BeNil())
+				Expect(model.finishRunningScanClient(&image3, nil)).To(BeNil())
+				Expect(model.Images[sha3].ScanStatus).To(Equal(ScanStatusRunningHubScan))
+				Expect(model.Images[sha3].HubURL).To(Equal("hub1"))
+
+				shas := model.findHubScans("hub1")
+				Expect(shas).To(ConsistOf(sha1, sha3))
+				Expect(model.requeueHubScans(shas)).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusInQueue))
+				Expect(model.Images[sha1].HubURL).To(Equal(""))
+				Expect(model.Images[sha3].ScanStatus).To(Equal(ScanStatusInQueue))
+				Expect(model.Leases).To(HaveLen(1))
+				Expect(model.Leases).To(HaveKey("lease2"))
+				Expect(model.Images[sha2].HubURL).To(Equal("hub2"))
+				Expect(model.findHubScans("hub1")).To(BeEmpty())
+			})
+
+			It("finds expired leases whose images are still in a scan client", func() {
+				model := removeScanItemModel()
+				Expect(model.startScanClientWithLease(newLease("lease1", sha1, time.Now().Add(-time.Second)))).To(BeNil())
+				Expect(model.startScanClientWithLease(newLease("lease2", sha2, time.Now().Add(time.Minute)))).To(BeNil())
+				Expect(model.startScanClientWithLease(newLease("lease3", sha3, time.Now().Add(-time.Second)))).To(BeNil())
+				Expect(model.finishRunningScanClient(&image3, nil)).To(BeNil())
+
+				leases := model.findExpiredLeases(time.Now())
+				Expect(leases).To(HaveLen(1))
+				Expect(leases[0].ID).To(Equal("lease1"))
+				Expect(model.Leases).To(HaveLen(1))
+				Expect(model.Leases).To(HaveKey("lease2"))
+			})
+		})
+
+		Describe("Scanners", func() {
+			It("tracks each scanner's jobs through its leases", func() {
+				model := removeScanItemModel()
+				Expect(model.registerScanner("", "1.0", []string{})).NotTo(BeNil())
+				Expect(model.registerScanner("scanner1", "1.0", []string{"docker"})).To(BeNil())
+				start := func(leaseID string, sha DockerImageSha) {
+					Expect(model.startScanClientWithLease(&ScanLease{ID: leaseID, Sha: sha, ScannerID: "scanner1", Expiry: time.Now().Add(time.Minute)})).To(BeNil())
+				}
+				start("lease1", sha1)
+				start("lease2", sha2)
+				start("lease3", sha3)
+				Expect(model.Scanners["scanner1"].InFlightJobs).To(HaveLen(3))
+
+				_, err := model.releaseLease("lease1", sha1, nil)
+				Expect(err).To(BeNil())
+				_, err = model.releaseLease("lease2", sha2, fmt.Errorf("planned error"))
+				Expect(err).To(BeNil())
+				model.Leases["lease3"].Expiry = time.Now().Add(-time.Second)
+				Expect(model.findExpiredLeases(time.Now())).To(HaveLen(1))
+
+				scanner := model.Scanners["scanner1"]
+				Expect(scanner.InFlightJobs).To(BeEmpty())
+				Expect(scanner.JobsCompleted).To(Equal(1))
+				Expect(scanner.JobsFailed).To(Equal(2))
+				Expect(scanner.AverageJobDuration()).To(Equal(scanner.TotalJobDuration / 3))
+
+				// re-registering keeps the statistics
+				Expect(model.registerScanner("scanner1", "1.1", []string{"docker", "openshift"})).To(BeNil())
+				Expect(model.Scanners["scanner1"].Version).To(Equal("1.1"))
+				Expect(model.Scanners["scanner1"].JobsFailed).To(Equal(2))
+			})
+
+			It("expires scanners which haven't been seen for a while", func() {
+				model := removeScanItemModel()
+				Expect(model.registerScanner("scanner1", "1.0", []string{})).To(BeNil())
+				model.scannerWasSeen("scanner2")
+				Expect(model.startScanClientWithLease(&ScanLease{ID: "lease1", Sha: sha1, ScannerID: "scanner3", Expiry: time.Now().Add(time.Minute)})).To(BeNil())
+				Expect(model.Scanners).To(HaveLen(3))
+
+				Expect(model.expireScanners(time.Now(), time.Hour)).To(BeEmpty())
+				later := time.Now().Add(2 * time.Hour)
+				ids := model.expireScanners(later, time.Hour)
+				sort.Strings(ids)
+				Expect(ids).To(Equal([]string{"scanner1", "scanner2"}))
+				// scanner3 is still working on its job
+				Expect(model.Scanners).To(HaveLen(1))
+				Expect(model.Scanners).To(HaveKey("scanner3"))
+			})
+
+			It("adds scanners which haven't registered when they're first seen", func() {
+				model := NewModel()
+				Expect(model.scannerWasSeen("")).To(BeNil())
+				Expect(model.scannerWasSeen("scanner2").Capabilities).To(Equal([]string{}))
+				Expect(model.Scanners).To(HaveKey("scanner2"))
+			})
+		})
+
+		Describe("Failed scans", func() {
+			failImage := func(model *Model, image Image) {
+				if model.Images[image.Sha].ScanStatus != ScanStatusInQueue {
+					Expect(model.setImageScanStatus(image.Sha, ScanStatusInQueue)).To(BeNil())
+				}
+				Expect(model.startScanClient(image.Sha)).To(BeNil())
+				Expect(model.finishRunningScanClient(&image, fmt.Errorf("unable to pull"))).To(BeNil())
+			}
+
+			It("backs off exponentially, up to the max delay", func() {
+				policy := &ScanRetryPolicy{BaseDelay: time.Minute, MaxDelay: 5 * time.Minute, MaxAttempts: 10}
+				Expect(policy.Delay(1)).To(Equal(time.Minute))
+				Expect(policy.Delay(2)).To(Equal(2 * time.Minute))
+				Expect(policy.Delay(3)).To(Equal(4 * time.Minute))
+				Expect(policy.Delay(4)).To(Equal(5 * time.Minute))
+				Expect(policy.Delay(20)).To(Equal(5 * time.Minute))
+			})
+
+			It("retries failed images once their backoff is over", func() {
+				model := NewModel()
+				model.scanRetryPolicy = &ScanRetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour, MaxAttempts: 3}
+				Expect(model.addImage(image1)).To(BeNil())
+				failImage(model, image1)
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusFailed))
+				Expect(model.ImageScanQueue.HasKey(string(sha1))).To(BeFalse())
+
+				Expect(model.findFailedScansToRetry(time.Now())).To(Equal([]DockerImageSha{}))
+				shas := model.findFailedScansToRetry(time.Now().Add(61 * time.Second))
+				Expect(shas).To(Equal([]DockerImageSha{sha1}))
+				Expect(model.retryFailedScans(shas)).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusInQueue))
+				Expect(model.ImageScanQueue.HasKey(string(sha1))).To(BeTrue())
+
+				failImage(model, image1)
+				Expect(model.findFailedScansToRetry(time.Now().Add(61 * time.Second))).To(Equal([]DockerImageSha{}))
+				Expect(model.findFailedScansToRetry(time.Now().Add(121 * time.Second))).To(Equal([]DockerImageSha{sha1}))
+			})
+
+			It("parks images which have used up their attempts", func() {
+				model := NewModel()
+				model.scanRetryPolicy = &ScanRetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour, MaxAttempts: 1}
+				Expect(model.addImage(image1)).To(BeNil())
+				failImage(model, image1)
+				Expect(model.findFailedScansToRetry(time.Now().Add(24 * time.Hour))).To(Equal([]DockerImageSha{}))
+
+				failed := failedScans(model)
+				Expect(len(failed.Images)).To(Equal(1))
+				Expect(failed.Images[0].Sha).To(Equal(string(sha1)))
+				Expect(failed.Images[0].IsParked).To(BeTrue())
+				Expect(failed.Images[0].LastError).To(Equal("unable to pull"))
+				Expect(failed.Images[0].NextRetry).To(Equal(""))
+			})
+
+			It("starts counting failures from scratch after a successful scan", func() {
+				model := NewModel()
+				model.scanRetryPolicy = &ScanRetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour, MaxAttempts: 2}
+				Expect(model.addImage(image1)).To(BeNil())
+				failImage(model, image1)
+				Expect(model.retryFailedScans([]DockerImageSha{sha1})).To(BeNil())
+				Expect(model.startScanClient(sha1)).To(BeNil())
+				Expect(model.finishRunningScanClient(&image1, nil)).To(BeNil())
+				results := &hub.ScanResults{
+					ScanSummaries: []hub.ScanSummary{{Status: hub.ScanSummaryStatusSuccess}},
+				}
+				Expect(model.scanDidFinish(sha1, results)).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusComplete))
+				Expect(model.Images[sha1].FailureCount).To(Equal(0))
+
+				// later, the image is requeued and fails again
+				Expect(model.scanWasDeleted(sha1)).To(BeNil())
+				failImage(model, image1)
+				Expect(model.Images[sha1].FailureCount).To(Equal(1))
+				Expect(model.findFailedScansToRetry(time.Now().Add(61 * time.Second))).To(Equal([]DockerImageSha{sha1}))
+			})
+		})
+
+		Describe("Rescans", func() {
+			completeImage := func(model *Model, sha DockerImageSha) {
+				results := &hub.ScanResults{
+					ScanSummaries: []hub.ScanSummary{{Status: hub.ScanSummaryStatusSuccess}},
+				}
+				Expect(model.scanDidFinish(sha, results)).To(BeNil())
+				Expect(model.Images[sha].ScanStatus).To(Equal(ScanStatusComplete))
+			}
+
+			It("requeues a completed image at the requested priority", func() {
+				model := NewModel()
+				Expect(model.addImage(image1)).To(BeNil())
+				completeImage(model, sha1)
+
+				priority := 10
+				rescan := &Rescan{Sha: sha1, Priority: &priority, Requester: "security-team"}
+				Expect(model.rescan(rescan)).To(BeNil())
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusInQueue))
+				Expect(model.Images[sha1].Priority).To(Equal(10))
+				Expect(model.ImageScanQueue.Dump()[0]["Priority"]).To(Equal(10))
+				Expect(rescan.Shas).To(Equal([]DockerImageSha{sha1}))
+				Expect(model.Rescans).To(Equal([]*Rescan{rescan}))
+			})
+
+			It("rescans every image in a namespace, skipping scans in progress", func() {
+				model := NewModel()
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(model.addPod(pod3)).To(BeNil())
+				completeImage(model, sha1)
+				Expect(model.setImageScanStatus(sha2, ScanStatusInQueue)).To(BeNil())
+				Expect(model.startScanClient(sha2)).To(BeNil())
+				completeImage(model, sha3)
+
+				oldPriority := model.Images[sha2].Priority
+				priority := 10
+				rescan := &Rescan{Namespace: "ns1", Priority: &priority, Requester: "security-team"}
+				Expect(model.rescan(rescan)).To(BeNil())
+				Expect(rescan.Shas).To(Equal([]DockerImageSha{sha1}))
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusInQueue))
+				Expect(model.Images[sha2].ScanStatus).To(Equal(ScanStatusRunningScanClient))
+				Expect(model.Images[sha2].Priority).To(Equal(oldPriority))
+				Expect(model.Images[sha3].ScanStatus).To(Equal(ScanStatusComplete))
+			})
+
+			It("gives failed images a fresh set of attempts", func() {
+				model := NewModel()
+				Expect(model.addPod(pod3)).To(BeNil())
+				Expect(model.setImageScanStatus(sha3, ScanStatusInQueue)).To(BeNil())
+				Expect(model.startScanClient(sha3)).To(BeNil())
+				Expect(model.finishRunningScanClient(&image3, fmt.Errorf("unable to pull"))).To(BeNil())
+				Expect(model.Images[sha3].ScanStatus).To(Equal(ScanStatusFailed))
+
+				Expect(model.rescan(&Rescan{PodName: pod3.QualifiedName(), Requester: "security-team"})).To(BeNil())
+				Expect(model.Images[sha3].ScanStatus).To(Equal(ScanStatusInQueue))
+				Expect(model.Images[sha3].FailureCount).To(Equal(0))
+			})
+
+			It("rejects unknown images and pods", func() {
+				model := NewModel()
+				Expect(model.rescan(&Rescan{Sha: sha1})).To(BeAssignableToTypeOf(&api.NotFoundError{}))
+				Expect(model.rescan(&Rescan{PodName: "ns1/nope"})).To(BeAssignableToTypeOf(&api.NotFoundError{}))
+				Expect(model.rescan(&Rescan{Namespace: "ns1"})).To(BeAssignableToTypeOf(&api.NotFoundError{}))
+				Expect(model.rescan(&Rescan{})).NotTo(BeNil())
+				Expect(model.rescan(&Rescan{})).NotTo(BeAssignableToTypeOf(&api.NotFoundError{}))
+			})
+		})
+
+		Describe("Orphaned image pruning", func() {
+			It("prunes unreferenced images once the grace period is over", func() {
+				model := NewModel()
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(model.addImage(image3)).To(BeNil())
+				Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+				Expect(model.setImageScanStatus(sha3, ScanStatusInQueue)).To(BeNil())
+				Expect(model.deletePod(pod1.QualifiedName())).To(BeNil())
+
+				Expect(model.findOrphanedImages(time.Hour)).To(Equal([]DockerImageSha{}))
+
+				shas := model.findOrphanedImages(0)
+				sort.Slice(shas, func(i int, j int) bool { return shas[i] < shas[j] })
+				Expect(shas).To(Equal([]DockerImageSha{sha1, sha2, sha3}))
+				Expect(model.pruneImages(shas)).To(BeNil())
+				Expect(len(model.Images)).To(Equal(0))
+				Expect(model.ImageScanQueue.Size()).To(Equal(0))
+				Expect(len(model.ImagePrunes)).To(Equal(3))
+			})
+
+			It("starts the grace period when an image's last pod is removed", func() {
+				model := NewModel()
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+				// the pod has been running for a long time
+				for _, imageInfo := range model.Images {
+					imageInfo.TimeOfLastReference = time.Now().Add(-24 * time.Hour)
+				}
+				Expect(model.findOrphanedImages(time.Hour)).To(Equal([]DockerImageSha{}))
+
+				Expect(model.deletePod(pod1.QualifiedName())).To(BeNil())
+				Expect(model.findOrphanedImages(time.Hour)).To(Equal([]DockerImageSha{}))
+				Expect(len(model.findOrphanedImages(0))).To(Equal(2))
+			})
+
+			It("leaves images in pods and images being scanned alone", func() {
+				model := NewModel()
+				Expect(model.addPod(pod3)).To(BeNil())
+				Expect(model.addImage(image1)).To(BeNil())
+				Expect(model.addImage(image2)).To(BeNil())
+				Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+				Expect(model.startScanClient(sha1)).To(BeNil())
+				Expect(model.setImageScanStatus(sha2, ScanStatusInQueue)).To(BeNil())
+				Expect(model.startScanClient(sha2)).To(BeNil())
+				Expect(model.finishRunningScanClient(&image2, nil)).To(BeNil())
+
+				Expect(model.findOrphanedImages(0)).To(Equal([]DockerImageSha{}))
+			})
+		})
+
+		Describe("Filtering", func() {
+			It("matches globs and regular expressions", func() {
+				filter, err := NewFilter([]string{"tenant-*", "regex:^team-[0-9]+$"}, []string{"tenant-test"}, nil, nil)
+				Expect(err).To(BeNil())
+				Expect(filter.podReason(Pod{Namespace: "tenant-a"})).To(Equal(""))
+				Expect(filter.podReason(Pod{Namespace: "team-42"})).To(Equal(""))
+				Expect(filter.podReason(Pod{Namespace: "tenant-test"})).To(Equal("namespace tenant-test matches exclude pattern tenant-test"))
+				Expect(filter.podReason(Pod{Namespace: "kube-system"})).To(Equal("namespace kube-system doesn't match any include pattern"))
+
+				_, err = NewFilter(nil, []string{"regex:("}, nil, nil)
+				Expect(err).NotTo(BeNil())
+			})
+
+			It("sets aside filtered pods and images without creating their images", func() {
+				model := NewModel()
+				filter, err := NewFilter(nil, []string{"ns3"}, nil, []string{"image*"})
+				Expect(err).To(BeNil())
+				Expect(model.setFilter(filter)).To(BeNil())
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(model.addPod(pod3)).To(BeNil())
+				Expect(model.addImage(image3)).To(BeNil())
+				Expect(len(model.Pods)).To(Equal(1))
+				Expect(model.FilteredPods[pod3.QualifiedName()].Reason).To(Equal("namespace ns3 matches exclude pattern ns3"))
+				Expect(model.FilteredImages[sha3].Image).To(Equal(image3))
+				_, ok := model.Images[sha3]
+				Expect(ok).To(BeFalse())
+				// repository rules don't apply to the images in a pod
+				Expect(len(model.Images)).To(Equal(2))
+
+				Expect(model.deletePod(pod3.QualifiedName())).To(BeNil())
+				Expect(len(model.FilteredPods)).To(Equal(0))
+			})
+
+			It("re-applies the rules when the filter changes", func() {
+				model := NewModel()
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(model.addPod(pod3)).To(BeNil())
+				Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+				Expect(model.setImageScanStatus(sha2, ScanStatusInQueue)).To(BeNil())
+				Expect(model.startScanClient(sha2)).To(BeNil())
+
+				filter, err := NewFilter(nil, []string{"ns1"}, nil, nil)
+				Expect(err).To(BeNil())
+				Expect(model.setFilter(filter)).To(BeNil())
+				Expect(len(model.Pods)).To(Equal(1))
+				Expect(len(model.FilteredPods)).To(Equal(1))
+				// queued images are dropped, but scans in progress are left alone
+				_, ok := model.Images[sha1]
+				Expect(ok).To(BeFalse())
+				Expect(model.ImageScanQueue.HasKey(string(sha1))).To(BeFalse())
+				Expect(model.Images[sha2].ScanStatus).To(Equal(ScanStatusRunningScanClient))
+
+				Expect(model.setFilter(nil)).To(BeNil())
+				Expect(len(model.Pods)).To(Equal(2))
+				Expect(len(model.FilteredPods)).To(Equal(0))
+				Expect(model.Images[sha1].ScanStatus).To(Equal(ScanStatusUnknown))
+			})
+		})
+
+		Describe("Priority policies", func() {
+			newPolicy := func() *PriorityPolicy {
+				tenant, err := NewPriorityRule("tenants", "ns1", "", "", ImageSourcePod, 10)
+				Expect(err).To(BeNil())
+				latest, err := NewPriorityRule("latest", "", "", "latest", "", 5)
+				Expect(err).To(BeNil())
+				imageStreams, err := NewPriorityRule("image streams", "", "image*", "", ImageSourceImageStream, 0)
+				Expect(err).To(BeNil())
+				return NewPriorityPolicy([]*PriorityRule{tenant, latest, imageStreams})
+			}
+
+			It("uses the first matching rule", func() {
+				policy := newPolicy()
+				priority, rule := policy.evaluate(image3, "ns1", ImageSourcePod)
+				Expect([]interface{}{priority, rule}).To(Equal([]interface{}{10, "tenants"}))
+				priority, rule = policy.evaluate(*NewImage("abc", "latest", sha3, 1, "", ""), "ns3", ImageSourcePod)
+				Expect([]interface{}{priority, rule}).To(Equal([]interface{}{5, "latest"}))
+				priority, rule = policy.evaluate(image3, "", ImageSourceImageStream)
+				Expect([]interface{}{priority, rule}).To(Equal([]interface{}{0, "image streams"}))
+				priority, rule = policy.evaluate(image3, "ns3", ImageSourcePod)
+				Expect([]interface{}{priority, rule}).To(Equal([]interface{}{3, priorityRuleDefault}))
+
+				_, err := NewPriorityRule("bad", "", "", "", ImageSource("Deployment"), 1)
+				Expect(err).NotTo(BeNil())
+			})
+
+			It("computes priorities when images are added", func() {
+				model := NewModel()
+				Expect(model.setPriorityPolicy(newPolicy())).To(BeNil())
+				Expect(model.addImage(image3)).To(BeNil())
+				Expect(model.Images[sha3].Priority).To(Equal(0))
+				Expect(model.Images[sha3].PriorityRule).To(Equal("image streams"))
+				Expect(model.Images[sha3].RequestedPriority).To(Equal(3))
+
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(model.Images[sha1].Priority).To(Equal(10))
+				Expect(model.Images[sha1].PriorityRule).To(Equal("tenants"))
+			})
+
+			It("recomputes priorities, including lowering them, when the policy changes", func() {
+				model := NewModel()
+				Expect(model.setPriorityPolicy(newPolicy())).To(BeNil())
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(model.addImage(image3)).To(BeNil())
+				Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+				Expect(model.setImageScanStatus(sha3, ScanStatusInQueue)).To(BeNil())
+
+				Expect(model.setPriorityPolicy(nil)).To(BeNil())
+				Expect(model.Images[sha1].Priority).To(Equal(1))
+				Expect(model.Images[sha1].PriorityRule).To(Equal(priorityRuleDefault))
+				Expect(model.Images[sha3].Priority).To(Equal(3))
+				Expect(model.ImageScanQueue.Values()).To(Equal([]interface{}{sha3, sha1}))
+			})
+
+			It("recomputes priorities when pods go away or images are re-sent", func() {
+				model := NewModel()
+				Expect(model.setPriorityPolicy(newPolicy())).To(BeNil())
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+				Expect(model.Images[sha1].Priority).To(Equal(10))
+
+				Expect(model.deletePod(pod1.QualifiedName())).To(BeNil())
+				Expect(model.Images[sha1].Priority).To(Equal(0))
+				Expect(model.Images[sha1].PriorityRule).To(Equal("image streams"))
+				Expect(model.ImageScanQueue.Dump()[0]["Priority"]).To(Equal(0))
+
+				Expect(model.setPriorityPolicy(nil)).To(BeNil())
+				Expect(model.addImage(image3)).To(BeNil())
+				Expect(model.Images[sha3].Priority).To(Equal(3))
+				lower := image3
+				lower.Priority = 1
+				Expect(model.addImage(lower)).To(BeNil())
+				Expect(model.Images[sha3].Priority).To(Equal(1))
+				Expect(model.Images[sha3].PriorityRule).To(Equal(priorityRuleDefault))
+			})
+		})
+
+		Describe("Scanner pools", func() {
+			It("routes images by rule, unless they come with a pool", func() {
+				rule, err := NewRoutingRule("regex:^image[12]$", "private")
+				Expect(err).To(BeNil())
+				_, err = NewRoutingRule("image1", "")
+				Expect(err).NotTo(BeNil())
+				policy := NewRoutingPolicy([]*RoutingRule{rule})
+				Expect(policy.evaluate(image1)).To(Equal("private"))
+				Expect(policy.evaluate(image3)).To(Equal(""))
+				highMemory := image3
+				highMemory.ScannerPool = "high-memory"
+				Expect(policy.evaluate(highMemory)).To(Equal("high-memory"))
+				Expect((*RoutingPolicy)(nil).evaluate(image1)).To(Equal(""))
+			})
+
+			It("only hands out images that the scanner's pool may scan", func() {
+				model := removeScanItemModel()
+				rule, err := NewRoutingRule("image3", "private")
+				Expect(err).To(BeNil())
+				model.setRoutingPolicy(NewRoutingPolicy([]*RoutingRule{rule}))
+				Expect(model.Images[sha3].ScannerPool).To(Equal("private"))
+
+				image, err := model.getNextImageFromScanQueue("")
+				Expect(err).To(BeNil())
+				Expect(image.Sha).To(Equal(sha2))
+				image, err = model.getNextImageFromScanQueue("private")
+				Expect(err).To(BeNil())
+				Expect(image.Sha).To(Equal(sha3))
+
+				Expect(model.startScanClient(sha1)).To(BeNil())
+				Expect(model.startScanClient(sha2)).To(BeNil())
+				image, err = model.getNextImageFromScanQueue("")
+				Expect(err).To(BeNil())
+				Expect(image).To(BeNil())
+
+				model.setFairSharePolicy(&FairSharePolicy{})
+				image, err = model.getNextImageFromScanQueue("public")
+				Expect(err).To(BeNil())
+				Expect(image).To(BeNil())
+				image, err = model.getNextImageFromScanQueue("private")
+				Expect(err).To(BeNil())
+				Expect(image.Sha).To(Equal(sha3))
+			})
+		})
+
+		Describe("Priority aging", func() {
+			It("reports the longest wait in the scan queue", func() {
+				model := NewModel()
+				Expect(model.addPod(pod1)).To(BeNil())
+				Expect(metrics(model).MaxQueueWait).To(Equal(time.Duration(0)))
+				Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+				Expect(model.setImageScanStatus(sha2, ScanStatusInQueue)).To(BeNil())
+				model.Images[sha1].TimeOfLastStatusChange = time.Now().Add(-time.Hour)
+				Expect(metrics(model).MaxQueueWait).To(BeNumerically(">=", time.Hour))
+			})
+
+			It("shows effective priorities in the model", func() {
+				model := NewModel()
+				Expect(model.addImage(image1)).To(BeNil())
+				Expect(model.removeImageFromScanQueue(sha1)).NotTo(BeNil())
+				Expect(model.ImageScanQueue.AddAt(string(sha1), 1, sha1, time.Now().Add(-time.Hour))).To(BeNil())
+				model.Images[sha1].ScanStatus = ScanStatusInQueue
+				model.ImageScanQueue.SetAgingInterval(10 * time.Minute)
+				Expect(coreModelToAPIModel(model).Images[string(sha1)].EffectivePriority).To(Equal(7))
+			})
+		})
+
+		Describe("Fair share", func() {
+			newTeamPod := func(namespace string, shas ...string) Pod {
+				containers := []Container{}
+				for _, sha := range shas {
+					containers = append(containers, *NewContainer(*NewImage("repo-"+sha, "1", DockerImageSha(sha), 1, "", ""), sha))
+				}
+				return *NewPod("pod", namespace+"-uid", namespace, containers)
+			}
+			queueAll := func(model *Model) {
+				for sha := range model.Images {
+					Expect(model.setImageScanStatus(sha, ScanStatusInQueue)).To(BeNil())
+				}
+			}
+			dispatch := func(model *Model, count int) map[string]int {
+				scans := map[string]int{}
+				for i := 0; i < count; i++ {
+					image, err := model.getNextImageFromScanQueue("")
+					Expect(err).To(BeNil())
+					if image == nil {
+						break
+					}
+					Expect(model.startScanClient(image.Sha)).To(BeNil())
+					scans[model.imageNamespaces[image.Sha]]++
+				}
+				return scans
+			}
+
+			It("takes turns between namespaces, according to their weights", func() {
+				model := NewModel()
+				Expect(model.addPod(newTeamPod("a", "a1", "a2", "a3", "a4", "a5", "a6"))).To(BeNil())
+				Expect(model.addPod(newTeamPod("b", "b1", "b2", "b3", "b4", "b5", "b6"))).To(BeNil())
+				queueAll(model)
+				model.setFairSharePolicy(&FairSharePolicy{DefaultWeight: 1, Weights: map[string]int{"a": 2}})
+				Expect(dispatch(model, 6)).To(Equal(map[string]int{"a": 4, "b": 2}))
+				Expect(model.namespaceQueueDepths()).To(Equal(map[string]int{"a": 2, "b": 4}))
+			})
+
+			It("queues a shared image under its alphabetically first namespace", func() {
+				model := NewModel()
+				Expect(model.addPod(newTeamPod("b", "shared"))).To(BeNil())
+				Expect(model.addPod(newTeamPod("a", "shared"))).To(BeNil())
+				Expect(model.addPod(newTeamPod("c", "shared"))).To(BeNil())
+				queueAll(model)
+				Expect(model.namespaceQueueDepths()).To(Equal(map[string]int{"a": 1}))
+			})
+
+			It("counts shared images once, and respects in-flight quotas", func() {
+				model := NewModel()
+				Expect(model.addPod(newTeamPod("a", "shared", "a1"))).To(BeNil())
+				Expect(model.addPod(newTeamPod("b", "shared", "b1", "b2"))).To(BeNil())
+				queueAll(model)
+				Expect(model.namespaceQueueDepths()).To(Equal(map[string]int{"a": 2, "b": 2}))
+
+				model.setFairSharePolicy(&FairSharePolicy{InFlightQuotas: map[string]int{"a": 1}})
+				Expect(dispatch(model, 10)).To(Equal(map[string]int{"a": 1, "b": 2}))
+				Expect(model.inFlightScans()).To(Equal(map[string]int{"a": 1, "b": 2}))
+				Expect(model.namespaceQueueDepths()).To(Equal(map[string]int{"a": 1}))
+			})
+		})
 	})
 }
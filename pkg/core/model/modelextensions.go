@@ -23,6 +23,7 @@ package model
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/blackducksoftware/perceptor/pkg/api" // TODO I hate how this package depends on the api package
 	"github.com/blackducksoftware/perceptor/pkg/hub"
@@ -127,6 +128,32 @@ func scanResults(model *Model) (api.ScanResults, error) {
 	return *api.NewScanResults(pods, images), combineErrors("scanResults", errors)
 }
 
+func failedScans(model *Model) api.FailedScans {
+	images := []api.FailedScan{}
+	for sha, imageInfo := range model.Images {
+		if imageInfo.ScanStatus != ScanStatusFailed {
+			continue
+		}
+		repoTag := imageInfo.FirstRepoTag()
+		nextRetryString := ""
+		nextRetry, ok := model.scanRetryPolicy.NextRetry(imageInfo)
+		if ok {
+			nextRetryString = nextRetry.String()
+		}
+		images = append(images, api.FailedScan{
+			Sha:               string(sha),
+			Repository:        repoTag.Repository,
+			Tag:               repoTag.Tag,
+			FailureCount:      imageInfo.FailureCount,
+			LastError:         imageInfo.LastError,
+			TimeOfLastFailure: imageInfo.TimeOfLastFailure.String(),
+			NextRetry:         nextRetryString,
+			IsParked:          !ok,
+		})
+	}
+	return *api.NewFailedScans(images)
+}
+
 func coreContainerToAPIContainer(coreContainer Container) *api.Container {
 	image := coreContainer.Image
 	priority := image.Priority
@@ -156,8 +183,16 @@ func coreModelToAPIModel(model *Model) *api.CoreModel {
 		pods[podName] = corePodToAPIPod(pod)
 	}
 	// images
+	now := time.Now()
 	images := map[string]*api.ModelImageInfo{}
 	for imageSha, imageInfo := range model.Images {
+		effectivePriority := imageInfo.Priority
+		if imageInfo.ScanStatus == ScanStatusInQueue {
+			priority, err := model.ImageScanQueue.EffectivePriority(string(imageSha), now)
+			if err == nil {
+				effectivePriority = priority
+			}
+		}
 		repoTags := []*api.ModelRepoTag{}
 		for _, repoTag := range imageInfo.RepoTags {
 			repoTags = append(repoTags, &api.ModelRepoTag{Repository: repoTag.Repository, Tag: repoTag.Tag})
@@ -168,7 +203,16 @@ func coreModelToAPIModel(model *Model) *api.CoreModel {
 			ScanResults:            imageInfo.ScanResults,
 			ScanStatus:             imageInfo.ScanStatus.String(),
 			TimeOfLastStatusChange: imageInfo.TimeOfLastStatusChange.String(),
+			TimeOfLastReference:    imageInfo.TimeOfLastReference.String(),
 			Priority:               imageInfo.Priority,
+			EffectivePriority:      effectivePriority,
+			RequestedPriority:      imageInfo.RequestedPriority,
+			PriorityRule:           imageInfo.PriorityRule,
+			ScannerPool:            imageInfo.ScannerPool,
+			HubURL:                 imageInfo.HubURL,
+			FailureCount:           imageInfo.FailureCount,
+			LastError:              imageInfo.LastError,
+			TimeOfLastFailure:      imageInfo.TimeOfLastFailure.String(),
 		}
 	}
 	// image transitions
@@ -186,15 +230,117 @@ func coreModelToAPIModel(model *Model) *api.CoreModel {
 			Time: it.Time.String(),
 		}
 	}
+	// image prunes
+	imagePrunes := make([]*api.ModelImagePrune, len(model.ImagePrunes))
+	for ix, ip := range model.ImagePrunes {
+		imagePrunes[ix] = &api.ModelImagePrune{
+			Sha:                 string(ip.Sha),
+			ScanStatus:          ip.ScanStatus.String(),
+			TimeOfLastReference: ip.TimeOfLastReference.String(),
+			Time:                ip.Time.String(),
+		}
+	}
+	// rescans
+	rescans := make([]*api.ModelRescan, len(model.Rescans))
+	for ix, rescan := range model.Rescans {
+		shas := make([]string, len(rescan.Shas))
+		for j, sha := range rescan.Shas {
+			shas[j] = string(sha)
+		}
+		rescans[ix] = &api.ModelRescan{
+			Sha:       string(rescan.Sha),
+			PodName:   rescan.PodName,
+			Namespace: rescan.Namespace,
+			Priority:  rescan.Priority,
+			Requester: rescan.Requester,
+			Time:      rescan.Time.String(),
+			Shas:      shas,
+		}
+	}
+	// filtered pods and images
+	filteredPods := map[string]*api.ModelFilteredPod{}
+	for podName, filteredPod := range model.FilteredPods {
+		filteredPods[podName] = &api.ModelFilteredPod{
+			Pod:    corePodToAPIPod(filteredPod.Pod),
+			Reason: filteredPod.Reason,
+		}
+	}
+	filteredImages := map[string]*api.ModelFilteredImage{}
+	for sha, filteredImage := range model.FilteredImages {
+		filteredImages[string(sha)] = &api.ModelFilteredImage{
+			Repository: filteredImage.Image.Repository,
+			Tag:        filteredImage.Image.Tag,
+			Reason:     filteredImage.Reason,
+		}
+	}
+	// namespace queues
+	namespaceQueues := map[string]*api.ModelNamespaceQueue{}
+	inFlight := model.inFlightScans()
+	for namespace, depth := range model.namespaceQueueDepths() {
+		namespaceQueues[namespace] = &api.ModelNamespaceQueue{Depth: depth}
+	}
+	for namespace, count := range inFlight {
+		if _, ok := namespaceQueues[namespace]; !ok {
+			namespaceQueues[namespace] = &api.ModelNamespaceQueue{}
+		}
+		namespaceQueues[namespace].InFlight = count
+	}
+	if model.fairSharePolicy != nil {
+		for namespace, queue := range namespaceQueues {
+			queue.Weight = model.fairSharePolicy.Weight(namespace)
+			queue.InFlightQuota = model.fairSharePolicy.InFlightQuota(namespace)
+		}
+	}
+	// leases
+	leases := map[string]*api.ModelLease{}
+	for leaseID, lease := range model.Leases {
+		leases[leaseID] = &api.ModelLease{
+			Sha:                 string(lease.Sha),
+			HubURL:              lease.HubURL,
+			ScannerID:           lease.ScannerID,
+			Expiry:              lease.Expiry.String(),
+			TimeOfLastHeartbeat: lease.TimeOfLastHeartbeat.String(),
+		}
+	}
 	// return value
 	return &api.CoreModel{
 		Pods:             pods,
 		Images:           images,
 		ImageScanQueue:   model.ImageScanQueue.Dump(),
 		ImageTransitions: imageTransitions,
+		ImagePrunes:      imagePrunes,
+		Rescans:          rescans,
+		FilteredPods:     filteredPods,
+		FilteredImages:   filteredImages,
+		NamespaceQueues:  namespaceQueues,
+		FairShare:        model.fairSharePolicy != nil,
+		Leases:           leases,
 	}
 }
 
+func apiScanners(model *Model) map[string]*api.ModelScanner {
+	scanners := map[string]*api.ModelScanner{}
+	for id, scanner := range model.Scanners {
+		inFlightJobs := map[string]string{}
+		for sha, start := range scanner.InFlightJobs {
+			inFlightJobs[string(sha)] = start.String()
+		}
+		capabilities := make([]string, len(scanner.Capabilities))
+		copy(capabilities, scanner.Capabilities)
+		scanners[id] = &api.ModelScanner{
+			Version:            scanner.Version,
+			Capabilities:       capabilities,
+			TimeOfRegistration: scanner.TimeOfRegistration.String(),
+			LastSeen:           scanner.LastSeen.String(),
+			InFlightJobs:       inFlightJobs,
+			JobsCompleted:      scanner.JobsCompleted,
+			JobsFailed:         scanner.JobsFailed,
+			AverageJobDuration: *api.NewModelTime(scanner.AverageJobDuration()),
+		}
+	}
+	return scanners
+}
+
 func metrics(model *Model) *Metrics {
 	// number of images in each status
 	statusCounts := make(map[ScanStatus]int)
@@ -243,7 +389,11 @@ func metrics(model *Model) *Metrics {
 	imageStatus := map[string]int{}
 	imagePolicyViolations := map[int]int{}
 	imageVulnerabilities := map[int]int{}
+	maxQueueWait := time.Duration(0)
 	for sha, imageInfo := range model.Images {
+		if imageInfo.ScanStatus == ScanStatusInQueue && imageInfo.TimeInCurrentScanStatus() > maxQueueWait {
+			maxQueueWait = imageInfo.TimeInCurrentScanStatus()
+		}
 		if imageInfo.ScanStatus == ScanStatusComplete {
 			imageScan := imageInfo.ScanResults
 			if imageScan == nil {
@@ -272,5 +422,6 @@ func metrics(model *Model) *Metrics {
 		ImagePolicyViolations: imagePolicyViolations,
 		PodVulnerabilities:    podVulnerabilities,
 		ImageVulnerabilities:  imageVulnerabilities,
+		MaxQueueWait:          maxQueueWait,
 	}
 }
@@ -21,6 +21,8 @@ under the License.
 
 package model
 
+import "time"
+
 // Metrics .....
 type Metrics struct {
 	ScanStatusCounts      map[ScanStatus]int
@@ -34,4 +36,5 @@ type Metrics struct {
 	ImagePolicyViolations map[int]int
 	PodVulnerabilities    map[int]int
 	ImageVulnerabilities  map[int]int
+	MaxQueueWait          time.Duration
 }
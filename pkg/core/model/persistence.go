@@ -0,0 +1,405 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/hub"
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	journalFileName  = "journal.log"
+)
+
+// snapshotQueueItem records an entry of the scan queue, in heap order.
+// Snapshots written before AddedAt was recorded leave it zero.
+type snapshotQueueItem struct {
+	Sha      DockerImageSha
+	Priority int
+	AddedAt  time.Time
+}
+
+// snapshot is the on-disk representation of the model.
+type snapshot struct {
+	Time           time.Time
+	Pods           map[string]Pod
+	Images         map[DockerImageSha]*ImageInfo
+	ImageScanQueue []*snapshotQueueItem
+	FilteredPods   map[string]*FilteredPod
+	FilteredImages map[DockerImageSha]*FilteredImage
+	// ImageNamespaces records which namespace's fair share each image counts
+	// against
+	ImageNamespaces map[DockerImageSha]string
+}
+
+// journalEntry records a single model mutation.  Only the fields relevant
+// to `Action` are filled in.
+type journalEntry struct {
+	Action      string
+	Time        time.Time
+	Pod         *Pod             `json:",omitempty"`
+	PodName     string           `json:",omitempty"`
+	Pods        []Pod            `json:",omitempty"`
+	Image       *Image           `json:",omitempty"`
+	Images      []Image          `json:",omitempty"`
+	Sha         DockerImageSha   `json:",omitempty"`
+	Shas        []DockerImageSha `json:",omitempty"`
+	ScanResults *hub.ScanResults `json:",omitempty"`
+	Rescan      *Rescan          `json:",omitempty"`
+	Err         string           `json:",omitempty"`
+}
+
+// persister writes snapshots of the model, and a write-ahead journal of the
+// actions applied since the last snapshot, to a data directory.  Each journal
+// entry is synced to disk before the action it records is applied.
+type persister struct {
+	dataDir string
+	journal *os.File
+	encoder *json.Encoder
+}
+
+func newPersister(dataDir string) (*persister, error) {
+	err := os.MkdirAll(dataDir, 0755)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create data directory %s", dataDir)
+	}
+	return &persister{dataDir: dataDir}, nil
+}
+
+func (p *persister) snapshotPath() string {
+	return filepath.Join(p.dataDir, snapshotFileName)
+}
+
+func (p *persister) journalPath() string {
+	return filepath.Join(p.dataDir, journalFileName)
+}
+
+// openJournal opens the journal for appending.  If `truncate` is true, any
+// existing entries are discarded.
+func (p *persister) openJournal(truncate bool) error {
+	if p.journal != nil {
+		p.journal.Close()
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(p.journalPath(), flags, 0644)
+	if err != nil {
+		p.journal = nil
+		p.encoder = nil
+		return errors.Annotatef(err, "unable to open journal %s", p.journalPath())
+	}
+	p.journal = file
+	p.encoder = json.NewEncoder(file)
+	return nil
+}
+
+func (p *persister) appendToJournal(entry *journalEntry) error {
+	if p.encoder == nil {
+		return fmt.Errorf("unable to append %s to journal: journal not open", entry.Action)
+	}
+	err := p.encoder.Encode(entry)
+	if err != nil {
+		return errors.Annotatef(err, "unable to append %s to journal", entry.Action)
+	}
+	return errors.Annotatef(p.journal.Sync(), "unable to sync journal after appending %s", entry.Action)
+}
+
+// writeSnapshot atomically replaces the snapshot file, and then truncates
+// the journal, since all of its entries are reflected in the new snapshot.
+func (p *persister) writeSnapshot(snap *snapshot) error {
+	tempPath := p.snapshotPath() + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return errors.Annotatef(err, "unable to create %s", tempPath)
+	}
+	err = json.NewEncoder(file).Encode(snap)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return errors.Annotatef(err, "unable to write snapshot to %s", tempPath)
+	}
+	err = os.Rename(tempPath, p.snapshotPath())
+	if err != nil {
+		return errors.Annotatef(err, "unable to rename %s to %s", tempPath, p.snapshotPath())
+	}
+	return p.openJournal(true)
+}
+
+// readSnapshot returns nil if there's no snapshot yet.
+func (p *persister) readSnapshot() (*snapshot, error) {
+	file, err := os.Open(p.snapshotPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Annotatef(err, "unable to open snapshot %s", p.snapshotPath())
+	}
+	defer file.Close()
+	var snap snapshot
+	err = json.NewDecoder(file).Decode(&snap)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to decode snapshot %s", p.snapshotPath())
+	}
+	return &snap, nil
+}
+
+// readJournal returns the journal entries in the order they were written.
+// A partially-written final entry -- for example, from a crash in the
+// middle of a write -- is dropped.
+func (p *persister) readJournal() ([]*journalEntry, error) {
+	file, err := os.Open(p.journalPath())
+	if os.IsNotExist(err) {
+		return []*journalEntry{}, nil
+	} else if err != nil {
+		return nil, errors.Annotatef(err, "unable to open journal %s", p.journalPath())
+	}
+	defer file.Close()
+	entries := []*journalEntry{}
+	decoder := json.NewDecoder(file)
+	for {
+		var entry journalEntry
+		err = decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Warnf("dropping unreadable journal entries after entry %d: %s", len(entries), err.Error())
+			recordPersistence("readJournal", false)
+			break
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// Model methods
+
+// record appends a journal entry, if persistence is enabled.  It must only
+// be called from within an action, before the mutation is applied.
+func (model *Model) record(entry *journalEntry) {
+	if model.persister == nil {
+		return
+	}
+	entry.Time = time.Now()
+	err := model.persister.appendToJournal(entry)
+	recordPersistence("appendToJournal", err == nil)
+	if err != nil {
+		log.Errorf("unable to record %s in journal: %s", entry.Action, err.Error())
+	}
+}
+
+func (model *Model) snapshot() *snapshot {
+	queue := []*snapshotQueueItem{}
+	for _, item := range model.ImageScanQueue.Items() {
+		queue = append(queue, &snapshotQueueItem{
+			Sha:      DockerImageSha(item.Key),
+			Priority: item.Priority,
+			AddedAt:  item.AddedAt,
+		})
+	}
+	return &snapshot{
+		Time:            time.Now(),
+		Pods:            model.Pods,
+		Images:          model.Images,
+		ImageScanQueue:  queue,
+		FilteredPods:    model.FilteredPods,
+		FilteredImages:  model.FilteredImages,
+		ImageNamespaces: model.imageNamespaces,
+	}
+}
+
+func (model *Model) writeSnapshot() error {
+	if model.persister == nil {
+		return nil
+	}
+	start := time.Now()
+	err := model.persister.writeSnapshot(model.snapshot())
+	recordPersistence("writeSnapshot", err == nil)
+	if err != nil {
+		return err
+	}
+	log.Debugf("wrote snapshot of %d pods and %d images in %s", len(model.Pods), len(model.Images), time.Now().Sub(start))
+	return nil
+}
+
+// restoreSnapshot loads pods, images, their namespaces and the scan queue.
+// Items are re-added to the queue in heap order, which reproduces the
+// original heap exactly.  They keep the time they were queued at, so that
+// they don't lose any priority they've gained from aging.
+func (model *Model) restoreSnapshot(snap *snapshot) {
+	if snap.Pods != nil {
+		for _, pod := range snap.Pods {
+			model.putPod(pod)
+		}
+	}
+	if snap.Images != nil {
+		model.Images = snap.Images
+	}
+	if snap.FilteredPods != nil {
+		model.FilteredPods = snap.FilteredPods
+	}
+	if snap.FilteredImages != nil {
+		model.FilteredImages = snap.FilteredImages
+	}
+	if snap.ImageNamespaces != nil {
+		model.imageNamespaces = snap.ImageNamespaces
+	} else {
+		// older snapshots didn't record namespaces
+		for _, pod := range model.Pods {
+			for _, cont := range pod.Containers {
+				model.referenceImageNamespace(cont.Image.Sha, pod.Namespace)
+			}
+		}
+	}
+	for _, item := range snap.ImageScanQueue {
+		imageInfo, ok := model.Images[item.Sha]
+		if !ok || imageInfo.ScanStatus != ScanStatusInQueue {
+			log.Warnf("skipping restore of scan queue item %s: image not found or not in queue", item.Sha)
+			continue
+		}
+		imageInfo.Priority = item.Priority
+		addedAt := item.AddedAt
+		if addedAt.IsZero() {
+			addedAt = imageInfo.TimeOfLastStatusChange
+		}
+		err := model.addToScanQueues(item.Sha, item.Priority, addedAt)
+		if err != nil {
+			log.Errorf("unable to restore scan queue item %s: %s", item.Sha, err.Error())
+		}
+	}
+	// in case the queue and the images disagree, trust the images
+	for sha, imageInfo := range model.Images {
+		if imageInfo.ScanStatus == ScanStatusInQueue && !model.ImageScanQueue.HasKey(string(sha)) {
+			err := model.addImageToScanQueue(sha)
+			if err != nil {
+				log.Errorf("unable to restore scan queue item %s: %s", sha, err.Error())
+			}
+		}
+	}
+}
+
+// replay re-applies a journal entry.
+func (model *Model) replay(entry *journalEntry) error {
+	switch entry.Action {
+	case "addPod", "updatePod":
+		if entry.Pod == nil {
+			return fmt.Errorf("missing pod for journal entry %s", entry.Action)
+		}
+		return model.addPod(*entry.Pod)
+	case "deletePod":
+		return model.deletePod(entry.PodName)
+	case "allPods":
+		return model.allPods(entry.Pods)
+	case "addImage":
+		if entry.Image == nil {
+			return fmt.Errorf("missing image for journal entry %s", entry.Action)
+		}
+		return model.addImage(*entry.Image)
+	case "allImages":
+		return model.allImages(entry.Images)
+	case "finishScanJob":
+		if entry.Image == nil {
+			return fmt.Errorf("missing image for journal entry %s", entry.Action)
+		}
+		var scanErr error
+		if entry.Err != "" {
+			scanErr = fmt.Errorf("%s", entry.Err)
+		}
+		return model.finishRunningScanClient(entry.Image, scanErr)
+	case "scanDidFinish":
+		return model.scanDidFinish(entry.Sha, entry.ScanResults)
+	case "scanDidRefresh":
+		return model.scanDidRefresh(entry.Sha, entry.ScanResults)
+	case "scanWasDeleted":
+		return model.scanWasDeleted(entry.Sha)
+	case "startScanClient":
+		return model.startScanClient(entry.Sha)
+	case "pruneImages":
+		return model.pruneImages(entry.Shas)
+	case "requeueStalledScans":
+		return model.requeueStalledScanClientScans(entry.Shas)
+	case "requeueExpiredLeases":
+		return model.requeueExpiredLeaseScans(entry.Shas)
+	case "requeueHubScans":
+		return model.requeueHubScans(entry.Shas)
+	case "retryFailedScans":
+		return model.retryFailedScans(entry.Shas)
+	case "rescan":
+		if entry.Rescan == nil {
+			return fmt.Errorf("missing rescan for journal entry %s", entry.Action)
+		}
+		return model.rescan(entry.Rescan)
+	default:
+		return fmt.Errorf("unrecognized journal entry %s", entry.Action)
+	}
+}
+
+// restore rebuilds the model from the most recent snapshot, followed by
+// any journal entries written since then.
+//
+// Images which were handed to a scan client before the restart go back into
+// the queue: the hub that was assigned to them no longer knows about the
+// scan, so there's nobody left to report on it.
+func (model *Model) restore() error {
+	snap, err := model.persister.readSnapshot()
+	if err != nil {
+		return err
+	}
+	if snap != nil {
+		model.restoreSnapshot(snap)
+		log.Infof("restored snapshot from %s with %d pods and %d images", snap.Time, len(model.Pods), len(model.Images))
+	}
+	entries, err := model.persister.readJournal()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = model.replay(entry)
+		if err != nil {
+			log.Warnf("problem replaying journal entry %s from %s: %s", entry.Action, entry.Time, err.Error())
+		}
+	}
+	log.Infof("replayed %d journal entries", len(entries))
+	for _, sha := range model.getShas(ScanStatusRunningScanClient) {
+		err = model.setImageScanStatus(sha, ScanStatusInQueue)
+		if err != nil {
+			log.Errorf("unable to requeue image %s after restore: %s", sha, err.Error())
+		}
+	}
+	recordPersistence("restore", true)
+	return nil
+}
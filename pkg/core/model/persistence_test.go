@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/hub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func RunPersistenceTests() {
+	Describe("persistence", func() {
+		var dataDir string
+
+		BeforeEach(func() {
+			var err error
+			dataDir, err = ioutil.TempDir("", "perceptor-model")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dataDir)
+		})
+
+		It("should restore statuses, priorities and queue order from a snapshot", func() {
+			model, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(model.addPod(pod1)).To(BeNil())
+			Expect(model.addPod(pod3)).To(BeNil())
+			Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+			Expect(model.setImageScanStatus(sha2, ScanStatusInQueue)).To(BeNil())
+			Expect(model.setImageScanStatus(sha3, ScanStatusComplete)).To(BeNil())
+			model.Images[sha3].SetScanResults(&hub.ScanResults{PolicyStatus: hub.PolicyStatus{OverallStatus: hub.PolicyStatusTypeNotInViolation}})
+			Expect(model.writeSnapshot()).To(BeNil())
+
+			restored, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(restored.Pods).To(Equal(model.Pods))
+			Expect(restored.Images[sha1].ScanStatus).To(Equal(ScanStatusInQueue))
+			Expect(restored.Images[sha2].Priority).To(Equal(2))
+			Expect(restored.Images[sha3].ScanStatus).To(Equal(ScanStatusComplete))
+			Expect(restored.Images[sha3].ScanResults.OverallStatus()).To(Equal(hub.PolicyStatusTypeNotInViolation))
+			Expect(restored.ImageScanQueue.Dump()).To(Equal(model.ImageScanQueue.Dump()))
+			Expect(restored.imageNamespaces).To(Equal(model.imageNamespaces))
+		})
+
+		It("should restore the namespaces of images being scanned", func() {
+			model, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(model.addPod(pod1)).To(BeNil())
+			Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+			Expect(model.setImageScanStatus(sha1, ScanStatusRunningScanClient)).To(BeNil())
+			Expect(model.setImageScanStatus(sha1, ScanStatusRunningHubScan)).To(BeNil())
+			Expect(model.writeSnapshot()).To(BeNil())
+
+			restored, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(restored.inFlightScans()).To(Equal(map[string]int{"ns1": 1}))
+		})
+
+		It("should replay journal entries written after the snapshot", func() {
+			model, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			model.AddPod(pod1)
+			model.AddImage(image3)
+			model.DeletePod(pod1.QualifiedName())
+			model.ScanDidFinish(sha3, nil)
+			Expect(len(model.GetImages(ScanStatusInQueue))).To(Equal(1))
+
+			restored, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(len(restored.Pods)).To(Equal(0))
+			Expect(len(restored.Images)).To(Equal(3))
+			Expect(restored.Images[sha3].ScanStatus).To(Equal(ScanStatusInQueue))
+			Expect(restored.ImageScanQueue.Values()).To(Equal([]interface{}{sha3}))
+		})
+
+		It("should requeue images that were running in a scan client", func() {
+			model, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(model.addImage(image1)).To(BeNil())
+			Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+			Expect(model.startScanClient(sha1)).To(BeNil())
+			Expect(model.writeSnapshot()).To(BeNil())
+
+			restored, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(restored.Images[sha1].ScanStatus).To(Equal(ScanStatusInQueue))
+			Expect(restored.ImageScanQueue.HasKey(string(sha1))).To(BeTrue())
+		})
+
+		It("should journal lease expiries separately from stalled scan clients", func() {
+			model, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(model.addImage(image1)).To(BeNil())
+			Expect(model.addImage(image2)).To(BeNil())
+			Expect(model.setImageScanStatus(sha1, ScanStatusInQueue)).To(BeNil())
+			Expect(model.setImageScanStatus(sha2, ScanStatusInQueue)).To(BeNil())
+			Expect(model.startScanClientWithLease(&ScanLease{ID: "lease1", Sha: sha1, Expiry: time.Now().Add(-time.Second)})).To(BeNil())
+			Expect(model.startScanClientWithLease(&ScanLease{ID: "lease2", Sha: sha2, Expiry: time.Now().Add(time.Minute)})).To(BeNil())
+			leases := model.ExpireLeases()
+			Expect(leases).To(HaveLen(1))
+			Expect(model.GetImages(ScanStatusInQueue)).To(ConsistOf(sha1))
+
+			entries, err := model.persister.readJournal()
+			Expect(err).To(BeNil())
+			last := entries[len(entries)-1]
+			Expect(last.Action).To(Equal("requeueExpiredLeases"))
+			Expect(last.Shas).To(Equal([]DockerImageSha{sha1}))
+		})
+
+		It("should drop a partially written journal entry", func() {
+			model, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			model.AddImage(image1)
+			Expect(len(model.GetImages(ScanStatusUnknown))).To(Equal(1))
+			file, err := os.OpenFile(filepath.Join(dataDir, journalFileName), os.O_WRONLY|os.O_APPEND, 0644)
+			Expect(err).To(BeNil())
+			_, err = file.WriteString(`{"Action":"addIm`)
+			Expect(err).To(BeNil())
+			Expect(file.Close()).To(BeNil())
+
+			restored, err := NewPersistentModel(dataDir)
+			Expect(err).To(BeNil())
+			Expect(len(restored.Images)).To(Equal(1))
+		})
+	})
+}
@@ -0,0 +1,224 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImageSource is where the model heard about an image from.
+type ImageSource string
+
+// ImageSource values
+const (
+	// ImageSourcePod is for images in a running pod's containers.
+	ImageSourcePod ImageSource = "Pod"
+	// ImageSourceImageStream is for images added on their own -- for example,
+	// from an OpenShift ImageStream -- which may not be running anywhere.
+	ImageSourceImageStream ImageSource = "ImageStream"
+)
+
+const (
+	// priorityRuleDefault explains the priority of images which don't match
+	// any rule: they keep the priority they were sent with.
+	priorityRuleDefault = "default"
+	// priorityRuleRescan explains the priority of images which were rescanned
+	// at a requested priority.
+	priorityRuleRescan = "rescan"
+)
+
+// PriorityRule sets the priority of images which match all of its patterns.
+// A nil pattern, or an empty source, matches anything.  Images which aren't
+// in a pod don't have a namespace, so they never match a namespace pattern.
+type PriorityRule struct {
+	Name       string
+	Priority   int
+	namespace  *Pattern
+	repository *Pattern
+	tag        *Pattern
+	source     ImageSource
+}
+
+// NewPriorityRule .....
+func NewPriorityRule(name string, namespace string, repository string, tag string, source ImageSource, priority int) (*PriorityRule, error) {
+	if name == "" {
+		return nil, fmt.Errorf("priority rule must have a name")
+	}
+	switch source {
+	case "", ImageSourcePod, ImageSourceImageStream:
+	default:
+		return nil, fmt.Errorf("invalid source %s for priority rule %s: expected %s or %s", source, name, ImageSourcePod, ImageSourceImageStream)
+	}
+	namespacePattern, err := newOptionalPattern(namespace)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create priority rule %s", name)
+	}
+	repositoryPattern, err := newOptionalPattern(repository)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create priority rule %s", name)
+	}
+	tagPattern, err := newOptionalPattern(tag)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create priority rule %s", name)
+	}
+	return &PriorityRule{
+		Name:       name,
+		Priority:   priority,
+		namespace:  namespacePattern,
+		repository: repositoryPattern,
+		tag:        tagPattern,
+		source:     source,
+	}, nil
+}
+
+// newOptionalPattern returns nil if `source` is empty.
+func newOptionalPattern(source string) (*Pattern, error) {
+	if source == "" {
+		return nil, nil
+	}
+	return NewPattern(source)
+}
+
+func (rule *PriorityRule) matches(image Image, namespace string, source ImageSource) bool {
+	if rule.source != "" && rule.source != source {
+		return false
+	}
+	if rule.namespace != nil && (source != ImageSourcePod || !rule.namespace.Matches(namespace)) {
+		return false
+	}
+	if rule.repository != nil && !rule.repository.Matches(image.Repository) {
+		return false
+	}
+	if rule.tag != nil && !rule.tag.Matches(image.Tag) {
+		return false
+	}
+	return true
+}
+
+// PriorityPolicy computes images' priorities from an ordered list of rules:
+// the first rule that matches wins.  A nil PriorityPolicy, like one without
+// any rules, leaves images at the priority they were sent with.
+type PriorityPolicy struct {
+	Rules []*PriorityRule
+}
+
+// NewPriorityPolicy .....
+func NewPriorityPolicy(rules []*PriorityRule) *PriorityPolicy {
+	return &PriorityPolicy{Rules: rules}
+}
+
+// evaluate returns the priority of `image`, and the name of the rule which
+// produced it.
+func (policy *PriorityPolicy) evaluate(image Image, namespace string, source ImageSource) (int, string) {
+	if policy != nil {
+		for _, rule := range policy.Rules {
+			if rule.matches(image, namespace, source) {
+				return rule.Priority, rule.Name
+			}
+		}
+	}
+	return image.Priority, priorityRuleDefault
+}
+
+// Model methods
+
+// setPriorityPolicy replaces the model's priority policy, and recomputes the
+// priority of every image.
+func (model *Model) setPriorityPolicy(policy *PriorityPolicy) error {
+	model.priorityPolicy = policy
+	errs := []error{}
+	for sha := range model.Images {
+		err := model.recomputePriority(sha)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return combineErrors("setPriorityPolicy", errs)
+}
+
+// recomputePriority sets an image's priority to the highest that the
+// priority policy gives it in any of the pods which use it -- or, if it
+// isn't in any pod, to what the policy gives it on its own, from the
+// priority it was last sent with.  This is the only place priorities come
+// from, apart from rescans: a rescan's priority sticks until the image has
+// been scanned.
+func (model *Model) recomputePriority(sha DockerImageSha) error {
+	imageInfo, ok := model.Images[sha]
+	if !ok {
+		return nil
+	}
+	if imageInfo.PriorityRule == priorityRuleRescan {
+		switch imageInfo.ScanStatus {
+		case ScanStatusInQueue, ScanStatusRunningScanClient, ScanStatusRunningHubScan:
+			return nil
+		}
+	}
+	priority, rule, found := 0, "", false
+	for podName := range model.imagePods[sha] {
+		pod := model.Pods[podName]
+		for _, cont := range pod.Containers {
+			if cont.Image.Sha != sha {
+				continue
+			}
+			podPriority, podRule := model.priorityPolicy.evaluate(cont.Image, pod.Namespace, ImageSourcePod)
+			// break ties by rule name, so that the rule doesn't depend on
+			// map order
+			if !found || podPriority > priority || (podPriority == priority && podRule < rule) {
+				priority, rule, found = podPriority, podRule, true
+			}
+		}
+	}
+	if !found {
+		image := imageInfo.Image()
+		image.Priority = imageInfo.RequestedPriority
+		priority, rule = model.priorityPolicy.evaluate(image, "", ImageSourceImageStream)
+	}
+	if imageInfo.Priority == priority && imageInfo.PriorityRule == rule {
+		return nil
+	}
+	log.Debugf("recomputed priority for image %s: %d from rule %s", sha, priority, rule)
+	imageInfo.SetPriority(priority, rule)
+	if imageInfo.ScanStatus != ScanStatusInQueue {
+		return nil
+	}
+	err := model.setImagePriority(sha, priority)
+	if err != nil {
+		return errors.Annotatef(err, "unable to set image %s priority in scan queue to %d", sha, priority)
+	}
+	return nil
+}
+
+// recomputePodPriorities recomputes the priorities of a pod's images, for
+// example after the pod has been removed.
+func (model *Model) recomputePodPriorities(pod Pod) error {
+	errs := []error{}
+	for _, cont := range pod.Containers {
+		err := model.recomputePriority(cont.Image.Sha)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return combineErrors("recomputePodPriorities", errs)
+}
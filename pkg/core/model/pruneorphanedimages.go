@@ -21,48 +21,104 @@ under the License.
 
 package model
 
-// import (
-// 	log "github.com/sirupsen/logrus"
-// )
+import (
+	"time"
 
-// // PruneOrphanedImages .....
-// type PruneOrphanedImages struct {
-// 	CompletedImageShas chan []string
-// }
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
 
-// // Apply .....
-// func (p *PruneOrphanedImages) Apply(model *Model) {
-// 	// find images that aren't in a pod
-// 	imagesInPod := map[DockerImageSha]bool{}
-// 	for _, pod := range model.Pods {
-// 		for _, cont := range pod.Containers {
-// 			imagesInPod[cont.Image.Sha] = true
-// 		}
-// 	}
-// 	//
-// 	completed := []string{}
-// 	deleteImmediately := []DockerImageSha{}
-// 	for sha, imageInfo := range model.Images {
-// 		if !imagesInPod[sha] {
-// 			switch imageInfo.ScanStatus {
-// 			case ScanStatusUnknown, ScanStatusInQueue:
-// 				deleteImmediately = append(deleteImmediately, sha)
-// 			case ScanStatusComplete:
-// 				completed = append(completed, string(sha))
-// 			default:
-// 				// let's leave ScanStatusRunningHubScan and ScanStatusRunningScanClient
-// 				// alone, so that they don't get messed up.  They can always be deleted
-// 				// later.
-// 			}
-// 		}
-// 	}
-// 	// 1. immediately delete any orphaned images in the scan queue or status unknown
-// 	for _, sha := range deleteImmediately {
-// 		err := model.deleteImage(sha)
-// 		if err != nil {
-// 			log.Errorf("unable to delete image: %s", err.Error())
-// 		}
-// 	}
-// 	// 2. get a list of completed images for further processing
-// 	p.CompletedImageShas <- completed
-// }
+const (
+	maxImagePrunes = 1000
+)
+
+// ImagePrune records the removal of an orphaned image from the model.
+type ImagePrune struct {
+	Sha                 DockerImageSha
+	ScanStatus          ScanStatus
+	TimeOfLastReference time.Time
+	Time                time.Time
+}
+
+// NewImagePrune .....
+func NewImagePrune(sha DockerImageSha, scanStatus ScanStatus, timeOfLastReference time.Time) *ImagePrune {
+	return &ImagePrune{
+		Sha:                 sha,
+		ScanStatus:          scanStatus,
+		TimeOfLastReference: timeOfLastReference,
+		Time:                time.Now(),
+	}
+}
+
+// dereferencePodImages records that `pod` no longer references its images,
+// which starts their grace period if that was their last pod.
+func (model *Model) dereferencePodImages(pod Pod) {
+	now := time.Now()
+	for _, cont := range pod.Containers {
+		if imageInfo, ok := model.Images[cont.Image.Sha]; ok {
+			imageInfo.TimeOfLastReference = now
+		}
+	}
+}
+
+// findOrphanedImages finds images which:
+//  - aren't in any pod
+//  - haven't been added, or referenced by a pod, for at least `gracePeriod`
+//  - aren't in the middle of being scanned
+// Images in the ScanStatusRunningScanClient and ScanStatusRunningHubScan
+// states are left alone so that in-flight scans don't get messed up.  They
+// can be pruned later, once their scans finish.
+func (model *Model) findOrphanedImages(gracePeriod time.Duration) []DockerImageSha {
+	imagesInPod := model.imagesInPods()
+	now := time.Now()
+	orphans := []DockerImageSha{}
+	for sha, imageInfo := range model.Images {
+		if imagesInPod[sha] {
+			continue
+		}
+		switch imageInfo.ScanStatus {
+		case ScanStatusRunningScanClient, ScanStatusRunningHubScan:
+			continue
+		}
+		if imageInfo.TimeOfLastReference.IsZero() {
+			// for example, restored from an older snapshot: start the clock now
+			imageInfo.TimeOfLastReference = now
+			continue
+		}
+		if now.Sub(imageInfo.TimeOfLastReference) < gracePeriod {
+			continue
+		}
+		orphans = append(orphans, sha)
+	}
+	return orphans
+}
+
+// pruneImages removes images from the model, taking them out of the scan
+// queue first if necessary.  The caller is responsible for making sure that
+// it's safe to delete them -- see the warnings on `deleteImage`.
+func (model *Model) pruneImages(shas []DockerImageSha) error {
+	errs := []error{}
+	for _, sha := range shas {
+		imageInfo, ok := model.Images[sha]
+		if !ok {
+			continue
+		}
+		err := model.leaveState(sha, imageInfo.ScanStatus)
+		if err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to leaveState %s for sha %s", imageInfo.ScanStatus, sha))
+			continue
+		}
+		err = model.deleteImage(sha)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		model.ImagePrunes = append(model.ImagePrunes, NewImagePrune(sha, imageInfo.ScanStatus, imageInfo.TimeOfLastReference))
+		recordPrunedImage(imageInfo.ScanStatus)
+		log.Debugf("pruned orphaned image %s in state %s, last referenced at %s", sha, imageInfo.ScanStatus, imageInfo.TimeOfLastReference)
+	}
+	if len(model.ImagePrunes) > maxImagePrunes {
+		model.ImagePrunes = model.ImagePrunes[len(model.ImagePrunes)-maxImagePrunes/2:]
+	}
+	return combineErrors("pruneImages", errs)
+}
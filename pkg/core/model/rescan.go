@@ -0,0 +1,143 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxRescans = 1000
+)
+
+// Rescan records a request to scan images again.  Exactly one of `Sha`,
+// `PodName` and `Namespace` should be set.  If `Priority` is nil, the images
+// keep their current priorities.  `Shas` are the images that were actually
+// put back into the scan queue.
+type Rescan struct {
+	Sha       DockerImageSha
+	PodName   string
+	Namespace string
+	Priority  *int
+	Requester string
+	Time      time.Time
+	Shas      []DockerImageSha
+}
+
+// findImagesToRescan finds the images matched by a rescan request.  If the
+// image, pod or namespace isn't known, it returns an *api.NotFoundError.
+func (model *Model) findImagesToRescan(rescan *Rescan) ([]DockerImageSha, error) {
+	switch {
+	case rescan.Sha != "":
+		if _, ok := model.Images[rescan.Sha]; !ok {
+			return nil, &api.NotFoundError{Message: fmt.Sprintf("unable to rescan image %s: not found", rescan.Sha)}
+		}
+		return []DockerImageSha{rescan.Sha}, nil
+	case rescan.PodName != "":
+		pod, ok := model.Pods[rescan.PodName]
+		if !ok {
+			return nil, &api.NotFoundError{Message: fmt.Sprintf("unable to rescan pod %s: not found", rescan.PodName)}
+		}
+		return podShas(pod), nil
+	case rescan.Namespace != "":
+		shas := []DockerImageSha{}
+		seen := map[DockerImageSha]bool{}
+		for _, pod := range model.Pods {
+			if pod.Namespace != rescan.Namespace {
+				continue
+			}
+			for _, sha := range podShas(pod) {
+				if !seen[sha] {
+					seen[sha] = true
+					shas = append(shas, sha)
+				}
+			}
+		}
+		if len(shas) == 0 {
+			return nil, &api.NotFoundError{Message: fmt.Sprintf("unable to rescan namespace %s: no images found", rescan.Namespace)}
+		}
+		return shas, nil
+	default:
+		return nil, fmt.Errorf("unable to rescan: no sha, pod or namespace given")
+	}
+}
+
+func podShas(pod Pod) []DockerImageSha {
+	shas := []DockerImageSha{}
+	for _, cont := range pod.Containers {
+		shas = append(shas, cont.Image.Sha)
+	}
+	return shas
+}
+
+// rescan moves the images matched by `rescan` back into the scan queue.
+// Images which are currently being scanned are skipped, and images which
+// are already in the queue just have their priority updated.  Failed images
+// get a fresh set of attempts.
+func (model *Model) rescan(rescan *Rescan) error {
+	shas, err := model.findImagesToRescan(rescan)
+	if err != nil {
+		return err
+	}
+	rescan.Shas = []DockerImageSha{}
+	errs := []error{}
+	for _, sha := range shas {
+		imageInfo, ok := model.Images[sha]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unable to rescan image %s: not found", sha))
+			continue
+		}
+		switch imageInfo.ScanStatus {
+		case ScanStatusRunningScanClient, ScanStatusRunningHubScan:
+			log.Infof("not rescanning image %s: scan already in progress", sha)
+			continue
+		}
+		if rescan.Priority != nil {
+			imageInfo.SetPriority(*rescan.Priority, priorityRuleRescan)
+		}
+		switch imageInfo.ScanStatus {
+		case ScanStatusInQueue:
+			err = model.setImagePriority(sha, imageInfo.Priority)
+		case ScanStatusFailed:
+			imageInfo.FailureCount = 0
+			err = model.setImageScanStatus(sha, ScanStatusInQueue)
+		default: // case ScanStatusUnknown, ScanStatusComplete:
+			err = model.setImageScanStatus(sha, ScanStatusInQueue)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rescan.Shas = append(rescan.Shas, sha)
+	}
+	recordRescan(len(rescan.Shas))
+	log.Infof("%s requested rescan of %d images: %+v", rescan.Requester, len(rescan.Shas), rescan.Shas)
+	model.Rescans = append(model.Rescans, rescan)
+	if len(model.Rescans) > maxRescans {
+		model.Rescans = model.Rescans[len(model.Rescans)-maxRescans/2:]
+	}
+	return combineErrors("rescan", errs)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// RoutingRule sends images whose repository matches to a pool of scanners.
+// Since repositories start with their registry, a pattern such as
+// "registry.example.com/*" routes everything from that registry.
+type RoutingRule struct {
+	Pool       string
+	repository *Pattern
+}
+
+// NewRoutingRule .....
+func NewRoutingRule(repository string, pool string) (*RoutingRule, error) {
+	if pool == "" {
+		return nil, fmt.Errorf("routing rule for repository %s must have a pool", repository)
+	}
+	if repository == "" {
+		return nil, fmt.Errorf("routing rule for pool %s must have a repository", pool)
+	}
+	pattern, err := NewPattern(repository)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create routing rule for pool %s", pool)
+	}
+	return &RoutingRule{Pool: pool, repository: pattern}, nil
+}
+
+// RoutingPolicy decides which pool of scanners an image has to be scanned
+// by: the pool the image was sent with, if any, and otherwise the pool of
+// the first rule that matches.  Images without a pool can be scanned by any
+// scanner, and scanners in a pool can scan images without one.
+type RoutingPolicy struct {
+	Rules []*RoutingRule
+}
+
+// NewRoutingPolicy .....
+func NewRoutingPolicy(rules []*RoutingRule) *RoutingPolicy {
+	return &RoutingPolicy{Rules: rules}
+}
+
+func (policy *RoutingPolicy) evaluate(image Image) string {
+	if image.ScannerPool != "" {
+		return image.ScannerPool
+	}
+	if policy != nil {
+		for _, rule := range policy.Rules {
+			if rule.repository.Matches(image.Repository) {
+				return rule.Pool
+			}
+		}
+	}
+	return ""
+}
+
+// canScan returns whether a scanner in `pool` may scan the image.
+func (imageInfo *ImageInfo) canScan(pool string) bool {
+	return imageInfo.ScannerPool == "" || imageInfo.ScannerPool == pool
+}
+
+// Model methods
+
+// setRoutingPolicy replaces the model's routing policy, and re-routes every
+// image.  Images which are already being scanned aren't affected.
+func (model *Model) setRoutingPolicy(policy *RoutingPolicy) {
+	model.routingPolicy = policy
+	for sha, imageInfo := range model.Images {
+		image := imageInfo.Image()
+		pool := policy.evaluate(image)
+		if pool != imageInfo.ScannerPool {
+			log.Debugf("routed image %s to scanner pool %s", sha, pool)
+			imageInfo.ScannerPool = pool
+		}
+	}
+	model.didMakeImagesAvailable()
+}
+
+// scannerPoolMatcher returns a function for PriorityQueue.PeekMatching which
+// picks out the images a scanner in `pool` may scan.
+func (model *Model) scannerPoolMatcher(pool string) func(value interface{}) bool {
+	return func(value interface{}) bool {
+		sha, ok := value.(DockerImageSha)
+		if !ok {
+			return false
+		}
+		imageInfo, ok := model.Images[sha]
+		return ok && imageInfo.canScan(pool)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Scanner is a scan client which has registered itself, or asked for an
+// image to scan.
+//
+// Like leases, scanners aren't journaled or snapshotted: after a restart,
+// the scanners re-register, and their statistics start over.
+type Scanner struct {
+	ID                 string
+	Version            string
+	Capabilities       []string
+	TimeOfRegistration time.Time
+	LastSeen           time.Time
+	// InFlightJobs is a map of image sha to the time the scan started
+	InFlightJobs     map[DockerImageSha]time.Time
+	JobsCompleted    int
+	JobsFailed       int
+	TotalJobDuration time.Duration
+}
+
+// NewScanner .....
+func NewScanner(id string, version string, capabilities []string) *Scanner {
+	now := time.Now()
+	return &Scanner{
+		ID:                 id,
+		Version:            version,
+		Capabilities:       capabilities,
+		TimeOfRegistration: now,
+		LastSeen:           now,
+		InFlightJobs:       map[DockerImageSha]time.Time{},
+	}
+}
+
+// AverageJobDuration is the mean duration of the scanner's finished jobs,
+// whether they succeeded or failed.
+func (scanner *Scanner) AverageJobDuration() time.Duration {
+	jobs := scanner.JobsCompleted + scanner.JobsFailed
+	if jobs == 0 {
+		return 0
+	}
+	return scanner.TotalJobDuration / time.Duration(jobs)
+}
+
+// registerScanner adds a scanner, or updates the version and capabilities
+// of one that's already known -- for example, after the scanner restarts.
+// Its statistics are kept.
+func (model *Model) registerScanner(id string, version string, capabilities []string) error {
+	if id == "" {
+		return fmt.Errorf("unable to register scanner: missing ID")
+	}
+	scanner, ok := model.Scanners[id]
+	if !ok {
+		model.Scanners[id] = NewScanner(id, version, capabilities)
+		recordScannerEvent("registered")
+		return nil
+	}
+	scanner.Version = version
+	scanner.Capabilities = capabilities
+	scanner.LastSeen = time.Now()
+	recordScannerEvent("reregistered")
+	return nil
+}
+
+// scannerWasSeen updates the scanner's last-seen time, adding it if it
+// hasn't registered.  Returns nil for scanners without an ID.
+func (model *Model) scannerWasSeen(id string) *Scanner {
+	if id == "" {
+		return nil
+	}
+	scanner, ok := model.Scanners[id]
+	if !ok {
+		scanner = NewScanner(id, "", []string{})
+		model.Scanners[id] = scanner
+		recordScannerEvent("unregistered")
+		return scanner
+	}
+	scanner.LastSeen = time.Now()
+	return scanner
+}
+
+// expireScanners forgets about scanners which haven't been heard from in at
+// least `timeout`, and returns their IDs.  Scanners which are still working
+// on jobs are kept until their leases run out.
+func (model *Model) expireScanners(now time.Time, timeout time.Duration) []string {
+	ids := []string{}
+	for id, scanner := range model.Scanners {
+		if len(scanner.InFlightJobs) > 0 || now.Sub(scanner.LastSeen) < timeout {
+			continue
+		}
+		delete(model.Scanners, id)
+		recordScannerEvent("expired")
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (model *Model) scannerDidStartJob(id string, sha DockerImageSha) {
+	scanner := model.scannerWasSeen(id)
+	if scanner == nil {
+		return
+	}
+	scanner.InFlightJobs[sha] = time.Now()
+}
+
+// scannerDidFinishJob records the outcome of a job.  Jobs which the scanner
+// isn't working on -- for example, because its lease was handed on to
+// another scanner -- are ignored.
+func (model *Model) scannerDidFinishJob(id string, sha DockerImageSha, succeeded bool, isHeardFrom bool) {
+	scanner, ok := model.Scanners[id]
+	if !ok {
+		return
+	}
+	if isHeardFrom {
+		scanner.LastSeen = time.Now()
+	}
+	start, ok := scanner.InFlightJobs[sha]
+	if !ok {
+		return
+	}
+	delete(scanner.InFlightJobs, sha)
+	duration := time.Now().Sub(start)
+	scanner.TotalJobDuration += duration
+	if succeeded {
+		scanner.JobsCompleted++
+		recordScannerEvent("jobCompleted")
+	} else {
+		scanner.JobsFailed++
+		recordScannerEvent("jobFailed")
+	}
+	recordScannerJobDuration(duration)
+}
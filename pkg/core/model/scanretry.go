@@ -0,0 +1,122 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ScanRetryPolicy describes how failed scans are retried: the delay doubles
+// after each failure, starting from `BaseDelay` and capped at `MaxDelay`.
+// After `MaxAttempts` failures, an image is parked in the Failed state.
+type ScanRetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+}
+
+// DefaultScanRetryPolicy .....
+var DefaultScanRetryPolicy = &ScanRetryPolicy{
+	BaseDelay:   1 * time.Minute,
+	MaxDelay:    1 * time.Hour,
+	MaxAttempts: 5,
+}
+
+// Delay returns how long to wait before retrying an image which has failed
+// `failureCount` times.
+func (policy *ScanRetryPolicy) Delay(failureCount int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < failureCount; i++ {
+		delay *= 2
+		if delay >= policy.MaxDelay {
+			return policy.MaxDelay
+		}
+	}
+	return delay
+}
+
+// IsParked returns true if an image which has failed `failureCount` times
+// won't be retried again.
+func (policy *ScanRetryPolicy) IsParked(failureCount int) bool {
+	return failureCount >= policy.MaxAttempts
+}
+
+// NextRetry returns when a failed image will be retried, and false if it
+// won't be retried.
+func (policy *ScanRetryPolicy) NextRetry(imageInfo *ImageInfo) (time.Time, bool) {
+	if policy.IsParked(imageInfo.FailureCount) {
+		return time.Time{}, false
+	}
+	return imageInfo.TimeOfLastFailure.Add(policy.Delay(imageInfo.FailureCount)), true
+}
+
+// failScan records a failed scan attempt, and moves the image to the Failed
+// state.  The image will be retried later by retryFailedScans.
+func (model *Model) failScan(sha DockerImageSha, scanErr error) error {
+	imageInfo, ok := model.Images[sha]
+	if !ok {
+		return fmt.Errorf("unable to fail scan for image %s, not found", sha)
+	}
+	imageInfo.recordFailure(scanErr)
+	isParked := model.scanRetryPolicy.IsParked(imageInfo.FailureCount)
+	recordScanFailure(isParked)
+	if isParked {
+		log.Warnf("scan of image %s failed %d times, giving up: %s", sha, imageInfo.FailureCount, imageInfo.LastError)
+	} else {
+		log.Infof("scan of image %s failed %d times, will retry in %s: %s", sha, imageInfo.FailureCount, model.scanRetryPolicy.Delay(imageInfo.FailureCount), imageInfo.LastError)
+	}
+	return model.setImageScanStatus(sha, ScanStatusFailed)
+}
+
+// findFailedScansToRetry finds failed images whose backoff period is over,
+// skipping those which have used up all of their attempts.
+func (model *Model) findFailedScansToRetry(now time.Time) []DockerImageSha {
+	shas := []DockerImageSha{}
+	for sha, imageInfo := range model.Images {
+		if imageInfo.ScanStatus != ScanStatusFailed {
+			continue
+		}
+		nextRetry, ok := model.scanRetryPolicy.NextRetry(imageInfo)
+		if ok && !now.Before(nextRetry) {
+			shas = append(shas, sha)
+		}
+	}
+	return shas
+}
+
+func (model *Model) retryFailedScans(shas []DockerImageSha) error {
+	errs := []error{}
+	for _, sha := range shas {
+		imageInfo, ok := model.Images[sha]
+		if !ok || imageInfo.ScanStatus != ScanStatusFailed {
+			continue
+		}
+		err := model.setImageScanStatus(sha, ScanStatusInQueue)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return combineErrors("retryFailedScans", errs)
+}
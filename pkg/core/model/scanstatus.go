@@ -21,7 +21,10 @@ under the License.
 
 package model
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // ScanStatus describes the state of an image in perceptor
 type ScanStatus int
@@ -33,6 +36,7 @@ const (
 	ScanStatusRunningScanClient ScanStatus = iota
 	ScanStatusRunningHubScan    ScanStatus = iota
 	ScanStatusComplete          ScanStatus = iota
+	ScanStatusFailed            ScanStatus = iota
 )
 
 // String .....
@@ -48,6 +52,8 @@ func (status ScanStatus) String() string {
 		return "ScanStatusRunningHubScan"
 	case ScanStatusComplete:
 		return "ScanStatusComplete"
+	case ScanStatusFailed:
+		return "ScanStatusFailed"
 	}
 	panic(fmt.Errorf("invalid ScanStatus value: %d", status))
 }
@@ -63,6 +69,35 @@ func (status ScanStatus) MarshalText() (text []byte, err error) {
 	return []byte(status.String()), nil
 }
 
+// UnmarshalJSON .....
+func (status *ScanStatus) UnmarshalJSON(data []byte) error {
+	var str string
+	err := json.Unmarshal(data, &str)
+	if err != nil {
+		return err
+	}
+	return status.UnmarshalText([]byte(str))
+}
+
+// UnmarshalText .....
+func (status *ScanStatus) UnmarshalText(text []byte) error {
+	parsed, err := parseScanStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*status = parsed
+	return nil
+}
+
+func parseScanStatus(str string) (ScanStatus, error) {
+	for _, status := range []ScanStatus{ScanStatusUnknown, ScanStatusInQueue, ScanStatusRunningScanClient, ScanStatusRunningHubScan, ScanStatusComplete, ScanStatusFailed} {
+		if status.String() == str {
+			return status, nil
+		}
+	}
+	return ScanStatusUnknown, fmt.Errorf("invalid ScanStatus string: %s", str)
+}
+
 var legalTransitions = map[ScanStatus]map[ScanStatus]bool{
 	ScanStatusUnknown: {
 		ScanStatusInQueue:        true,
@@ -76,13 +111,22 @@ var legalTransitions = map[ScanStatus]map[ScanStatus]bool{
 	ScanStatusRunningScanClient: {
 		ScanStatusInQueue:        true,
 		ScanStatusRunningHubScan: true,
+		ScanStatusFailed:         true,
 	},
 	ScanStatusRunningHubScan: {
 		ScanStatusInQueue:  true,
 		ScanStatusComplete: true,
+		ScanStatusFailed:   true,
+	},
+	// only a rescan moves an image out of complete
+	ScanStatusComplete: {
+		ScanStatusInQueue: true,
+	},
+	// failed images are retried, or may turn out to have been scanned after all
+	ScanStatusFailed: {
+		ScanStatusInQueue:  true,
+		ScanStatusComplete: true,
 	},
-	// we never expect to transition FROM complete
-	ScanStatusComplete: {},
 }
 
 // IsLegalTransition .....
@@ -38,30 +38,42 @@ var cases = []transitionCase{
 	{from: ScanStatusUnknown, to: ScanStatusRunningScanClient, isLegal: false},
 	{from: ScanStatusUnknown, to: ScanStatusRunningHubScan, isLegal: true},
 	{from: ScanStatusUnknown, to: ScanStatusComplete, isLegal: true},
+	{from: ScanStatusUnknown, to: ScanStatusFailed, isLegal: false},
 
 	{from: ScanStatusInQueue, to: ScanStatusUnknown, isLegal: false},
 	{from: ScanStatusInQueue, to: ScanStatusInQueue, isLegal: false},
 	{from: ScanStatusInQueue, to: ScanStatusRunningScanClient, isLegal: true},
 	{from: ScanStatusInQueue, to: ScanStatusRunningHubScan, isLegal: true},
 	{from: ScanStatusInQueue, to: ScanStatusComplete, isLegal: false},
+	{from: ScanStatusInQueue, to: ScanStatusFailed, isLegal: false},
 
 	{from: ScanStatusRunningScanClient, to: ScanStatusUnknown, isLegal: false},
 	{from: ScanStatusRunningScanClient, to: ScanStatusInQueue, isLegal: true},
 	{from: ScanStatusRunningScanClient, to: ScanStatusRunningScanClient, isLegal: false},
 	{from: ScanStatusRunningScanClient, to: ScanStatusRunningHubScan, isLegal: true},
 	{from: ScanStatusRunningScanClient, to: ScanStatusComplete, isLegal: false},
+	{from: ScanStatusRunningScanClient, to: ScanStatusFailed, isLegal: true},
 
 	{from: ScanStatusRunningHubScan, to: ScanStatusUnknown, isLegal: false},
 	{from: ScanStatusRunningHubScan, to: ScanStatusInQueue, isLegal: true},
 	{from: ScanStatusRunningHubScan, to: ScanStatusRunningScanClient, isLegal: false},
 	{from: ScanStatusRunningHubScan, to: ScanStatusRunningHubScan, isLegal: false},
 	{from: ScanStatusRunningHubScan, to: ScanStatusComplete, isLegal: true},
+	{from: ScanStatusRunningHubScan, to: ScanStatusFailed, isLegal: true},
 
 	{from: ScanStatusComplete, to: ScanStatusUnknown, isLegal: false},
-	{from: ScanStatusComplete, to: ScanStatusInQueue, isLegal: false},
+	{from: ScanStatusComplete, to: ScanStatusInQueue, isLegal: true},
 	{from: ScanStatusComplete, to: ScanStatusRunningScanClient, isLegal: false},
 	{from: ScanStatusComplete, to: ScanStatusRunningHubScan, isLegal: false},
 	{from: ScanStatusComplete, to: ScanStatusComplete, isLegal: false},
+	{from: ScanStatusComplete, to: ScanStatusFailed, isLegal: false},
+
+	{from: ScanStatusFailed, to: ScanStatusUnknown, isLegal: false},
+	{from: ScanStatusFailed, to: ScanStatusInQueue, isLegal: true},
+	{from: ScanStatusFailed, to: ScanStatusRunningScanClient, isLegal: false},
+	{from: ScanStatusFailed, to: ScanStatusRunningHubScan, isLegal: false},
+	{from: ScanStatusFailed, to: ScanStatusComplete, isLegal: true},
+	{from: ScanStatusFailed, to: ScanStatusFailed, isLegal: false},
 }
 
 func RunTestLegalScanStatusTransitions() {
@@ -22,10 +22,12 @@ under the License.
 package core
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+	"time"
 
 	api "github.com/blackducksoftware/perceptor/pkg/api"
 	m "github.com/blackducksoftware/perceptor/pkg/core/model"
@@ -35,6 +37,8 @@ import (
 
 const (
 	actionChannelSize = 100
+	// maxNextImageWait caps how long a request for the next image can wait
+	maxNextImageWait = 5 * time.Minute
 )
 
 // Perceptor ties together: a cluster, scan clients, and a hub.
@@ -48,16 +52,72 @@ type Perceptor struct {
 	routineTaskManager *RoutineTaskManager
 	scanScheduler      *ScanScheduler
 	hubManager         HubManagerInterface
-	config             *Config
 	// channels
 	stop           <-chan struct{}
-	getNextImageCh chan chan *api.ImageSpec
-	hosts          map[string]*Host
+	getNextImageCh chan *nextImageRequest
+	setHostsCh     chan map[string]*Host
+	getHostCh      chan *hostRequest
+	setConfigCh    chan *Config
+	getConfigCh    chan chan *Config
+	// hosts and config are only accessed from the dispatchImages goroutine
+	hosts  map[string]*Host
+	config *Config
+}
+
+// hostRequest asks for the configuration of the Black Duck host `hubURL`,
+// which is nil if there's no such host.
+type hostRequest struct {
+	hubURL string
+	done   chan *Host
+}
+
+// nextImageRequest is a scanner's request for an image, along with the
+// channel to send the answer on.  If there's nothing to scan, it waits for
+// an image until `deadline`; the zero deadline means don't wait.
+type nextImageRequest struct {
+	scannerID string
+	pool      string
+	deadline  time.Time
+	done      chan *api.NextImage
+}
+
+func (request *nextImageRequest) finish(nextImage *api.NextImage, stop <-chan struct{}) {
+	select {
+	case <-stop:
+	case request.done <- nextImage:
+	}
 }
 
 // NewPerceptor creates a Perceptor using a real hub client.
 func NewPerceptor(config *Config, timings *Timings, scanScheduler *ScanScheduler, hubManager HubManagerInterface) (*Perceptor, error) {
-	model := m.NewModel()
+	_, err := config.BlackDuck.credentialMode()
+	if err != nil {
+		return nil, err
+	}
+	model, err := newModel(config)
+	if err != nil {
+		return nil, err
+	}
+	model.SetScanRetryPolicy(config.scanRetryPolicy())
+	filter, err := config.filter()
+	if err != nil {
+		return nil, err
+	}
+	// always set the filter, even if it's nil, so that anything restored
+	// from disk is re-checked against the current rules
+	model.SetFilter(filter)
+	priorityPolicy, err := config.priorityPolicy()
+	if err != nil {
+		return nil, err
+	}
+	model.SetPriorityPolicy(priorityPolicy)
+	routingPolicy, err := config.routingPolicy()
+	if err != nil {
+		return nil, err
+	}
+	model.SetRoutingPolicy(routingPolicy)
+	model.SetPriorityAgingInterval(timings.PriorityAgingInterval())
+	model.SetFairSharePolicy(config.fairSharePolicy())
 
 	// 1. routine task manager
 	stop := make(chan struct{})
@@ -69,6 +129,46 @@ func NewPerceptor(config *Config, timings *Timings, scanScheduler *ScanScheduler
 				return
 			case <-routineTaskManager.metricsCh:
 				recordModelMetrics(model.GetMetrics())
+			case <-routineTaskManager.snapshotCh:
+				model.Snapshot()
+			case <-routineTaskManager.stalledScanClientCh:
+				rtmTimings, err := routineTaskManager.GetTimings()
+				if err != nil {
+					log.Errorf("unable to check for stalled scans: %s", err.Error())
+					break
+				}
+				shas := model.RequeueStalledScanClientScans(rtmTimings.StalledScanClientTimeout())
+				failStalledHubScans(hubManager, shas)
+				scanScheduler.DidFreeCapacity()
+			case <-routineTaskManager.hubOutagesCh:
+				rtmTimings, err := routineTaskManager.GetTimings()
+				if err != nil {
+					log.Errorf("unable to check for hub outages: %s", err.Error())
+					break
+				}
+				requeueHubOutageScans(hubManager, model, rtmTimings.HubOutageRequeue())
+				scanScheduler.DidFreeCapacity()
+			case <-routineTaskManager.refreshScansCh:
+				refreshScans(hubManager, model.GetTimesOfLastRefresh())
+			case <-routineTaskManager.expireScannersCh:
+				rtmTimings, err := routineTaskManager.GetTimings()
+				if err != nil {
+					log.Errorf("unable to expire scanners: %s", err.Error())
+					break
+				}
+				model.ExpireScanners(rtmTimings.ScannerTimeout())
+			case <-routineTaskManager.retryFailedScansCh:
+				model.RetryFailedScans()
+			case <-routineTaskManager.expiredLeasesCh:
+				freeExpiredLeases(hubManager, model.ExpireLeases())
+				scanScheduler.DidFreeCapacity()
+			case <-routineTaskManager.pruneOrphanedImagesCh:
+				rtmTimings, err := routineTaskManager.GetTimings()
+				if err != nil {
+					log.Errorf("unable to prune orphaned images: %s", err.Error())
+					break
+				}
+				model.PruneOrphanedImages(rtmTimings.OrphanedImageGracePeriod())
 			case <-routineTaskManager.unknownImagesCh:
 				log.Debugf("handling RTM unknown images")
 				/*
@@ -127,18 +227,28 @@ func NewPerceptor(config *Config, timings *Timings, scanScheduler *ScanScheduler
 	}()
 	go func() {
 		updates := hubManager.Updates()
+		events := hubManager.Events()
 		for {
 			select {
 			case <-stop:
 				return
+			case event := <-events:
+				// the scans in flight on a removed hub are lost
+				if event.Type == HubRemoved {
+					model.RequeueHubScans(event.HubURL)
+				}
+				scanScheduler.DidFreeCapacity()
 			case update := <-updates:
 				switch u := update.Update.(type) {
 				case *hub.DidFindScan:
 					model.ScanDidFinish(m.DockerImageSha(u.Name), u.Results)
 				case *hub.DidFinishScan:
 					model.ScanDidFinish(m.DockerImageSha(u.Name), u.Results)
+					scanScheduler.DidFreeCapacity()
 				case *hub.DidRefreshScan:
-					model.ScanDidFinish(m.DockerImageSha(u.Name), u.Results)
+					model.ScanDidRefresh(m.DockerImageSha(u.Name), u.Results)
+				case *hub.DidDeleteScan:
+					model.ScanWasDeleted(m.DockerImageSha(u.Name))
 				}
 			}
 		}
@@ -156,37 +266,135 @@ func NewPerceptor(config *Config, timings *Timings, scanScheduler *ScanScheduler
 		hubManager:         hubManager,
 		config:             config,
 		stop:               stop,
-		getNextImageCh:     make(chan chan *api.ImageSpec),
+		getNextImageCh:     make(chan *nextImageRequest),
+		setHostsCh:         make(chan map[string]*Host),
+		getHostCh:          make(chan *hostRequest),
+		setConfigCh:        make(chan *Config),
+		getConfigCh:        make(chan chan *Config),
 		hosts:              hosts,
 	}
 
-	go func() {
-		for {
-			select {
-			case <-stop:
-				return
-			case ch := <-perceptor.getNextImageCh:
-				perceptor.getNextImage(ch)
-			}
-		}
-	}()
+	go perceptor.dispatchImages()
 
 	// 3. done
 	return perceptor, nil
 }
 
+// newModel creates a model which is persisted to the configured data
+// directory, or an in-memory model if there's no data directory.
+func newModel(config *Config) (*m.Model, error) {
+	if config.Perceptor == nil || config.Perceptor.DataDirectory == "" {
+		log.Infof("no data directory configured, model will not be persisted")
+		return m.NewModel(), nil
+	}
+	log.Infof("restoring model from data directory %s", config.Perceptor.DataDirectory)
+	return m.NewPersistentModel(config.Perceptor.DataDirectory)
+}
+
+// failStalledHubScans marks the hub-side scans of images whose scan client
+// stalled as failed, so that they no longer count against the hub's
+// concurrent scan limit.
+func failStalledHubScans(hubManager HubManagerInterface, shas []m.DockerImageSha) {
+	if len(shas) == 0 {
+		return
+	}
+	scanErr := fmt.Errorf("scan client stalled")
+	for hubURL, scans := range hubManager.ScanResults() {
+		for _, sha := range shas {
+			scan, ok := scans[string(sha)]
+			if !ok || scan.Stage != hub.ScanStageScanClient {
+				continue
+			}
+			err := hubManager.FinishScanClient(hubURL, string(sha), scanErr)
+			if err != nil {
+				log.Errorf("unable to fail stalled scan %s on hub %s: %s", sha, hubURL, err.Error())
+			}
+		}
+	}
+}
+
+// requeueHubOutageScans moves the in-flight scans of hubs which have been down
+// for longer than `threshold` back into the scan queue, and fails them on the
+// hub so that they no longer count against its concurrent scan limit.
+func requeueHubOutageScans(hubManager HubManagerInterface, model *m.Model, threshold time.Duration) {
+	for hubURL, hub := range hubManager.HubClients() {
+		outage := <-hub.Outage()
+		if outage <= threshold {
+			continue
+		}
+		shas := model.RequeueHubScans(hubURL)
+		if len(shas) == 0 {
+			continue
+		}
+		log.Warnf("hub %s has been down for %s, requeued %d scans", hubURL, outage, len(shas))
+		for _, sha := range shas {
+			err := hubManager.FinishScanClient(hubURL, string(sha), fmt.Errorf("hub down for %s", outage))
+			if err != nil {
+				log.Errorf("unable to fail scan %s on hub %s: %s", sha, hubURL, err.Error())
+			}
+		}
+	}
+}
+
+// refreshScans asks each hub to refresh the results of the completed images
+// which are due for it.  Each hub only refreshes the scans it knows about.
+func refreshScans(hubManager HubManagerInterface, timesOfLastRefresh map[m.DockerImageSha]time.Time) {
+	if len(timesOfLastRefresh) == 0 {
+		return
+	}
+	scans := map[string]time.Time{}
+	for sha, timeOfLastRefresh := range timesOfLastRefresh {
+		scans[string(sha)] = timeOfLastRefresh
+	}
+	for _, hub := range hubManager.HubClients() {
+		hub.RefreshScans(scans)
+	}
+}
+
+// freeExpiredLeases marks the hub-side scans of images whose lease expired
+// as failed, so that they no longer count against the hub's concurrent scan
+// limit.
+func freeExpiredLeases(hubManager HubManagerInterface, leases []*m.ScanLease) {
+	for _, lease := range leases {
+		err := hubManager.FinishScanClient(lease.HubURL, string(lease.Sha), fmt.Errorf("lease %s expired", lease.ID))
+		if err != nil {
+			log.Errorf("unable to free expired lease %s for image %s on hub %s: %s", lease.ID, lease.Sha, lease.HubURL, err.Error())
+		}
+	}
+}
+
+// newRandomID returns a random, hex-encoded ID, for lease IDs and scan
+// tokens.
+func newRandomID() (string, error) {
+	bytes := make([]byte, 16)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
 // getBlackDuckHosts will get the list of Black Duck hosts
 func getBlackDuckHosts(config *Config) (map[string]*Host, error) {
-	connectionStrings, ok := os.LookupEnv(config.BlackDuck.ConnectionsEnvironmentVariableName)
-	if !ok {
-		return nil, fmt.Errorf("cannot find Black Duck hosts: environment variable %s not found", config.BlackDuck.ConnectionsEnvironmentVariableName)
+	connectionStrings, err := config.BlackDuck.connectionStrings()
+	if err != nil {
+		return nil, err
 	}
 
 	blackduckHosts := map[string]*Host{}
-	err := json.Unmarshal([]byte(connectionStrings), &blackduckHosts)
+	err = json.Unmarshal([]byte(connectionStrings), &blackduckHosts)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshall Black Duck hosts due to %+v", err)
 	}
+	// resolving each host's timings here means a change to the global
+	// timings shows up as a change to every host that doesn't override them
+	for _, host := range blackduckHosts {
+		host.Timings = host.Timings.withDefaults(config.BlackDuck.Timings)
+	}
+	err = config.BlackDuck.checkHostCredentials(blackduckHosts)
+	if err != nil {
+		return nil, err
+	}
 
 	return blackduckHosts, nil
 }
@@ -200,14 +408,53 @@ func (pcp *Perceptor) UpdateConfig(config *Config) {
 		log.Errorf("set config, but unable to dump to string: %s", err.Error())
 	}
 
-	pcp.hubManager.SetHubs(pcp.hosts)
+	// the hosts are re-read every time, so that hubs can be added, removed
+	// or changed without a restart
+	hosts, err := getBlackDuckHosts(config)
+	if err != nil {
+		log.Errorf("unable to reload Black Duck hosts, keeping the previous ones: %s", err.Error())
+	} else {
+		pcp.setHosts(hosts)
+		pcp.hubManager.SetHubs(hosts)
+	}
+	pcp.scanScheduler.DidFreeCapacity()
+	if config.BlackDuck != nil {
+		if _, err := config.BlackDuck.credentialMode(); err != nil {
+			log.Errorf("invalid credential mode, falling back to %s: %s", credentialModeScanToken, err.Error())
+		}
+	}
+	pcp.model.SetScanRetryPolicy(config.scanRetryPolicy())
+	filter, err := config.filter()
+	if err != nil {
+		log.Errorf("unable to update filter, keeping the previous one: %s", err.Error())
+	} else {
+		pcp.model.SetFilter(filter)
+	}
+	priorityPolicy, err := config.priorityPolicy()
+	if err != nil {
+		log.Errorf("unable to update priority rules, keeping the previous ones: %s", err.Error())
+	} else {
+		pcp.model.SetPriorityPolicy(priorityPolicy)
+	}
+	routingPolicy, err := config.routingPolicy()
+	if err != nil {
+		log.Errorf("unable to update routing rules, keeping the previous ones: %s", err.Error())
+	} else {
+		pcp.model.SetRoutingPolicy(routingPolicy)
+	}
+	pcp.model.SetFairSharePolicy(config.fairSharePolicy())
+	if config.Perceptor != nil && config.Perceptor.Timings != nil {
+		pcp.model.SetPriorityAgingInterval(config.Perceptor.Timings.PriorityAgingInterval())
+		pcp.routineTaskManager.SetTimings(config.Perceptor.Timings)
+		pcp.hubManager.SetClientTimeout(config.Perceptor.Timings.ClientTimeout())
+	}
 	logLevel, err := config.GetLogLevel()
 	if err != nil {
 		log.Errorf("unable to get log level: %s", err.Error())
 	} else {
 		log.SetLevel(logLevel)
 	}
-	// config.Timings
+	pcp.setConfig(config)
 }
 
 // Section: api.Responder implementation
@@ -219,7 +466,11 @@ func (pcp *Perceptor) GetModel() (*api.Model, error) {
 	for hubURL, hub := range pcp.hubManager.HubClients() {
 		hubModels[hubURL] = <-hub.Model()
 	}
-	configModel, err := pcp.config.model()
+	config := pcp.getConfig()
+	if config == nil {
+		return nil, fmt.Errorf("unable to get config: perceptor is stopped")
+	}
+	configModel, err := config.model()
 	if err != nil {
 		return nil, err
 	}
@@ -228,9 +479,16 @@ func (pcp *Perceptor) GetModel() (*api.Model, error) {
 		BlackDucks: hubModels,
 		Config:     configModel,
 		Scheduler:  pcp.scanScheduler.model(),
+		Scanners:   pcp.model.GetScanners(),
 	}, nil
 }
 
+// GetFailedScans returns the images whose scans have failed
+func (pcp *Perceptor) GetFailedScans() api.FailedScans {
+	recordGetFailedScans()
+	return pcp.model.GetFailedScans()
+}
+
 // AddPod adds the pod to the model
 func (pcp *Perceptor) AddPod(apiPod api.Pod) error {
 	recordAddPod()
@@ -316,29 +574,162 @@ func (pcp *Perceptor) GetScanResults() api.ScanResults {
 	return pcp.model.GetScanResults()
 }
 
-// getNextImage returns the next image from the queue
-func (pcp *Perceptor) getNextImage(ch chan<- *api.ImageSpec) {
-	finish := func(spec *api.ImageSpec) {
+// dispatchImages answers requests for the next image one at a time, so
+// that the same image is never handed out twice.  Requests which are
+// willing to wait are parked, and retried in the order they arrived
+// whenever the model has new images or the scan scheduler has more hub
+// capacity.
+func (pcp *Perceptor) dispatchImages() {
+	waiting := []*nextImageRequest{}
+	for {
+		var timeout <-chan time.Time
+		if len(waiting) > 0 {
+			timeout = time.After(time.Until(earliestDeadline(waiting)))
+		}
 		select {
 		case <-pcp.stop:
-		case ch <- spec:
+			return
+		case request := <-pcp.getNextImageCh:
+			nextImage := pcp.getNextImage(request)
+			if nextImage == nil && !request.deadline.IsZero() {
+				waiting = append(waiting, request)
+				recordWaitingNextImageRequests(len(waiting))
+				break
+			}
+			request.finish(nextImage, pcp.stop)
+		case hosts := <-pcp.setHostsCh:
+			pcp.hosts = hosts
+		case request := <-pcp.getHostCh:
+			var host *Host
+			if found, ok := pcp.hosts[request.hubURL]; ok {
+				copied := *found
+				host = &copied
+			}
+			request.done <- host
+		case config := <-pcp.setConfigCh:
+			pcp.config = config
+		case done := <-pcp.getConfigCh:
+			done <- pcp.config
+		case <-pcp.model.ImagesAvailable():
+			waiting = pcp.retryWaitingRequests(waiting)
+		case <-pcp.scanScheduler.CapacityFreed():
+			waiting = pcp.retryWaitingRequests(waiting)
+		case now := <-timeout:
+			stillWaiting := []*nextImageRequest{}
+			for _, request := range waiting {
+				if now.Before(request.deadline) {
+					stillWaiting = append(stillWaiting, request)
+				} else {
+					request.finish(nil, pcp.stop)
+				}
+			}
+			waiting = stillWaiting
+			recordWaitingNextImageRequests(len(waiting))
 		}
 	}
-	image := pcp.model.GetNextImage()
+}
+
+// setHosts replaces the Black Duck hosts which scanners are told about.
+func (pcp *Perceptor) setHosts(hosts map[string]*Host) {
+	select {
+	case <-pcp.stop:
+	case pcp.setHostsCh <- hosts:
+	}
+}
+
+// setConfig replaces the config, once it's been applied.
+func (pcp *Perceptor) setConfig(config *Config) {
+	select {
+	case <-pcp.stop:
+	case pcp.setConfigCh <- config:
+	}
+}
+
+// getConfig returns the config which was last applied, or nil if the
+// perceptor has stopped.
+func (pcp *Perceptor) getConfig() *Config {
+	done := make(chan *Config, 1)
+	select {
+	case <-pcp.stop:
+		return nil
+	case pcp.getConfigCh <- done:
+	}
+	return <-done
+}
+
+// getHost returns a copy of the configuration of the Black Duck host
+// `hubURL`, or nil if there's no such host.
+func (pcp *Perceptor) getHost(hubURL string) *Host {
+	request := &hostRequest{hubURL: hubURL, done: make(chan *Host, 1)}
+	select {
+	case <-pcp.stop:
+		return nil
+	case pcp.getHostCh <- request:
+	}
+	return <-request.done
+}
+
+// retryWaitingRequests hands out images to waiting requests, oldest first.
+// Since requests from different scanner pools can get different images, a
+// request that comes up empty doesn't stop the ones behind it.  It returns
+// the requests which are still waiting.
+func (pcp *Perceptor) retryWaitingRequests(waiting []*nextImageRequest) []*nextImageRequest {
+	stillWaiting := []*nextImageRequest{}
+	for _, request := range waiting {
+		nextImage := pcp.getNextImage(request)
+		if nextImage == nil {
+			stillWaiting = append(stillWaiting, request)
+			continue
+		}
+		request.finish(nextImage, pcp.stop)
+	}
+	recordWaitingNextImageRequests(len(stillWaiting))
+	return stillWaiting
+}
+
+func earliestDeadline(requests []*nextImageRequest) time.Time {
+	earliest := requests[0].deadline
+	for _, request := range requests[1:] {
+		if request.deadline.Before(earliest) {
+			earliest = request.deadline
+		}
+	}
+	return earliest
+}
+
+// getNextImage takes the next image for the request's scanner pool from the
+// queue and assigns it to a hub.  It returns nil if there's no image, or no
+// hub with capacity.
+func (pcp *Perceptor) getNextImage(request *nextImageRequest) *api.NextImage {
+	image := pcp.model.GetNextImage(request.pool)
 	if image == nil {
 		log.Debug("get next image: no image found")
-		finish(nil)
-		return
+		return nil
 	}
 	hub := pcp.scanScheduler.AssignImage(image)
 	if hub == nil {
 		log.Debug("get next image: no available hub found")
-		finish(nil)
-		return
+		return nil
 	}
 
 	if host, ok := pcp.hosts[hub.Host()]; ok {
-		finish(&api.ImageSpec{
+		lease, err := pcp.newScanLease(image.Sha, hub.Host(), request.scannerID)
+		if err != nil {
+			log.Errorf("unable to lease image %s: %s", image.Sha, err.Error())
+			return nil
+		}
+		log.Debugf("handle didStartScan")
+		err = pcp.model.StartScanClientWithLease(lease)
+		if err != nil {
+			log.Errorf("unable to start scan client for image %s: %s", image.Sha, err.Error())
+			return nil
+		}
+		pcp.hubManager.StartScanClient(hub.Host(), string(image.Sha))
+		password := ""
+		if pcp.credentialMode() == credentialModeLegacy {
+			password = host.Password
+		}
+		spec := &api.ImageSpec{
 			Repository:                  image.Repository,
 			Tag:                         image.Tag,
 			Sha:                         string(image.Sha),
@@ -346,60 +737,226 @@ func (pcp *Perceptor) getNextImage(ch chan<- *api.ImageSpec) {
 			Domain:                      host.Domain,
 			Port:                        host.Port,
 			User:                        host.User,
-			Password:                    host.Password,
+			Password:                    password,
+			ScanToken:                   lease.ScanToken,
 			BlackDuckProjectName:        image.GetBlackDuckProjectName(),
 			BlackDuckProjectVersionName: image.GetBlackDuckProjectVersionName(),
 			BlackDuckScanName:           image.GetBlackDuckScanName(),
-			Priority:                    image.Priority})
-		log.Debugf("handle didStartScan")
-		pcp.model.StartScanClient(image.Sha)
-		pcp.hubManager.StartScanClient(hub.Host(), string(image.Sha))
-		return
+			Priority:                    image.Priority}
+		return &api.NextImage{
+			ImageSpec: spec,
+			Lease:     &api.Lease{ID: lease.ID, Sha: string(lease.Sha), Expiry: lease.Expiry}}
 	}
 	log.Errorf("unable to find the Black Duck host %s from the secret", hub.Host())
+	return nil
+}
+
+// credentialMode reads the credential mode from the current config, falling
+// back to scan tokens -- which don't hand out passwords -- if it's invalid.
+// It must only be called from the dispatchImages goroutine.
+func (pcp *Perceptor) credentialMode() string {
+	if pcp.config == nil || pcp.config.BlackDuck == nil {
+		return credentialModeScanToken
+	}
+	credentialMode, err := pcp.config.BlackDuck.credentialMode()
+	if err != nil {
+		return credentialModeScanToken
+	}
+	return credentialMode
+}
+
+// newScanLease creates a lease on an image, which expires after the
+// configured scan lease duration.  Unless the credential mode is legacy, the
+// lease comes with a scan token for the scanner to exchange for credentials.
+func (pcp *Perceptor) newScanLease(sha m.DockerImageSha, hubURL string, scannerID string) (*m.ScanLease, error) {
+	leaseID, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	scanToken := ""
+	if pcp.credentialMode() != credentialModeLegacy {
+		scanToken, err = newRandomID()
+		if err != nil {
+			return nil, err
+		}
+	}
+	timings, err := pcp.routineTaskManager.GetTimings()
+	if err != nil {
+		return nil, err
+	}
+	return &m.ScanLease{
+		ID:        leaseID,
+		Sha:       sha,
+		HubURL:    hubURL,
+		ScannerID: scannerID,
+		Expiry:    time.Now().Add(timings.ScanLease()),
+		ScanToken: scanToken,
+	}, nil
 }
 
 // GetNextImage returns the next image from the queue
-func (pcp *Perceptor) GetNextImage() api.NextImage {
+func (pcp *Perceptor) GetNextImage(request api.NextImageRequest) api.NextImage {
 	recordGetNextImage()
-	log.Debugf("handling GET next image")
-	ch := make(chan *api.ImageSpec)
-	pcp.getNextImageCh <- ch
-	nextImage := *api.NewNextImage(<-ch)
+	log.Debugf("handling GET next image for scanner %s", request.ScannerID)
+	pcp.model.ScannerWasSeen(request.ScannerID)
+	ch := make(chan *api.NextImage)
+	dispatch := &nextImageRequest{scannerID: request.ScannerID, pool: request.Pool, done: ch}
+	if request.WaitSeconds > 0 {
+		wait := time.Duration(request.WaitSeconds) * time.Second
+		if wait > maxNextImageWait {
+			wait = maxNextImageWait
+		}
+		dispatch.deadline = time.Now().Add(wait)
+	}
+	pcp.getNextImageCh <- dispatch
+	nextImage := api.NextImage{}
+	if next := <-ch; next != nil {
+		nextImage = *next
+	}
 	log.Debugf("handled GET next image -- %+v", nextImage)
 	return nextImage
 }
 
-// PostFinishScan executes the post finished scan job
+// PostFinishScan executes the post finished scan job.  It's rejected unless
+// it comes with a valid lease on the image.
 func (pcp *Perceptor) PostFinishScan(job api.FinishedScanClientJob) error {
+	if job.ImageSpec == nil {
+		return fmt.Errorf("finished scan job with lease %s has no image", job.LeaseID)
+	}
+	log.Debugf("handle didFinishScanClient")
+	var scanErr error
+	if job.Err != "" {
+		scanErr = fmt.Errorf("%s", job.Err)
+	}
+	image := m.NewImage(job.ImageSpec.Repository, job.ImageSpec.Tag, m.DockerImageSha(job.ImageSpec.Sha), job.ImageSpec.Priority, job.ImageSpec.BlackDuckProjectName, job.ImageSpec.BlackDuckProjectVersionName)
+	lease, err := pcp.model.FinishLeasedScanJob(job.LeaseID, image, scanErr)
+	if err != nil {
+		return err
+	}
 	recordPostFinishedScan()
-	go func() {
-		log.Debugf("handle didFinishScanClient")
-		var scanErr error
-		if job.Err != "" {
-			scanErr = fmt.Errorf(job.Err)
-		}
-		err := pcp.hubManager.FinishScanClient(job.ImageSpec.Domain, job.ImageSpec.BlackDuckScanName, scanErr)
-		if err != nil {
-			log.Errorf("unable to record FinishScanClient for hub %s, image %s:", job.ImageSpec.Domain, job.ImageSpec.BlackDuckScanName)
-		}
-		image := m.NewImage(job.ImageSpec.Repository, job.ImageSpec.Tag, m.DockerImageSha(job.ImageSpec.Sha), job.ImageSpec.Priority, job.ImageSpec.BlackDuckProjectName, job.ImageSpec.BlackDuckProjectVersionName)
-		pcp.model.FinishScanJob(image, scanErr)
-	}()
+	err = pcp.hubManager.FinishScanClient(lease.HubURL, job.ImageSpec.BlackDuckScanName, scanErr)
+	if err != nil {
+		log.Errorf("unable to record FinishScanClient for hub %s, image %s: %s", lease.HubURL, job.ImageSpec.BlackDuckScanName, err.Error())
+	}
+	if scanErr != nil {
+		pcp.scanScheduler.DidFreeCapacity()
+	}
 	log.Debugf("handled finished scan job -- %v", job)
 	return nil
 }
 
+// Heartbeat renews a scanner's lease on an image
+func (pcp *Perceptor) Heartbeat(heartbeat api.Heartbeat) (*api.Lease, error) {
+	timings, err := pcp.routineTaskManager.GetTimings()
+	if err != nil {
+		return nil, err
+	}
+	lease, err := pcp.model.RenewLease(heartbeat.LeaseID, timings.ScanLease())
+	if err != nil {
+		return nil, err
+	}
+	recordHeartbeat()
+	log.Debugf("handled heartbeat for lease %s, now expires at %s", lease.ID, lease.Expiry)
+	return &api.Lease{ID: lease.ID, Sha: string(lease.Sha), Expiry: lease.Expiry}, nil
+}
+
+// ExchangeScanToken trades a scan token for the credentials of the Black Duck
+// which the token's image was assigned to.  Each token can only be exchanged
+// once, while its lease is valid.
+//
+// The scanner gets a fresh bearer token issued for the host's ScanAPIToken,
+// which can only do what that token's user can do.  Without a ScanAPIToken,
+// the exchange is refused -- unless the credential mode has since been
+// switched to legacy, which hands out the password anyway.  Perceptor's own
+// bearer token is never handed out.
+func (pcp *Perceptor) ExchangeScanToken(exchange api.ScanTokenExchange) (*api.ScanCredentials, error) {
+	lease, err := pcp.model.ExchangeScanToken(exchange.ScanToken)
+	if err != nil {
+		return nil, err
+	}
+	host := pcp.getHost(lease.HubURL)
+	if host == nil {
+		return nil, fmt.Errorf("unable to find the Black Duck host %s for lease %s", lease.HubURL, lease.ID)
+	}
+	credentials := &api.ScanCredentials{
+		Scheme: host.Scheme,
+		Domain: host.Domain,
+		Port:   host.Port,
+		Expiry: lease.Expiry,
+	}
+	if host.ScanAPIToken == "" {
+		config := pcp.getConfig()
+		if config == nil || config.BlackDuck == nil || config.BlackDuck.CredentialMode != credentialModeLegacy {
+			return nil, fmt.Errorf("unable to exchange scan token for lease %s: Black Duck %s has no scan API token", lease.ID, lease.HubURL)
+		}
+		credentials.User = host.User
+		credentials.Password = host.Password
+		recordExchangeScanToken()
+		log.Debugf("handled legacy scan token exchange for lease %s", lease.ID)
+		return credentials, nil
+	}
+	hub, ok := pcp.hubManager.HubClients()[lease.HubURL]
+	if !ok {
+		return nil, fmt.Errorf("unable to find the Black Duck client %s for lease %s", lease.HubURL, lease.ID)
+	}
+	bearerToken, err := hub.IssueBearerToken(host.ScanAPIToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to issue a bearer token from Black Duck %s for lease %s: %s", lease.HubURL, lease.ID, err.Error())
+	}
+	credentials.BearerToken = bearerToken.Token
+	if bearerToken.Expiry.Before(credentials.Expiry) {
+		credentials.Expiry = bearerToken.Expiry
+	}
+	recordExchangeScanToken()
+	log.Debugf("handled scan token exchange for lease %s", lease.ID)
+	return credentials, nil
+}
+
+// RegisterScanner adds a scanner to the registry
+func (pcp *Perceptor) RegisterScanner(registration api.ScannerRegistration) error {
+	recordRegisterScanner()
+	capabilities := registration.Capabilities
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+	err := pcp.model.RegisterScanner(registration.ID, registration.Version, capabilities)
+	if err != nil {
+		return err
+	}
+	log.Debugf("handled register scanner -- %+v", registration)
+	return nil
+}
+
+// GetScanners returns the scanners in the registry
+func (pcp *Perceptor) GetScanners() api.Scanners {
+	recordGetScanners()
+	return api.Scanners{Scanners: pcp.model.GetScanners()}
+}
+
 // internal use
 
-// PostCommand resets the circuit breaker
-func (pcp *Perceptor) PostCommand(command *api.PostCommand) {
+// PostCommand resets the circuit breaker, or requests a rescan
+func (pcp *Perceptor) PostCommand(command *api.PostCommand) error {
 	if command.ResetCircuitBreaker != nil {
 		for _, hub := range pcp.hubManager.HubClients() {
 			hub.ResetCircuitBreaker()
 		}
 	}
+	if command.Rescan != nil {
+		rescan, err := APIRescanCommandToCoreRescan(*command.Rescan)
+		if err != nil {
+			log.Errorf("unable to handle rescan command %+v: %s", command.Rescan, err.Error())
+			return err
+		}
+		count, err := pcp.model.Rescan(rescan)
+		if err != nil {
+			log.Errorf("unable to handle rescan command %+v: %s", command.Rescan, err.Error())
+			return err
+		}
+		log.Infof("rescan command %+v matched %d images", command.Rescan, count)
+	}
 	log.Debugf("handled post command -- %+v", command)
+	return nil
 }
 
 // errors
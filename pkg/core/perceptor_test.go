@@ -24,7 +24,9 @@ package core
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -52,6 +54,10 @@ var (
 )
 
 func newPerceptor() *Perceptor {
+	return newPerceptorWithCredentialMode("")
+}
+
+func newPerceptorWithCredentialMode(credentialMode string) *Perceptor {
 	stop := make(chan struct{})
 	manager := NewHubManager(createMockHubClient, stop)
 	timings := &Timings{
@@ -60,16 +66,16 @@ func newPerceptor() *Perceptor {
 		StalledScanClientTimeoutHours:  9999,
 		UnknownImagePauseMilliseconds:  500,
 	}
-	config := &Config{BlackDuck: &BlackDuckConfig{ConnectionsEnvironmentVariableName: "blackduck.json", TLSVerification: false}}
+	config := &Config{BlackDuck: &BlackDuckConfig{ConnectionsEnvironmentVariableName: "blackduck.json", TLSVerification: false, CredentialMode: credentialMode}}
 	hosts := map[string]*Host{
-		"hub1": {"https", "hub1", 8443, "mock-username", "mock-password", 2},
-		"hub2": {"https", "hub2", 8443, "mock-username", "mock-password", 2},
-		"hub3": {"https", "hub3", 8443, "mock-username", "mock-password", 2},
+		"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
+		"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
+		"hub3": {Scheme: "https", Domain: "hub3", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
 	}
 	bytes, err := json.Marshal(hosts)
 	Expect(err).To(BeNil())
 	os.Setenv("blackduck.json", string(bytes))
-	pcp, err := NewPerceptor(config, timings, &ScanScheduler{HubManager: manager}, manager)
+	pcp, err := NewPerceptor(config, timings, NewScanScheduler(manager), manager)
 	Expect(err).To(BeNil())
 	return pcp
 }
@@ -82,17 +88,20 @@ func newPerceptorPrepopulatedClients(fetchUnknownScansPause time.Duration) *Perc
 		hub2Host: {image3.Sha},
 		hub3Host: {},
 	}
-	createClient := func(scheme string, hubURL string, port int, user string, password string, concurrentScanLimit int) (*hub.Hub, error) {
-		mockRawClient := hub.NewMockRawClient(false, scans[hubURL])
+	createClient := func(host *Host) (*hub.Hub, error) {
+		mockRawClient := hub.NewMockRawClient(false, scans[host.Domain])
 		hubTimings := &hub.Timings{
 			ScanCompletionPause:    1 * time.Minute,
 			FetchUnknownScansPause: fetchUnknownScansPause,
 			FetchAllScansPause:     999999 * time.Hour,
+			FullSyncPause:          hub.DefaultTimings.FullSyncPause,
 			GetMetricsPause:        hub.DefaultTimings.GetMetricsPause,
 			LoginPause:             hub.DefaultTimings.LoginPause,
 			RefreshScanThreshold:   hub.DefaultTimings.RefreshScanThreshold,
 		}
-		return hub.NewHub("mock-username", "mock-password", hubURL, concurrentScanLimit, mockRawClient, hubTimings), nil
+		options := host.hubOptions()
+		options.Timings = hubTimings
+		return hub.NewHub(host.Domain, mockRawClient, options), nil
 	}
 
 	stop := make(chan struct{})
@@ -110,25 +119,21 @@ func newPerceptorPrepopulatedClients(fetchUnknownScansPause time.Duration) *Perc
 		BlackDuck: &BlackDuckConfig{ConnectionsEnvironmentVariableName: "blackduck.json", TLSVerification: false},
 	}
 	hosts := map[string]*Host{
-		"hub1": {"https", "hub1", 8443, "mock-username", "mock-password", 2},
-		"hub2": {"https", "hub2", 8443, "mock-username", "mock-password", 2},
-		"hub3": {"https", "hub3", 8443, "mock-username", "mock-password", 2},
+		"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
+		"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
+		"hub3": {Scheme: "https", Domain: "hub3", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
 	}
 	bytes, err := json.Marshal(hosts)
 	Expect(err).To(BeNil())
 	os.Setenv("blackduck.json", string(bytes))
 	pcp, err := NewPerceptor(config, timings,
-		&ScanScheduler{
-			HubManager: manager,
-			// ConcurrentScanLimit: concurrentScanLimit,
-			// TotalScanLimit:      totalScanLimit,
-		},
+		NewScanScheduler(manager),
 		manager)
 	Expect(err).To(BeNil())
 	return pcp
 }
 
-func makeImageSpec(image *api.Image, host *Host) *api.ImageSpec {
+func makeImageSpec(image *api.Image, host *Host, scanToken string) *api.ImageSpec {
 	return &api.ImageSpec{
 		BlackDuckProjectName:        image.Repository,
 		BlackDuckProjectVersionName: fmt.Sprintf("%s-%s", image.Tag, image.Sha[:20]),
@@ -137,7 +142,7 @@ func makeImageSpec(image *api.Image, host *Host) *api.ImageSpec {
 		Domain:                      host.Domain,
 		Port:                        host.Port,
 		User:                        host.User,
-		Password:                    host.Password,
+		ScanToken:                   scanToken,
 		Repository:                  image.Repository,
 		Sha:                         image.Sha,
 		Tag:                         image.Tag,
@@ -145,28 +150,36 @@ func makeImageSpec(image *api.Image, host *Host) *api.ImageSpec {
 	}
 }
 
+// imageInfo reads an image through the model's action loop, so that tests
+// don't race with it.
+func imageInfo(pcp *Perceptor, sha string) *api.ModelImageInfo {
+	return pcp.model.GetModel().Images[sha]
+}
+
+func scanQueueSize(pcp *Perceptor) int {
+	return len(pcp.model.GetModel().ImageScanQueue)
+}
+
 func RunTestPerceptor() {
 	Describe("Perceptor", func() {
 		It("should experience unblocked channel communication", func() {
 			pcp := newPerceptor()
-			sha1, err := m.NewDockerImageSha(image1.Sha)
-			Expect(err).To(BeNil())
-			imageSpec := makeImageSpec(&image1, &Host{Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password"})
-			nextImage := api.NextImage{ImageSpec: imageSpec}
 			Expect(pcp.AddImage(image1)).To(BeNil())
-			time.Sleep(500 * time.Millisecond)
-			Expect(len(pcp.model.Images)).To(Equal(1))
-			Expect(pcp.model.Images[sha1].ScanStatus).To(Equal(m.ScanStatusUnknown))
+			Expect(len(pcp.model.GetModel().Images)).To(Equal(1))
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusUnknown.String()))
 
-			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {"https", "hub1", 8443, "mock-username", "mock-password", 2}})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2}})
 			time.Sleep(1 * time.Second)
 
-			Expect(pcp.model.Images[sha1].ScanStatus).To(Equal(m.ScanStatusInQueue))
-			Expect(pcp.GetNextImage()).To(Equal(nextImage))
-			Expect(pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: imageSpec, Err: ""})).To(BeNil())
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusInQueue.String()))
+			nextImage := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(nextImage.ImageSpec.ScanToken).NotTo(BeEmpty())
+			Expect(nextImage.ImageSpec).To(Equal(makeImageSpec(&image1, &Host{Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username"}, nextImage.ImageSpec.ScanToken)))
+			Expect(nextImage.Lease.Sha).To(Equal(image1.Sha))
+			Expect(pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: nextImage.ImageSpec, Err: "", LeaseID: nextImage.Lease.ID})).To(BeNil())
 			time.Sleep(500 * time.Millisecond)
 
-			Expect(pcp.model.Images[sha1].ScanStatus).To(Equal(m.ScanStatusRunningHubScan))
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusRunningHubScan.String()))
 		})
 
 		It("should not assign scans when there are no hubs", func() {
@@ -174,7 +187,7 @@ func RunTestPerceptor() {
 			pcp.UpdateAllImages(api.AllImages{
 				Images: []api.Image{image1},
 			})
-			Expect(pcp.GetNextImage()).To(Equal(api.NextImage{}))
+			Expect(pcp.GetNextImage(api.NextImageRequest{})).To(Equal(api.NextImage{}))
 		})
 
 		It("should not assign scans when the concurrent scan limit is 0", func() {
@@ -183,12 +196,305 @@ func RunTestPerceptor() {
 				Images: []api.Image{image1},
 			})
 			pcp.hubManager.SetHubs(map[string]*Host{
-				"hub1": {"https", "hub1", 8443, "mock-username", "mock-password", 0},
-				"hub2": {"https", "hub2", 8443, "mock-username", "mock-password", 0},
-				"hub3": {"https", "hub3", 8443, "mock-username", "mock-password", 0},
+				"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token"},
+				"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token"},
+				"hub3": {Scheme: "https", Domain: "hub3", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token"},
+			})
+			time.Sleep(1 * time.Second)
+			Expect(pcp.GetNextImage(api.NextImageRequest{})).To(Equal(api.NextImage{}))
+		})
+
+		It("should wait for an image when asked to", func() {
+			pcp := newPerceptor()
+			start := time.Now()
+			Expect(pcp.GetNextImage(api.NextImageRequest{WaitSeconds: 1})).To(Equal(api.NextImage{}))
+			Expect(time.Now().Sub(start) >= time.Second).To(BeTrue())
+
+			nextImages := make(chan api.NextImage)
+			go func() {
+				nextImages <- pcp.GetNextImage(api.NextImageRequest{WaitSeconds: 10})
+			}()
+			time.Sleep(200 * time.Millisecond)
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			var next1 api.NextImage
+			Eventually(nextImages, 5*time.Second).Should(Receive(&next1))
+			Expect(next1.ImageSpec.Sha).To(Equal(image1.Sha))
+		})
+
+		It("should wait for hub capacity when asked to", func() {
+			pcp := newPerceptor()
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1, image2},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Sha).To(Equal(image2.Sha))
+			nextImages := make(chan api.NextImage)
+			go func() {
+				nextImages <- pcp.GetNextImage(api.NextImageRequest{WaitSeconds: 10})
+			}()
+			Consistently(nextImages, 500*time.Millisecond).ShouldNot(Receive())
+
+			Expect(pcp.PostFinishScan(api.FinishedScanClientJob{Err: "planned error", ImageSpec: next1.ImageSpec, LeaseID: next1.Lease.ID})).To(BeNil())
+			var next2 api.NextImage
+			Eventually(nextImages, 5*time.Second).Should(Receive(&next2))
+			Expect(next2.ImageSpec.Sha).To(Equal(image1.Sha))
+		})
+
+		It("should only give images to scanners in the pool they're routed to", func() {
+			pcp := newPerceptor()
+			privateImage := image5
+			privateImage.ScannerPool = "private"
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1, privateImage},
 			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2}})
 			time.Sleep(1 * time.Second)
-			Expect(pcp.GetNextImage()).To(Equal(api.NextImage{}))
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Sha).To(Equal(image1.Sha))
+			Expect(pcp.GetNextImage(api.NextImageRequest{})).To(Equal(api.NextImage{}))
+			Expect(pcp.GetNextImage(api.NextImageRequest{Pool: "high-memory"})).To(Equal(api.NextImage{}))
+			next2 := pcp.GetNextImage(api.NextImageRequest{Pool: "private"})
+			Expect(next2.ImageSpec.Sha).To(Equal(image5.Sha))
+		})
+
+		It("should hand out single-use scan tokens instead of passwords", func() {
+			pcp := newPerceptor()
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2}})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Password).To(BeEmpty())
+			Expect(next1.ImageSpec.ScanToken).NotTo(BeEmpty())
+			_, err := pcp.ExchangeScanToken(api.ScanTokenExchange{ScanToken: "not-a-scan-token"})
+			Expect(err).NotTo(BeNil())
+			credentials, err := pcp.ExchangeScanToken(api.ScanTokenExchange{ScanToken: next1.ImageSpec.ScanToken})
+			Expect(err).To(BeNil())
+			Expect(credentials.Domain).To(Equal("hub1"))
+			Expect(credentials.User).To(BeEmpty())
+			Expect(credentials.Password).To(BeEmpty())
+			Expect(credentials.BearerToken).To(Equal("mock-bearer-token-1-for-mock-scan-api-token"))
+			Expect(credentials.Expiry).To(Equal(next1.Lease.Expiry))
+			_, err = pcp.ExchangeScanToken(api.ScanTokenExchange{ScanToken: next1.ImageSpec.ScanToken})
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should refuse to exchange scan tokens for Black Ducks without a scan API token", func() {
+			pcp := newPerceptor()
+			host := &Host{Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", APIToken: "api-token", ConcurrentScanLimit: 2}
+			pcp.setHosts(map[string]*Host{"hub1": host})
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": host})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			credentials, err := pcp.ExchangeScanToken(api.ScanTokenExchange{ScanToken: next1.ImageSpec.ScanToken})
+			Expect(err).NotTo(BeNil())
+			Expect(credentials).To(BeNil())
+		})
+
+		It("should reject hosts without a scan API token in the scan token credential mode", func() {
+			config := &Config{BlackDuck: &BlackDuckConfig{ConnectionsEnvironmentVariableName: "perceptor-test-hosts"}}
+			bytes, err := json.Marshal(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password"}})
+			Expect(err).To(BeNil())
+			os.Setenv("perceptor-test-hosts", string(bytes))
+			defer os.Unsetenv("perceptor-test-hosts")
+			_, err = getBlackDuckHosts(config)
+			Expect(err).NotTo(BeNil())
+			config.BlackDuck.CredentialMode = "legacy"
+			_, err = getBlackDuckHosts(config)
+			Expect(err).To(BeNil())
+		})
+
+		It("should hand out bearer tokens issued for the scan API token", func() {
+			pcp := newPerceptor()
+			host := &Host{Scheme: "https", Domain: "hub1", Port: 8443, APIToken: "api-token", ScanAPIToken: "scan-api-token", ConcurrentScanLimit: 2}
+			pcp.setHosts(map[string]*Host{"hub1": host})
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": host})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			credentials, err := pcp.ExchangeScanToken(api.ScanTokenExchange{ScanToken: next1.ImageSpec.ScanToken})
+			Expect(err).To(BeNil())
+			Expect(credentials.Password).To(BeEmpty())
+			Expect(credentials.BearerToken).To(Equal("mock-bearer-token-2-for-scan-api-token"))
+		})
+
+		It("should reload Black Duck hosts from the connections file", func() {
+			dir, err := ioutil.TempDir("", "perceptor-hosts")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "blackduck.json")
+			writeHosts := func(hosts map[string]*Host) {
+				bytes, err := json.Marshal(hosts)
+				Expect(err).To(BeNil())
+				Expect(ioutil.WriteFile(path, bytes, 0600)).To(BeNil())
+			}
+			writeHosts(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			config := &Config{BlackDuck: &BlackDuckConfig{ConnectionsFilePath: path}}
+			stop := make(chan struct{})
+			defer close(stop)
+			manager := NewHubManager(createMockHubClient, stop)
+			pcp, err := NewPerceptor(config, &Timings{
+				CheckForStalledScansPauseHours: 9999,
+				ModelMetricsPauseSeconds:       15,
+				StalledScanClientTimeoutHours:  9999,
+				UnknownImagePauseMilliseconds:  500,
+			}, NewScanScheduler(manager), manager)
+			Expect(err).To(BeNil())
+			pcp.UpdateConfig(config)
+			time.Sleep(500 * time.Millisecond)
+			Expect(manager.HubClients()).To(HaveLen(1))
+			hub1 := manager.HubClients()["hub1"]
+			Expect(hub1.ConcurrentScanLimit()).To(Equal(1))
+
+			writeHosts(map[string]*Host{
+				"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "new-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 3},
+				"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1},
+			})
+			pcp.UpdateConfig(config)
+			time.Sleep(500 * time.Millisecond)
+			Expect(manager.HubClients()).To(HaveLen(2))
+			Expect(manager.HubClients()["hub1"]).To(BeIdenticalTo(hub1))
+			Expect(hub1.ConcurrentScanLimit()).To(Equal(3))
+			Expect(pcp.getHost("hub1").Password).To(Equal("new-password"))
+
+			writeHosts(map[string]*Host{"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			pcp.UpdateConfig(config)
+			time.Sleep(500 * time.Millisecond)
+			Expect(manager.HubClients()).To(HaveLen(1))
+			Expect(manager.HubClients()).To(HaveKey("hub2"))
+			Expect(pcp.getHost("hub1")).To(BeNil())
+		})
+
+		It("should resolve hub timings from the host and global config", func() {
+			dir, err := ioutil.TempDir("", "perceptor-hosts")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "blackduck.json")
+			bytes, err := json.Marshal(map[string]*Host{
+				"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1, Timings: &HubTimings{FetchAllScansPauseSeconds: 60}},
+				"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1},
+			})
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(path, bytes, 0600)).To(BeNil())
+			config := &Config{
+				BlackDuck: &BlackDuckConfig{ConnectionsFilePath: path, Timings: &HubTimings{FetchAllScansPauseSeconds: 30, LoginPauseMinutes: 10}},
+				Perceptor: &PerceptorConfig{Timings: &Timings{}},
+			}
+
+			hosts, err := getBlackDuckHosts(config)
+			Expect(err).To(BeNil())
+			hub1Timings := hosts["hub1"].Timings.hubTimings()
+			Expect(hub1Timings.FetchAllScansPause).To(Equal(60 * time.Second))
+			Expect(hub1Timings.LoginPause).To(Equal(10 * time.Minute))
+			Expect(hub1Timings.ScanCompletionPause).To(Equal(hub.DefaultTimings.ScanCompletionPause))
+			hub2Timings := hosts["hub2"].Timings.hubTimings()
+			Expect(hub2Timings.FetchAllScansPause).To(Equal(30 * time.Second))
+			Expect(hub2Timings.LoginPause).To(Equal(10 * time.Minute))
+
+			model, err := config.model()
+			Expect(err).To(BeNil())
+			Expect(model.BlackDuck.Timings.FetchAllScansPause.Seconds).To(Equal(float64(30)))
+			Expect(model.BlackDuck.Timings.FullSyncPause.Minutes).To(Equal(hub.DefaultTimings.FullSyncPause.Minutes()))
+			Expect(model.BlackDuck.Hosts).To(HaveLen(2))
+			for _, host := range model.BlackDuck.Hosts {
+				expected := map[string]float64{"hub1": 60, "hub2": 30}[host.Domain]
+				Expect(host.Timings.FetchAllScansPause.Seconds).To(Equal(expected))
+			}
+		})
+
+		It("should requeue the scans of a removed hub", func() {
+			dir, err := ioutil.TempDir("", "perceptor-hosts")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "blackduck.json")
+			writeHosts := func(hosts map[string]*Host) {
+				bytes, err := json.Marshal(hosts)
+				Expect(err).To(BeNil())
+				Expect(ioutil.WriteFile(path, bytes, 0600)).To(BeNil())
+			}
+			writeHosts(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			config := &Config{BlackDuck: &BlackDuckConfig{ConnectionsFilePath: path}}
+			stop := make(chan struct{})
+			defer close(stop)
+			manager := NewHubManager(createMockHubClient, stop)
+			pcp, err := NewPerceptor(config, &Timings{
+				CheckForStalledScansPauseHours: 9999,
+				ModelMetricsPauseSeconds:       15,
+				StalledScanClientTimeoutHours:  9999,
+				UnknownImagePauseMilliseconds:  500,
+			}, NewScanScheduler(manager), manager)
+			Expect(err).To(BeNil())
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.UpdateConfig(config)
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Domain).To(Equal("hub1"))
+			Expect(imageInfo(pcp, image1.Sha).HubURL).To(Equal("hub1"))
+
+			writeHosts(map[string]*Host{"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			pcp.UpdateConfig(config)
+			time.Sleep(500 * time.Millisecond)
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusInQueue.String()))
+			Expect(imageInfo(pcp, image1.Sha).HubURL).To(BeEmpty())
+			Expect(pcp.model.GetModel().Leases).To(BeEmpty())
+
+			next2 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next2.ImageSpec.Sha).To(Equal(image1.Sha))
+			Expect(next2.ImageSpec.Domain).To(Equal("hub2"))
+		})
+
+		It("should hand out passwords in the legacy credential mode", func() {
+			pcp := newPerceptorWithCredentialMode("legacy")
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2}})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Password).To(Equal("mock-password"))
+			Expect(next1.ImageSpec.ScanToken).To(BeEmpty())
+		})
+
+		It("should pick up credential mode changes from a config reload", func() {
+			pcp := newPerceptor()
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.UpdateConfig(&Config{
+				BlackDuck: &BlackDuckConfig{ConnectionsEnvironmentVariableName: "blackduck.json", CredentialMode: "legacy"},
+				Perceptor: &PerceptorConfig{Timings: &Timings{UnknownImagePauseMilliseconds: 500}},
+			})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Password).To(Equal("mock-password"))
+			Expect(next1.ImageSpec.ScanToken).To(BeEmpty())
+		})
+
+		It("should reject an invalid credential mode", func() {
+			config := &Config{BlackDuck: &BlackDuckConfig{ConnectionsEnvironmentVariableName: "blackduck.json", CredentialMode: "plaintext"}}
+			_, err := NewPerceptor(config, &Timings{}, nil, nil)
+			Expect(err).NotTo(BeNil())
 		})
 
 		It("should assign scans to different hubs, not exceeding the concurrent scan limit of any hub", func() {
@@ -197,52 +503,49 @@ func RunTestPerceptor() {
 				Images: []api.Image{image1, image2, image3, image4, image5},
 			})
 			pcp.hubManager.SetHubs(map[string]*Host{
-				"hub1": {"https", "hub1", 8443, "mock-username", "mock-password", 1},
-				"hub2": {"https", "hub2", 8443, "mock-username", "mock-password", 1},
-				"hub3": {"https", "hub3", 8443, "mock-username", "mock-password", 1},
+				"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1},
+				"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1},
+				"hub3": {Scheme: "https", Domain: "hub3", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1},
 			})
 			time.Sleep(1 * time.Second)
 
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(5))
+			Expect(scanQueueSize(pcp)).To(Equal(5))
 
-			next1 := pcp.GetNextImage()
-			Expect(next1).To(Equal(*api.NewNextImage(makeImageSpec(&image5,
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec).To(Equal(makeImageSpec(&image5,
 				&Host{
-					Scheme:   next1.ImageSpec.Scheme,
-					Domain:   next1.ImageSpec.Domain,
-					Port:     next1.ImageSpec.Port,
-					User:     next1.ImageSpec.User,
-					Password: next1.ImageSpec.Password,
-				}))))
+					Scheme: next1.ImageSpec.Scheme,
+					Domain: next1.ImageSpec.Domain,
+					Port:   next1.ImageSpec.Port,
+					User:   next1.ImageSpec.User,
+				}, next1.ImageSpec.ScanToken)))
 			time.Sleep(500 * time.Millisecond)
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(4))
+			Expect(scanQueueSize(pcp)).To(Equal(4))
 
-			next2 := pcp.GetNextImage()
-			Expect(next2).To(Equal(*api.NewNextImage(makeImageSpec(&image4,
+			next2 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next2.ImageSpec).To(Equal(makeImageSpec(&image4,
 				&Host{
-					Scheme:   next2.ImageSpec.Scheme,
-					Domain:   next2.ImageSpec.Domain,
-					Port:     next2.ImageSpec.Port,
-					User:     next2.ImageSpec.User,
-					Password: next2.ImageSpec.Password,
-				}))))
+					Scheme: next2.ImageSpec.Scheme,
+					Domain: next2.ImageSpec.Domain,
+					Port:   next2.ImageSpec.Port,
+					User:   next2.ImageSpec.User,
+				}, next2.ImageSpec.ScanToken)))
 			time.Sleep(500 * time.Millisecond)
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(3))
+			Expect(scanQueueSize(pcp)).To(Equal(3))
 
-			next3 := pcp.GetNextImage()
-			Expect(next3).To(Equal(*api.NewNextImage(makeImageSpec(&image3,
+			next3 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next3.ImageSpec).To(Equal(makeImageSpec(&image3,
 				&Host{
-					Scheme:   next3.ImageSpec.Scheme,
-					Domain:   next3.ImageSpec.Domain,
-					Port:     next3.ImageSpec.Port,
-					User:     next3.ImageSpec.User,
-					Password: next3.ImageSpec.Password,
-				}))))
+					Scheme: next3.ImageSpec.Scheme,
+					Domain: next3.ImageSpec.Domain,
+					Port:   next3.ImageSpec.Port,
+					User:   next3.ImageSpec.User,
+				}, next3.ImageSpec.ScanToken)))
 			time.Sleep(500 * time.Millisecond)
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(2))
+			Expect(scanQueueSize(pcp)).To(Equal(2))
 
-			Expect(pcp.GetNextImage()).To(Equal(api.NextImage{}))
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(2))
+			Expect(pcp.GetNextImage(api.NextImageRequest{})).To(Equal(api.NextImage{}))
+			Expect(scanQueueSize(pcp)).To(Equal(2))
 		})
 
 		It("should handle scan client failure", func() {
@@ -250,46 +553,203 @@ func RunTestPerceptor() {
 			pcp.UpdateAllImages(api.AllImages{
 				Images: []api.Image{image1, image2},
 			})
-			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {"https", "hub1", 8443, "mock-username", "mock-password", 2}})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2}})
 			time.Sleep(1 * time.Second)
 
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(2))
+			Expect(scanQueueSize(pcp)).To(Equal(2))
 
-			next1 := pcp.GetNextImage()
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
 			Expect(next1.ImageSpec.Sha).To(Equal(image2.Sha))
 			time.Sleep(500 * time.Millisecond)
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(1))
+			Expect(scanQueueSize(pcp)).To(Equal(1))
 
-			pcp.PostFinishScan(api.FinishedScanClientJob{Err: "planned error", ImageSpec: next1.ImageSpec})
+			Expect(pcp.PostFinishScan(api.FinishedScanClientJob{Err: "planned error", ImageSpec: next1.ImageSpec, LeaseID: next1.Lease.ID})).To(BeNil())
 			time.Sleep(500 * time.Millisecond)
 
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(2))
-			Expect(pcp.model.Images[m.DockerImageSha(image1.Sha)].ScanStatus).To(Equal(m.ScanStatusInQueue))
+			Expect(scanQueueSize(pcp)).To(Equal(1))
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusInQueue.String()))
+			Expect(imageInfo(pcp, image2.Sha).ScanStatus).To(Equal(m.ScanStatusFailed.String()))
+			failedScans := pcp.GetFailedScans()
+			Expect(len(failedScans.Images)).To(Equal(1))
+			Expect(failedScans.Images[0].LastError).To(Equal("planned error"))
+
+			Expect(pcp.PostCommand(&api.PostCommand{Rescan: &api.RescanCommand{Sha: image2.Sha, Requester: "tester"}})).To(BeNil())
+			err := pcp.PostCommand(&api.PostCommand{Rescan: &api.RescanCommand{Sha: image5.Sha, Requester: "tester"}})
+			Expect(err).To(BeAssignableToTypeOf(&api.NotFoundError{}))
+			Expect(pcp.PostCommand(&api.PostCommand{Rescan: &api.RescanCommand{Requester: "tester"}})).NotTo(BeNil())
+			time.Sleep(500 * time.Millisecond)
+			Expect(imageInfo(pcp, image2.Sha).ScanStatus).To(Equal(m.ScanStatusInQueue.String()))
+			Expect(scanQueueSize(pcp)).To(Equal(2))
 
 			Expect(<-pcp.hubManager.HubClients()["hub1"].ScansCount()).To(Equal(0))
 		})
 
+		It("should free up hub capacity when a scan client stalls", func() {
+			pcp := newPerceptor()
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Sha).To(Equal(image1.Sha))
+			time.Sleep(500 * time.Millisecond)
+			Expect(len(<-pcp.hubManager.HubClients()["hub1"].InProgressScans())).To(Equal(1))
+
+			shas := pcp.model.RequeueStalledScanClientScans(0)
+			Expect(shas).To(Equal([]m.DockerImageSha{m.DockerImageSha(image1.Sha)}))
+			failStalledHubScans(pcp.hubManager, shas)
+			time.Sleep(500 * time.Millisecond)
+
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusInQueue.String()))
+			Expect(len(<-pcp.hubManager.HubClients()["hub1"].InProgressScans())).To(Equal(0))
+			Expect(pcp.GetNextImage(api.NextImageRequest{}).ImageSpec.Sha).To(Equal(image1.Sha))
+		})
+
+		It("should only accept finished scans with a valid lease", func() {
+			pcp := newPerceptor()
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Sha).To(Equal(image1.Sha))
+			err := pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: next1.ImageSpec, LeaseID: "no-such-lease"})
+			Expect(err).To(BeAssignableToTypeOf(&api.LeaseError{}))
+			err = pcp.PostFinishScan(api.FinishedScanClientJob{LeaseID: next1.Lease.ID})
+			Expect(err).NotTo(BeNil())
+			Expect(err).NotTo(BeAssignableToTypeOf(&api.LeaseError{}))
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusRunningScanClient.String()))
+
+			lease, err := pcp.Heartbeat(api.Heartbeat{LeaseID: next1.Lease.ID})
+			Expect(err).To(BeNil())
+			Expect(lease.Expiry.Before(next1.Lease.Expiry)).To(BeFalse())
+			_, err = pcp.Heartbeat(api.Heartbeat{LeaseID: "no-such-lease"})
+			Expect(err).NotTo(BeNil())
+
+			Expect(pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: next1.ImageSpec, LeaseID: next1.Lease.ID})).To(BeNil())
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusRunningHubScan.String()))
+			Expect(pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: next1.ImageSpec, LeaseID: next1.Lease.ID})).NotTo(BeNil())
+		})
+
+		It("should requeue the image and free up hub capacity when a lease expires", func() {
+			pcp := newPerceptor()
+			pcp.routineTaskManager.SetTimings(&Timings{
+				CheckForStalledScansPauseHours:    9999,
+				StalledScanClientTimeoutHours:     9999,
+				UnknownImagePauseMilliseconds:     500,
+				ScanLeaseSeconds:                  1,
+				CheckForExpiredLeasesPauseSeconds: 9999,
+			})
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{})
+			Expect(next1.ImageSpec.Sha).To(Equal(image1.Sha))
+			time.Sleep(500 * time.Millisecond)
+			Expect(len(<-pcp.hubManager.HubClients()["hub1"].InProgressScans())).To(Equal(1))
+			Expect(pcp.model.ExpireLeases()).To(BeEmpty())
+
+			time.Sleep(1 * time.Second)
+			leases := pcp.model.ExpireLeases()
+			Expect(len(leases)).To(Equal(1))
+			Expect(leases[0].ID).To(Equal(next1.Lease.ID))
+			freeExpiredLeases(pcp.hubManager, leases)
+			time.Sleep(500 * time.Millisecond)
+
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusInQueue.String()))
+			Expect(len(<-pcp.hubManager.HubClients()["hub1"].InProgressScans())).To(Equal(0))
+			Expect(pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: next1.ImageSpec, LeaseID: next1.Lease.ID})).NotTo(BeNil())
+			Expect(pcp.GetNextImage(api.NextImageRequest{}).ImageSpec.Sha).To(Equal(image1.Sha))
+		})
+
+		It("should show the reloaded config in the model", func() {
+			pcp := newPerceptor()
+			pcp.UpdateConfig(&Config{
+				BlackDuck: &BlackDuckConfig{ConnectionsEnvironmentVariableName: "blackduck.json", Timings: &HubTimings{FetchAllScansPauseSeconds: 45}},
+				Perceptor: &PerceptorConfig{Timings: &Timings{UnknownImagePauseMilliseconds: 500}},
+			})
+			model, err := pcp.GetModel()
+			Expect(err).To(BeNil())
+			Expect(model.Config.BlackDuck.Timings.FetchAllScansPause.Seconds).To(Equal(float64(45)))
+		})
+
+		It("should apply timing changes from a config reload", func() {
+			pcp := newPerceptor()
+			oldTimings, err := pcp.routineTaskManager.GetTimings()
+			Expect(err).To(BeNil())
+			newTimings := *oldTimings
+			newTimings.UnknownImagePauseMilliseconds = 200
+			newTimings.ClientTimeoutMilliseconds = 5000
+			Expect(diffTimings(oldTimings, &newTimings)).To(Equal([]timingChange{
+				{name: "UnknownImagePause", from: 500 * time.Millisecond, to: 200 * time.Millisecond},
+				{name: "ClientTimeout", from: oldTimings.ClientTimeout(), to: 5 * time.Second},
+			}))
+			Expect(diffTimings(oldTimings, oldTimings)).To(BeEmpty())
+
+			pcp.UpdateConfig(&Config{
+				BlackDuck: &BlackDuckConfig{ConnectionsEnvironmentVariableName: "blackduck.json"},
+				Perceptor: &PerceptorConfig{Timings: &newTimings},
+			})
+			timings, err := pcp.routineTaskManager.GetTimings()
+			Expect(err).To(BeNil())
+			Expect(timings.UnknownImagePause()).To(Equal(200 * time.Millisecond))
+			Expect(timings.ClientTimeout()).To(Equal(5 * time.Second))
+
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			Expect(pcp.hubManager.HubClients()).To(HaveKey("hub1"))
+		})
+
+		It("should track the jobs of registered scanners", func() {
+			pcp := newPerceptor()
+			Expect(pcp.RegisterScanner(api.ScannerRegistration{ID: "scanner1", Version: "1.0", Capabilities: []string{"docker"}})).To(BeNil())
+			Expect(pcp.RegisterScanner(api.ScannerRegistration{Version: "1.0"})).NotTo(BeNil())
+			pcp.UpdateAllImages(api.AllImages{
+				Images: []api.Image{image1},
+			})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 1}})
+			time.Sleep(1 * time.Second)
+
+			next1 := pcp.GetNextImage(api.NextImageRequest{ScannerID: "scanner1"})
+			Expect(next1.ImageSpec.Sha).To(Equal(image1.Sha))
+			scanner := pcp.GetScanners().Scanners["scanner1"]
+			Expect(scanner.Capabilities).To(Equal([]string{"docker"}))
+			Expect(scanner.InFlightJobs).To(HaveKey(image1.Sha))
+
+			Expect(pcp.PostFinishScan(api.FinishedScanClientJob{ImageSpec: next1.ImageSpec, LeaseID: next1.Lease.ID})).To(BeNil())
+			scanner = pcp.GetScanners().Scanners["scanner1"]
+			Expect(scanner.InFlightJobs).To(BeEmpty())
+			Expect(scanner.JobsCompleted).To(Equal(1))
+			Expect(scanner.JobsFailed).To(Equal(0))
+		})
+
 		It("should recognize scan status of scans already in hubs when first starting up, or after a restart", func() {
 			pcp := newPerceptorPrepopulatedClients(500 * time.Millisecond)
 			pcp.UpdateAllImages(api.AllImages{
 				Images: []api.Image{image1, image2, image3, image4, image5},
 			})
 			pcp.hubManager.SetHubs(map[string]*Host{
-				"hub1": {"https", "hub1", 8443, "mock-username", "mock-password", 2},
-				"hub2": {"https", "hub2", 8443, "mock-username", "mock-password", 2},
-				"hub3": {"https", "hub3", 8443, "mock-username", "mock-password", 2},
+				"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
+				"hub2": {Scheme: "https", Domain: "hub2", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
+				"hub3": {Scheme: "https", Domain: "hub3", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2},
 			})
 			time.Sleep(1 * time.Second)
 
 			// jbs, _ := json.MarshalIndent(pcp.GetModel(), "", "  ")
 			// fmt.Printf("%s\n", string(jbs))
 
-			Expect(pcp.model.ImageScanQueue.Size()).To(Equal(2))
-			Expect(pcp.model.Images[m.DockerImageSha(image1.Sha)].ScanStatus).To(Equal(m.ScanStatusComplete))
-			Expect(pcp.model.Images[m.DockerImageSha(image2.Sha)].ScanStatus).To(Equal(m.ScanStatusComplete))
-			Expect(pcp.model.Images[m.DockerImageSha(image3.Sha)].ScanStatus).To(Equal(m.ScanStatusComplete))
-			Expect(pcp.model.Images[m.DockerImageSha(image4.Sha)].ScanStatus).To(Equal(m.ScanStatusInQueue))
-			Expect(pcp.model.Images[m.DockerImageSha(image5.Sha)].ScanStatus).To(Equal(m.ScanStatusInQueue))
+			Expect(scanQueueSize(pcp)).To(Equal(2))
+			Expect(imageInfo(pcp, image1.Sha).ScanStatus).To(Equal(m.ScanStatusComplete.String()))
+			Expect(imageInfo(pcp, image2.Sha).ScanStatus).To(Equal(m.ScanStatusComplete.String()))
+			Expect(imageInfo(pcp, image3.Sha).ScanStatus).To(Equal(m.ScanStatusComplete.String()))
+			Expect(imageInfo(pcp, image4.Sha).ScanStatus).To(Equal(m.ScanStatusInQueue.String()))
+			Expect(imageInfo(pcp, image5.Sha).ScanStatus).To(Equal(m.ScanStatusInQueue.String()))
 		})
 
 		It("should not return the same image to scan twice", func() {
@@ -297,7 +757,7 @@ func RunTestPerceptor() {
 			pcp.UpdateAllImages(api.AllImages{
 				Images: []api.Image{image1, image2, image3, image4, image5},
 			})
-			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {"https", "hub1", 8443, "mock-username", "mock-password", 2}})
+			pcp.hubManager.SetHubs(map[string]*Host{"hub1": {Scheme: "https", Domain: "hub1", Port: 8443, User: "mock-username", Password: "mock-password", ScanAPIToken: "mock-scan-api-token", ConcurrentScanLimit: 2}})
 			time.Sleep(1 * time.Second)
 
 			var i1 *api.NextImage
@@ -305,12 +765,12 @@ func RunTestPerceptor() {
 			var wg sync.WaitGroup
 			wg.Add(2)
 			go func() {
-				i := pcp.GetNextImage()
+				i := pcp.GetNextImage(api.NextImageRequest{})
 				i1 = &i
 				wg.Done()
 			}()
 			go func() {
-				i := pcp.GetNextImage()
+				i := pcp.GetNextImage(api.NextImageRequest{})
 				i2 = &i
 				wg.Done()
 			}()
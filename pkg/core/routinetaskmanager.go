@@ -36,27 +36,57 @@ type RoutineTaskManager struct {
 	writeTimings chan *Timings
 	timings      *Timings
 	// timers
-	modelMetricsTimer      *util.Timer
-	stalledScanClientTimer *util.Timer
-	unknownImagesTimer     *util.Timer
+	modelMetricsTimer        *util.Timer
+	stalledScanClientTimer   *util.Timer
+	unknownImagesTimer       *util.Timer
+	modelSnapshotTimer       *util.Timer
+	pruneOrphanedImagesTimer *util.Timer
+	retryFailedScansTimer    *util.Timer
+	expiredLeasesTimer       *util.Timer
+	hubOutagesTimer          *util.Timer
+	refreshScansTimer        *util.Timer
+	expireScannersTimer      *util.Timer
 	// channels
-	metricsCh       chan bool
-	unknownImagesCh chan bool
+	metricsCh             chan bool
+	stalledScanClientCh   chan bool
+	unknownImagesCh       chan bool
+	snapshotCh            chan bool
+	pruneOrphanedImagesCh chan bool
+	retryFailedScansCh    chan bool
+	expiredLeasesCh       chan bool
+	hubOutagesCh          chan bool
+	refreshScansCh        chan bool
+	expireScannersCh      chan bool
 }
 
 // NewRoutineTaskManager ...
 func NewRoutineTaskManager(stop <-chan struct{}, timings *Timings) *RoutineTaskManager {
 	rtm := &RoutineTaskManager{
-		stop:            stop,
-		readTimings:     make(chan chan *Timings),
-		writeTimings:    make(chan *Timings),
-		timings:         timings,
-		metricsCh:       make(chan bool),
-		unknownImagesCh: make(chan bool),
+		stop:                  stop,
+		readTimings:           make(chan chan *Timings),
+		writeTimings:          make(chan *Timings),
+		timings:               timings,
+		metricsCh:             make(chan bool),
+		stalledScanClientCh:   make(chan bool),
+		unknownImagesCh:       make(chan bool),
+		snapshotCh:            make(chan bool),
+		pruneOrphanedImagesCh: make(chan bool),
+		retryFailedScansCh:    make(chan bool),
+		expiredLeasesCh:       make(chan bool),
+		hubOutagesCh:          make(chan bool),
+		refreshScansCh:        make(chan bool),
+		expireScannersCh:      make(chan bool),
 	}
 	rtm.stalledScanClientTimer = rtm.startCheckingForStalledScanClientScans()
 	rtm.modelMetricsTimer = rtm.startGeneratingModelMetrics()
 	rtm.unknownImagesTimer = rtm.startCheckingForUnknownImages(timings.UnknownImagePause())
+	rtm.modelSnapshotTimer = rtm.startWritingModelSnapshots()
+	rtm.pruneOrphanedImagesTimer = rtm.startPruningOrphanedImages()
+	rtm.retryFailedScansTimer = rtm.startRetryingFailedScans()
+	rtm.expiredLeasesTimer = rtm.startCheckingForExpiredLeases()
+	rtm.hubOutagesTimer = rtm.startCheckingForHubOutages()
+	rtm.refreshScansTimer = rtm.startRefreshingScans()
+	rtm.expireScannersTimer = rtm.startExpiringScanners()
 	go func() {
 		for {
 			select {
@@ -71,15 +101,88 @@ func NewRoutineTaskManager(stop <-chan struct{}, timings *Timings) *RoutineTaskM
 					}
 				}()
 			case newTimings := <-rtm.writeTimings:
-				rtm.timings = newTimings
-				rtm.stalledScanClientTimer.SetDelay(newTimings.StalledScanClientTimeout())
-				rtm.modelMetricsTimer.SetDelay(newTimings.ModelMetricsPause())
+				rtm.applyTimings(newTimings)
 			}
 		}
 	}()
 	return rtm
 }
 
+// timingChange is a timing whose value changed with a new config.
+type timingChange struct {
+	name string
+	from time.Duration
+	to   time.Duration
+}
+
+// timingAccessors are all of the Timings, by name.
+var timingAccessors = []struct {
+	name  string
+	value func(*Timings) time.Duration
+}{
+	{"CheckForStalledScansPause", (*Timings).CheckForStalledScansPause},
+	{"StalledScanClientTimeout", (*Timings).StalledScanClientTimeout},
+	{"ModelMetricsPause", (*Timings).ModelMetricsPause},
+	{"UnknownImagePause", (*Timings).UnknownImagePause},
+	{"ClientTimeout", (*Timings).ClientTimeout},
+	{"ModelSnapshotPause", (*Timings).ModelSnapshotPause},
+	{"PruneOrphanedImagesPause", (*Timings).PruneOrphanedImagesPause},
+	{"OrphanedImageGracePeriod", (*Timings).OrphanedImageGracePeriod},
+	{"RetryFailedScansPause", (*Timings).RetryFailedScansPause},
+	{"FailedScanRetryBaseDelay", (*Timings).FailedScanRetryBaseDelay},
+	{"FailedScanRetryMaxDelay", (*Timings).FailedScanRetryMaxDelay},
+	{"PriorityAgingInterval", (*Timings).PriorityAgingInterval},
+	{"ScanLease", (*Timings).ScanLease},
+	{"CheckForExpiredLeasesPause", (*Timings).CheckForExpiredLeasesPause},
+	{"CheckForHubOutagesPause", (*Timings).CheckForHubOutagesPause},
+	{"HubOutageRequeue", (*Timings).HubOutageRequeue},
+	{"RefreshScansPause", (*Timings).RefreshScansPause},
+	{"ExpireScannersPause", (*Timings).ExpireScannersPause},
+	{"ScannerTimeout", (*Timings).ScannerTimeout},
+}
+
+// diffTimings returns the timings whose values differ between `old` and
+// `new`.
+func diffTimings(old *Timings, new *Timings) []timingChange {
+	changes := []timingChange{}
+	for _, accessor := range timingAccessors {
+		from, to := accessor.value(old), accessor.value(new)
+		if from != to {
+			changes = append(changes, timingChange{name: accessor.name, from: from, to: to})
+		}
+	}
+	return changes
+}
+
+// applyTimings switches to `newTimings`, and restarts the timers whose pause
+// changed.  Timers whose pause didn't change are left alone, since
+// restarting them on every config reload could keep them from ever firing.
+// The other timings are read whenever they're needed, so they take effect
+// right away.
+func (rtm *RoutineTaskManager) applyTimings(newTimings *Timings) {
+	changes := diffTimings(rtm.timings, newTimings)
+	rtm.timings = newTimings
+	timers := map[string]*util.Timer{
+		"CheckForStalledScansPause":  rtm.stalledScanClientTimer,
+		"ModelMetricsPause":          rtm.modelMetricsTimer,
+		"UnknownImagePause":          rtm.unknownImagesTimer,
+		"ModelSnapshotPause":         rtm.modelSnapshotTimer,
+		"PruneOrphanedImagesPause":   rtm.pruneOrphanedImagesTimer,
+		"RetryFailedScansPause":      rtm.retryFailedScansTimer,
+		"CheckForExpiredLeasesPause": rtm.expiredLeasesTimer,
+		"CheckForHubOutagesPause":    rtm.hubOutagesTimer,
+		"RefreshScansPause":          rtm.refreshScansTimer,
+		"ExpireScannersPause":        rtm.expireScannersTimer,
+	}
+	for _, change := range changes {
+		log.Infof("changing timing %s from %s to %s", change.name, change.from, change.to)
+		recordTimingChange(change.name)
+		if timer, ok := timers[change.name]; ok {
+			timer.SetDelay(change.to)
+		}
+	}
+}
+
 // SetTimings sets the timings in a threadsafe way
 func (rtm *RoutineTaskManager) SetTimings(newTimings *Timings) {
 	rtm.writeTimings <- newTimings
@@ -101,7 +204,11 @@ func (rtm *RoutineTaskManager) startCheckingForStalledScanClientScans() *util.Ti
 	log.Info("starting checking for stalled scans")
 	return util.NewRunningTimer("stalledScanClient", rtm.timings.CheckForStalledScansPause(), rtm.stop, false, func() {
 		log.Debug("checking for stalled scans")
-		// TODO write to a channel or something?
+		select {
+		case <-rtm.stop:
+			return
+		case rtm.stalledScanClientCh <- true:
+		}
 	})
 }
 
@@ -125,3 +232,79 @@ func (rtm *RoutineTaskManager) startCheckingForUnknownImages(pause time.Duration
 		}
 	})
 }
+
+func (rtm *RoutineTaskManager) startWritingModelSnapshots() *util.Timer {
+	return util.NewRunningTimer("modelSnapshot", rtm.timings.ModelSnapshotPause(), rtm.stop, false, func() {
+		select {
+		case <-rtm.stop:
+			return
+		case rtm.snapshotCh <- true:
+		}
+	})
+}
+
+func (rtm *RoutineTaskManager) startPruningOrphanedImages() *util.Timer {
+	return util.NewRunningTimer("pruneOrphanedImages", rtm.timings.PruneOrphanedImagesPause(), rtm.stop, false, func() {
+		log.Debug("checking for orphaned images")
+		select {
+		case <-rtm.stop:
+			return
+		case rtm.pruneOrphanedImagesCh <- true:
+		}
+	})
+}
+
+func (rtm *RoutineTaskManager) startRetryingFailedScans() *util.Timer {
+	return util.NewRunningTimer("retryFailedScans", rtm.timings.RetryFailedScansPause(), rtm.stop, false, func() {
+		log.Debug("checking for failed scans to retry")
+		select {
+		case <-rtm.stop:
+			return
+		case rtm.retryFailedScansCh <- true:
+		}
+	})
+}
+
+func (rtm *RoutineTaskManager) startCheckingForExpiredLeases() *util.Timer {
+	return util.NewRunningTimer("expiredLeases", rtm.timings.CheckForExpiredLeasesPause(), rtm.stop, false, func() {
+		log.Debug("checking for expired scan leases")
+		select {
+		case <-rtm.stop:
+			return
+		case rtm.expiredLeasesCh <- true:
+		}
+	})
+}
+
+func (rtm *RoutineTaskManager) startCheckingForHubOutages() *util.Timer {
+	return util.NewRunningTimer("hubOutages", rtm.timings.CheckForHubOutagesPause(), rtm.stop, false, func() {
+		log.Debug("checking for hub outages")
+		select {
+		case <-rtm.stop:
+			return
+		case rtm.hubOutagesCh <- true:
+		}
+	})
+}
+
+func (rtm *RoutineTaskManager) startRefreshingScans() *util.Timer {
+	return util.NewRunningTimer("refreshScans", rtm.timings.RefreshScansPause(), rtm.stop, false, func() {
+		log.Debug("checking for scans to refresh")
+		select {
+		case <-rtm.stop:
+			return
+		case rtm.refreshScansCh <- true:
+		}
+	})
+}
+
+func (rtm *RoutineTaskManager) startExpiringScanners() *util.Timer {
+	return util.NewRunningTimer("expireScanners", rtm.timings.ExpireScannersPause(), rtm.stop, false, func() {
+		log.Debug("checking for scanners which haven't been seen")
+		select {
+		case <-rtm.stop:
+			return
+		case rtm.expireScannersCh <- true:
+		}
+	})
+}
@@ -30,6 +30,16 @@ import (
 // ScanScheduler stores the scan scheduler
 type ScanScheduler struct {
 	HubManager HubManagerInterface
+	// capacityFreed is signalled when a hub may be able to take on more scans
+	capacityFreed chan struct{}
+}
+
+// NewScanScheduler .....
+func NewScanScheduler(hubManager HubManagerInterface) *ScanScheduler {
+	return &ScanScheduler{
+		HubManager:    hubManager,
+		capacityFreed: make(chan struct{}, 1),
+	}
 }
 
 // AssignImage finds a Hub that is available to scan `image`.
@@ -44,6 +54,21 @@ func (s *ScanScheduler) AssignImage(image *m.Image) *hub.Hub {
 	return nil
 }
 
+// DidFreeCapacity should be called when a hub finishes a scan, or hubs are
+// added.  Signals are coalesced, and never block.
+func (s *ScanScheduler) DidFreeCapacity() {
+	select {
+	case s.capacityFreed <- struct{}{}:
+	default:
+	}
+}
+
+// CapacityFreed is signalled after DidFreeCapacity is called.  It's meant
+// for a single consumer.
+func (s *ScanScheduler) CapacityFreed() <-chan struct{} {
+	return s.capacityFreed
+}
+
 func (s *ScanScheduler) model() *api.ModelScanScheduler {
 	return &api.ModelScanScheduler{}
 }
@@ -24,51 +24,156 @@ package hub
 import (
 	"fmt"
 	"math"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/blackducksoftware/perceptor/pkg/api"
 )
 
+// CircuitBreakerPolicy configures when a CircuitBreaker opens, and how it
+// backs off and recovers.
+type CircuitBreakerPolicy struct {
+	// BaseDelay is how long the circuit breaker stays disabled after it first
+	// opens.  The delay doubles with each failed probe, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter randomizes each delay by up to this fraction of it, in either
+	// direction, so that perceptors don't all probe a recovering hub at once.
+	Jitter float64
+	// FailureThreshold is how many consecutive failures open the circuit
+	// breaker.
+	FailureThreshold int
+	// HalfOpenProbes is how many requests are let through while checking
+	// whether the hub has recovered; all of them have to succeed to reenable
+	// the circuit breaker.
+	HalfOpenProbes int
+}
+
+// DefaultCircuitBreakerPolicy .....
+var DefaultCircuitBreakerPolicy = &CircuitBreakerPolicy{
+	BaseDelay:        2 * time.Second,
+	MaxDelay:         5 * time.Minute,
+	Jitter:           0.2,
+	FailureThreshold: 1,
+	HalfOpenProbes:   1,
+}
+
+// withDefaults returns a copy of the policy, with fields which are unset or
+// out of range taken from DefaultCircuitBreakerPolicy -- except for Jitter,
+// where 0 turns jitter off.  A nil policy gives the default policy.
+func (policy *CircuitBreakerPolicy) withDefaults() *CircuitBreakerPolicy {
+	effective := *DefaultCircuitBreakerPolicy
+	if policy == nil {
+		return &effective
+	}
+	if policy.BaseDelay > 0 {
+		effective.BaseDelay = policy.BaseDelay
+	}
+	if policy.MaxDelay > 0 {
+		effective.MaxDelay = policy.MaxDelay
+	}
+	if effective.MaxDelay < effective.BaseDelay {
+		effective.MaxDelay = effective.BaseDelay
+	}
+	if policy.Jitter >= 0 && policy.Jitter <= 1 {
+		effective.Jitter = policy.Jitter
+	}
+	if policy.FailureThreshold > 0 {
+		effective.FailureThreshold = policy.FailureThreshold
+	}
+	if policy.HalfOpenProbes > 0 {
+		effective.HalfOpenProbes = policy.HalfOpenProbes
+	}
+	return &effective
+}
+
 // CircuitBreaker .....
 type CircuitBreaker struct {
+	mutex               sync.Mutex
+	policy              *CircuitBreakerPolicy
 	state               CircuitBreakerState
 	nextCheckTime       *time.Time
-	maxBackoffDuration  time.Duration
 	consecutiveFailures int
-	host                string
+	// probesIssued and probesSucceeded count requests in the Checking state
+	probesIssued    int
+	probesSucceeded int
+	host            string
+	errorCounts     map[ErrorClass]int
+	// retryAfter is set when the hub answers with a 429, and holds off
+	// requests until then without tripping the circuit breaker
+	retryAfter   *time.Time
+	unauthorized chan struct{}
 }
 
-// NewCircuitBreaker .....
-func NewCircuitBreaker(host string, maxBackoffDuration time.Duration) *CircuitBreaker {
+// NewCircuitBreaker creates a CircuitBreaker; if `policy` is nil, it uses
+// DefaultCircuitBreakerPolicy.
+func NewCircuitBreaker(host string, policy *CircuitBreakerPolicy) *CircuitBreaker {
 	cb := &CircuitBreaker{
+		policy:              policy.withDefaults(),
 		nextCheckTime:       nil,
-		maxBackoffDuration:  maxBackoffDuration,
 		consecutiveFailures: 0,
 		host:                host,
+		errorCounts:         map[ErrorClass]int{},
+		retryAfter:          nil,
+		unauthorized:        make(chan struct{}, 1),
 	}
 	cb.setState(CircuitBreakerStateEnabled)
 	return cb
 }
 
+// SetPolicy changes the policy; it takes effect from the next state change.
+func (cb *CircuitBreaker) SetPolicy(policy *CircuitBreakerPolicy) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.policy = policy.withDefaults()
+}
+
 // Model dumps the current state of the circuit breaker
 func (cb *CircuitBreaker) Model() *api.ModelCircuitBreaker {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	errorCounts := map[string]int{}
+	for class, count := range cb.errorCounts {
+		errorCounts[class.String()] = count
+	}
 	return &api.ModelCircuitBreaker{
 		State:               cb.state.String(),
 		ConsecutiveFailures: cb.consecutiveFailures,
-		MaxBackoffDuration:  *api.NewModelTime(cb.maxBackoffDuration),
+		MaxBackoffDuration:  *api.NewModelTime(cb.policy.MaxDelay),
 		NextCheckTime:       cb.nextCheckTime,
+		RetryAfter:          cb.retryAfter,
+		ErrorCounts:         errorCounts,
+		Policy: &api.ModelCircuitBreakerPolicy{
+			BaseDelay:        *api.NewModelTime(cb.policy.BaseDelay),
+			MaxDelay:         *api.NewModelTime(cb.policy.MaxDelay),
+			Jitter:           cb.policy.Jitter,
+			FailureThreshold: cb.policy.FailureThreshold,
+			HalfOpenProbes:   cb.policy.HalfOpenProbes,
+		},
 	}
 }
 
+// Unauthorized is signalled when a request fails with a 401, meaning that
+// the client needs to log in again.  Signals are coalesced, and never block.
+func (cb *CircuitBreaker) Unauthorized() <-chan struct{} {
+	return cb.unauthorized
+}
+
 // Reset reenables the circuit breaker regardless of its current state,
-// and clears out ConsecutiveFailures and NextCheckTime
+// and clears out ConsecutiveFailures, NextCheckTime and RetryAfter
 func (cb *CircuitBreaker) Reset() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
 	cb.setState(CircuitBreakerStateEnabled)
 	cb.consecutiveFailures = 0
 	cb.nextCheckTime = nil
+	cb.retryAfter = nil
 }
 
 func (cb *CircuitBreaker) setState(state CircuitBreakerState) {
+	cb.probesIssued = 0
+	cb.probesSucceeded = 0
 	recordCircuitBreakerState(cb.host, state)
 	recordCircuitBreakerTransition(cb.host, cb.state, state)
 	cb.state = state
@@ -82,22 +187,77 @@ func (cb *CircuitBreaker) IsEnabled() bool {
 // isAbleToIssueRequest does 3 things:
 // 1. changes the state to `Checking` if necessary
 // 2. increments a metric of the circuit breaker state
-// 3. returns whether the circuit breaker is enabled
-func (cb *CircuitBreaker) isAbleToIssueRequest() bool {
-	if cb.state == CircuitBreakerStateDisabled && time.Now().After(*cb.nextCheckTime) {
+// 3. returns an error if the circuit breaker is disabled, is checking and
+//    has already let through all of its probes, or the hub asked for
+//    requests to be held off
+func (cb *CircuitBreaker) isAbleToIssueRequest(description string) error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	now := time.Now()
+	if cb.state == CircuitBreakerStateDisabled && now.After(*cb.nextCheckTime) {
 		cb.setState(CircuitBreakerStateChecking)
 	}
 	isEnabled := cb.IsEnabled()
 	recordCircuitBreakerIsEnabled(cb.host, isEnabled)
-	return isEnabled
+	if !isEnabled {
+		return fmt.Errorf("unable to issue request %s, circuit breaker is disabled", description)
+	}
+	// this is checked before a probe is let through, since a request which
+	// is held off never finishes, and so would never give back its probe
+	if cb.retryAfter != nil {
+		if now.Before(*cb.retryAfter) {
+			return fmt.Errorf("unable to issue request %s, hub asked to retry after %s", description, cb.retryAfter.String())
+		}
+		cb.retryAfter = nil
+	}
+	if cb.state == CircuitBreakerStateChecking {
+		if cb.probesIssued >= cb.policy.HalfOpenProbes {
+			return fmt.Errorf("unable to issue request %s, circuit breaker is waiting for %d probes", description, cb.policy.HalfOpenProbes)
+		}
+		cb.probesIssued++
+	}
+	return nil
+}
+
+// didFinishRequest updates the circuit breaker with the outcome of a request.
+// Only errors which suggest the hub is down or overloaded count as failures;
+// a 429 holds off further requests for as long as the hub asked.
+func (cb *CircuitBreaker) didFinishRequest(description string, err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if err == nil {
+		cb.success()
+		return
+	}
+	class, retryAfter := classifyError(err)
+	cb.errorCounts[class]++
+	recordHubErrorClass(cb.host, description, class)
+	if class.tripsCircuitBreaker() {
+		cb.failure()
+		return
+	}
+	// the hub answered, so it's up
+	cb.success()
+	if class == ErrorClassTooManyRequests {
+		retryAt := time.Now().Add(retryAfter)
+		cb.retryAfter = &retryAt
+	}
+	if isUnauthorized(err) {
+		select {
+		case cb.unauthorized <- struct{}{}:
+		default:
+		}
+	}
 }
 
 func (cb *CircuitBreaker) failure() {
 	switch cb.state {
 	case CircuitBreakerStateEnabled:
-		cb.setState(CircuitBreakerStateDisabled)
-		cb.consecutiveFailures = 1
-		cb.setNextCheckTime()
+		cb.consecutiveFailures++
+		if cb.consecutiveFailures >= cb.policy.FailureThreshold {
+			cb.setState(CircuitBreakerStateDisabled)
+			cb.setNextCheckTime()
+		}
 	case CircuitBreakerStateDisabled:
 		break
 	case CircuitBreakerStateChecking:
@@ -110,39 +270,56 @@ func (cb *CircuitBreaker) failure() {
 func (cb *CircuitBreaker) success() {
 	switch cb.state {
 	case CircuitBreakerStateEnabled:
-		break
+		cb.consecutiveFailures = 0
 	case CircuitBreakerStateDisabled:
 		break
 	case CircuitBreakerStateChecking:
-		cb.setState(CircuitBreakerStateEnabled)
-		cb.consecutiveFailures = 0
-		cb.nextCheckTime = nil
+		cb.probesSucceeded++
+		if cb.probesSucceeded >= cb.policy.HalfOpenProbes {
+			cb.setState(CircuitBreakerStateEnabled)
+			cb.consecutiveFailures = 0
+			cb.nextCheckTime = nil
+		}
 	}
 }
 
 func (cb *CircuitBreaker) setNextCheckTime() {
-	nextExponentialSeconds := math.Pow(2, float64(cb.consecutiveFailures))
-	nextCheckDuration := MinDuration(cb.maxBackoffDuration, time.Duration(nextExponentialSeconds)*time.Second)
-	nextCheckTime := time.Now().Add(nextCheckDuration)
+	nextCheckTime := time.Now().Add(cb.backoff())
 	cb.nextCheckTime = &nextCheckTime
 }
 
+// backoff is BaseDelay, doubled for each failed probe since the circuit
+// breaker opened, capped at MaxDelay, and then randomized by Jitter.
+func (cb *CircuitBreaker) backoff() time.Duration {
+	policy := cb.policy
+	failedProbes := cb.consecutiveFailures - policy.FailureThreshold
+	if failedProbes < 0 {
+		failedProbes = 0
+	}
+	delay := float64(policy.BaseDelay) * math.Pow(2, float64(failedProbes))
+	delay = math.Min(delay, float64(policy.MaxDelay))
+	delay += delay * policy.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
 // IssueRequest synchronously:
 //  - checks whether it's enabled
+//  - waits for 'limiter' to let the request through; a nil limiter doesn't
+//    wait
 //  - runs 'request'
-//  - looks at the result of 'request', disabling itself on failure
-func (cb *CircuitBreaker) IssueRequest(description string, request func() error) error {
-	if !cb.isAbleToIssueRequest() {
-		return fmt.Errorf("unable to issue request %s, circuit breaker is disabled", description)
+//  - looks at the result of 'request', disabling itself on failures which
+//    suggest the hub is down
+func (cb *CircuitBreaker) IssueRequest(description string, limiter *RateLimiter, request func() error) error {
+	err := cb.isAbleToIssueRequest(description)
+	if err != nil {
+		return err
 	}
+	queueWait := limiter.wait()
+	defer limiter.done()
 	start := time.Now()
-	err := request()
-	recordHubResponseTime(cb.host, description, time.Now().Sub(start))
+	err = request()
+	recordHubResponseTime(cb.host, description, queueWait, time.Now().Sub(start))
 	recordHubResponse(cb.host, description, err == nil)
-	if err == nil {
-		cb.success()
-	} else {
-		cb.failure()
-	}
+	cb.didFinishRequest(description, err)
 	return err
 }
@@ -30,13 +30,13 @@ import (
 // TestCircuitBreaker .....
 func TestCircuitBreaker(t *testing.T) {
 	hubClient := &MockRawClient{ShouldFail: false}
-	cb := NewCircuitBreaker("testhost", 10*time.Minute)
+	cb := NewCircuitBreaker("testhost", &CircuitBreakerPolicy{MaxDelay: 10 * time.Minute, Jitter: 0})
 	if cb.state != CircuitBreakerStateEnabled {
 		t.Errorf("expected CircuitBreakerStateEnabled, found %s", cb.state)
 	}
 
 	// API working -> cb remains enabled
-	cb.IssueRequest("abc", func() error {
+	cb.IssueRequest("abc", nil, func() error {
 		return nil
 	})
 	if cb.state != CircuitBreakerStateEnabled {
@@ -44,7 +44,7 @@ func TestCircuitBreaker(t *testing.T) {
 	}
 
 	// API fails -> cb gets disabled
-	cb.IssueRequest("abc", func() error {
+	cb.IssueRequest("abc", nil, func() error {
 		return fmt.Errorf("planned failure")
 	})
 	if cb.state != CircuitBreakerStateDisabled {
@@ -55,7 +55,7 @@ func TestCircuitBreaker(t *testing.T) {
 	}
 
 	// cb disabled -> API calls fail
-	err := cb.IssueRequest("abc", func() error {
+	err := cb.IssueRequest("abc", nil, func() error {
 		panic("this should never be called!")
 	})
 	if err == nil {
@@ -70,7 +70,7 @@ func TestCircuitBreaker(t *testing.T) {
 
 	// disabled -> checks -> disabled
 	time.Sleep(2 * time.Second)
-	err = cb.IssueRequest("abc", func() error {
+	err = cb.IssueRequest("abc", nil, func() error {
 		return fmt.Errorf("planned failure")
 	})
 	if err == nil {
@@ -86,7 +86,7 @@ func TestCircuitBreaker(t *testing.T) {
 	// disabled -> checks -> enabled
 	time.Sleep(4 * time.Second)
 	hubClient.ShouldFail = false
-	err = cb.IssueRequest("abc", func() error {
+	err = cb.IssueRequest("abc", nil, func() error {
 		return nil
 	})
 	if err != nil {
@@ -97,9 +97,199 @@ func TestCircuitBreaker(t *testing.T) {
 	}
 }
 
+// TestCircuitBreakerErrorClasses .....
+func TestCircuitBreakerErrorClasses(t *testing.T) {
+	cb := NewCircuitBreaker("testhost", &CircuitBreakerPolicy{MaxDelay: 10 * time.Minute, Jitter: 0})
+
+	// client errors don't disable the cb
+	cb.IssueRequest("abc", nil, func() error {
+		return &HTTPError{StatusCode: 404}
+	})
+	if cb.state != CircuitBreakerStateEnabled {
+		t.Errorf("expected CircuitBreakerStateEnabled, found %s", cb.state)
+	}
+
+	// 401 -> signals that a new login is needed
+	cb.IssueRequest("abc", nil, func() error {
+		return &HTTPError{StatusCode: 401}
+	})
+	if cb.state != CircuitBreakerStateEnabled {
+		t.Errorf("expected CircuitBreakerStateEnabled, found %s", cb.state)
+	}
+	select {
+	case <-cb.Unauthorized():
+	default:
+		t.Errorf("expected unauthorized signal")
+	}
+
+	// 429 -> requests are held off, without disabling the cb
+	cb.IssueRequest("abc", nil, func() error {
+		return &HTTPError{StatusCode: 429, RetryAfter: 1 * time.Second}
+	})
+	if cb.state != CircuitBreakerStateEnabled {
+		t.Errorf("expected CircuitBreakerStateEnabled, found %s", cb.state)
+	}
+	err := cb.IssueRequest("abc", nil, func() error {
+		panic("this should never be called!")
+	})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	err = cb.IssueRequest("abc", nil, func() error {
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected nil error, got: %s", err.Error())
+	}
+
+	// server errors disable the cb
+	cb.IssueRequest("abc", nil, func() error {
+		return &HTTPError{StatusCode: 503}
+	})
+	if cb.state != CircuitBreakerStateDisabled {
+		t.Errorf("expected CircuitBreakerStateDisabled, found %s", cb.state)
+	}
+
+	expectedCounts := map[string]int{
+		"ErrorClassNotFound":        1,
+		"ErrorClassUnauthorized":    1,
+		"ErrorClassTooManyRequests": 1,
+		"ErrorClassServerError":     1,
+	}
+	errorCounts := cb.Model().ErrorCounts
+	if len(errorCounts) != len(expectedCounts) {
+		t.Errorf("expected %+v, found %+v", expectedCounts, errorCounts)
+	}
+	for class, count := range expectedCounts {
+		if errorCounts[class] != count {
+			t.Errorf("expected %d for %s, found %d", count, class, errorCounts[class])
+		}
+	}
+}
+
+// TestCircuitBreakerPolicy .....
+func TestCircuitBreakerPolicy(t *testing.T) {
+	cb := NewCircuitBreaker("testhost", &CircuitBreakerPolicy{BaseDelay: 1 * time.Second, FailureThreshold: 3, HalfOpenProbes: 2, Jitter: 0})
+	fail := func() error { return fmt.Errorf("planned failure") }
+	succeed := func() error { return nil }
+
+	// below the failure threshold -> cb remains enabled
+	cb.IssueRequest("abc", nil, fail)
+	cb.IssueRequest("abc", nil, fail)
+	if cb.state != CircuitBreakerStateEnabled {
+		t.Errorf("expected CircuitBreakerStateEnabled, found %s", cb.state)
+	}
+	cb.IssueRequest("abc", nil, fail)
+	if cb.state != CircuitBreakerStateDisabled {
+		t.Errorf("expected CircuitBreakerStateDisabled, found %s", cb.state)
+	}
+
+	// checking -> only lets through 2 probes
+	time.Sleep(1500 * time.Millisecond)
+	release := make(chan struct{})
+	probeErrs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			probeErrs <- cb.IssueRequest("abc", nil, func() error {
+				<-release
+				return nil
+			})
+		}()
+	}
+	time.Sleep(250 * time.Millisecond)
+	if err := cb.IssueRequest("abc", nil, succeed); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-probeErrs; err != nil {
+			t.Errorf("expected nil error, got: %s", err.Error())
+		}
+	}
+	if cb.state != CircuitBreakerStateEnabled {
+		t.Errorf("expected CircuitBreakerStateEnabled, found %s", cb.state)
+	}
+
+	policy := cb.Model().Policy
+	if policy.FailureThreshold != 3 || policy.HalfOpenProbes != 2 || policy.MaxDelay.Minutes != DefaultCircuitBreakerPolicy.MaxDelay.Minutes() {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+}
+
+// TestCircuitBreakerTooManyRequestsWhileChecking .....
+func TestCircuitBreakerTooManyRequestsWhileChecking(t *testing.T) {
+	cb := NewCircuitBreaker("testhost", &CircuitBreakerPolicy{BaseDelay: 1 * time.Second, FailureThreshold: 1, HalfOpenProbes: 2, Jitter: 0})
+	succeed := func() error { return nil }
+
+	cb.IssueRequest("abc", nil, func() error { return fmt.Errorf("planned failure") })
+	if cb.state != CircuitBreakerStateDisabled {
+		t.Errorf("expected CircuitBreakerStateDisabled, found %s", cb.state)
+	}
+
+	// first probe -> 429; the hub answered, so it counts as a successful probe
+	time.Sleep(1500 * time.Millisecond)
+	cb.IssueRequest("abc", nil, func() error {
+		return &HTTPError{StatusCode: 429, RetryAfter: 1 * time.Second}
+	})
+	if cb.state != CircuitBreakerStateChecking {
+		t.Errorf("expected CircuitBreakerStateChecking, found %s", cb.state)
+	}
+
+	// held off by the 429 -> doesn't use up the second probe
+	if err := cb.IssueRequest("abc", nil, func() error {
+		panic("this should never be called!")
+	}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if err := cb.IssueRequest("abc", nil, succeed); err != nil {
+		t.Errorf("expected nil error, got: %s", err.Error())
+	}
+	if cb.state != CircuitBreakerStateEnabled {
+		t.Errorf("expected CircuitBreakerStateEnabled, found %s", cb.state)
+	}
+	if err := cb.IssueRequest("abc", nil, succeed); err != nil {
+		t.Errorf("expected nil error, got: %s", err.Error())
+	}
+}
+
+// TestCircuitBreakerBackoff .....
+func TestCircuitBreakerBackoff(t *testing.T) {
+	cb := NewCircuitBreaker("testhost", &CircuitBreakerPolicy{BaseDelay: 10 * time.Second, MaxDelay: 30 * time.Second, Jitter: 0.5})
+	testCases := []struct {
+		consecutiveFailures int
+		delay               time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, testCase := range testCases {
+		cb.consecutiveFailures = testCase.consecutiveFailures
+		for i := 0; i < 100; i++ {
+			backoff := cb.backoff()
+			if backoff < testCase.delay/2 || backoff > testCase.delay*3/2 {
+				t.Errorf("for %d failures, expected %s +/- 50%%, found %s", testCase.consecutiveFailures, testCase.delay, backoff)
+			}
+		}
+	}
+
+	defaults := (*CircuitBreakerPolicy)(nil).withDefaults()
+	if *defaults != *DefaultCircuitBreakerPolicy {
+		t.Errorf("expected %+v, found %+v", DefaultCircuitBreakerPolicy, defaults)
+	}
+	invalid := (&CircuitBreakerPolicy{BaseDelay: time.Minute, MaxDelay: time.Second, Jitter: 3}).withDefaults()
+	if invalid.MaxDelay != time.Minute || invalid.Jitter != DefaultCircuitBreakerPolicy.Jitter {
+		t.Errorf("unexpected policy %+v", invalid)
+	}
+}
+
 // TestCircuitBreakerConsecutiveFailures .....
 func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
-	cb := NewCircuitBreaker("testhost", 10*time.Minute)
+	cb := NewCircuitBreaker("testhost", &CircuitBreakerPolicy{MaxDelay: 10 * time.Minute, Jitter: 0})
 	if cb.state != CircuitBreakerStateEnabled {
 		t.Errorf("expected CircuitBreakerStateEnabled, found %s", cb.state)
 	}
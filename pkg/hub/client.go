@@ -23,6 +23,7 @@ package hub
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/blackducksoftware/hub-client-go/hubapi"
@@ -30,30 +31,59 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	maxHubExponentialBackoffDuration = 5 * time.Minute
-)
-
-// Client combines a raw hub client with a circuit breaker
+// Client combines a raw hub client with a circuit breaker and a rate limiter
 type Client struct {
 	rawClient      RawClientInterface
 	circuitBreaker *CircuitBreaker
 	host           string
-	username       string
-	password       string
+	// the rate limiter is replaced, not changed, when its policy changes, so
+	// that requests in flight give their slot back to the limiter they took it
+	// from
+	rateLimiterMutex sync.RWMutex
+	rateLimiter      *RateLimiter
+	// credentials can be changed while the client is in use
+	credentialsMutex sync.RWMutex
+	username         string
+	password         string
+	// apiToken, if set, is used instead of username and password
+	apiToken string
 }
 
-// NewClient returns a new Client.
-func NewClient(username string, password string, host string, rawClient RawClientInterface) *Client {
+// NewClient returns a new Client.  If `circuitBreakerPolicy` is nil, the
+// default policy is used.  If `rateLimitPolicy` is nil, requests aren't
+// limited.
+func NewClient(username string, password string, apiToken string, host string, rawClient RawClientInterface, circuitBreakerPolicy *CircuitBreakerPolicy, rateLimitPolicy *RateLimitPolicy) *Client {
 	return &Client{
 		rawClient:      rawClient,
-		circuitBreaker: NewCircuitBreaker(host, maxHubExponentialBackoffDuration),
+		circuitBreaker: NewCircuitBreaker(host, circuitBreakerPolicy),
+		rateLimiter:    NewRateLimiter(rateLimitPolicy),
 		username:       username,
 		password:       password,
+		apiToken:       apiToken,
 		host:           host,
 	}
 }
 
+func (client *Client) setCredentials(username string, password string, apiToken string) {
+	client.credentialsMutex.Lock()
+	defer client.credentialsMutex.Unlock()
+	client.username = username
+	client.password = password
+	client.apiToken = apiToken
+}
+
+func (client *Client) setRateLimitPolicy(policy *RateLimitPolicy) {
+	client.rateLimiterMutex.Lock()
+	defer client.rateLimiterMutex.Unlock()
+	client.rateLimiter = NewRateLimiter(policy)
+}
+
+func (client *Client) getRateLimiter() *RateLimiter {
+	client.rateLimiterMutex.RLock()
+	defer client.rateLimiterMutex.RUnlock()
+	return client.rateLimiter
+}
+
 func (client *Client) resetCircuitBreaker() {
 	client.circuitBreaker.Reset()
 }
@@ -63,7 +93,7 @@ func (client *Client) Version() (string, error) {
 	start := time.Now()
 	currentVersion, err := client.rawClient.CurrentVersion()
 	recordHubResponse(client.host, "version", err == nil)
-	recordHubResponseTime(client.host, "version", time.Now().Sub(start))
+	recordHubResponseTime(client.host, "version", 0, time.Now().Sub(start))
 	if err != nil {
 		log.Errorf("unable to get hub version: %s", err.Error())
 		return "", errors.Trace(err)
@@ -73,22 +103,46 @@ func (client *Client) Version() (string, error) {
 	return currentVersion.Version, nil
 }
 
-// SetTimeout is currently not concurrent-safe, and should be made so TODO
+// SetTimeout changes the timeout of the raw client, which takes care of
+// requests that are already in flight
 func (client *Client) SetTimeout(timeout time.Duration) {
 	client.rawClient.SetTimeout(timeout)
 }
 
-// login ignores the circuit breaker, just in case the circuit breaker
-// is closed because the calls were failing due to being unauthenticated.
-// Or maybe TODO we need to distinguish between different types of
-// request failure (network vs. 400 vs. 500 etc.)
+// login ignores the circuit breaker, since it's what fixes the 401s that
+// the circuit breaker reports through Unauthorized.
 // TODO could reset circuit breaker on success
-func (client *Client) login() error {
+// If the client has an API token, login exchanges it for a new bearer token,
+// and returns that; otherwise, it logs in with the username and password, and
+// returns a nil bearer token.
+func (client *Client) login() (*BearerToken, error) {
+	client.credentialsMutex.RLock()
+	username, password, apiToken := client.username, client.password, client.apiToken
+	client.credentialsMutex.RUnlock()
 	start := time.Now()
-	err := client.rawClient.Login(client.username, client.password)
+	if apiToken != "" {
+		bearerToken, err := client.rawClient.AuthenticateWithAPIToken(apiToken)
+		recordHubResponse(client.host, "authenticate", err == nil)
+		recordHubResponseTime(client.host, "authenticate", 0, time.Now().Sub(start))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return bearerToken, nil
+	}
+	err := client.rawClient.Login(username, password)
 	recordHubResponse(client.host, "login", err == nil)
-	recordHubResponseTime(client.host, "login", time.Now().Sub(start))
-	return errors.Trace(err)
+	recordHubResponseTime(client.host, "login", 0, time.Now().Sub(start))
+	return nil, errors.Trace(err)
+}
+
+// issueBearerToken exchanges `apiToken` for a bearer token, for someone
+// other than the client to use.
+func (client *Client) issueBearerToken(apiToken string) (*BearerToken, error) {
+	start := time.Now()
+	bearerToken, err := client.rawClient.IssueBearerToken(apiToken)
+	recordHubResponse(client.host, "issueBearerToken", err == nil)
+	recordHubResponseTime(client.host, "issueBearerToken", 0, time.Now().Sub(start))
+	return bearerToken, errors.Trace(err)
 }
 
 // FetchScan finds ScanResults by starting from a code location,
@@ -239,7 +293,7 @@ func (client *Client) fetchScanResultsUsingCodeLocation(codeLocation hubapi.Code
 func (client *Client) listAllProjects() (*hubapi.ProjectList, error) {
 	var list *hubapi.ProjectList
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("allProjects", func() error {
+	err := client.circuitBreaker.IssueRequest("allProjects", client.getRateLimiter(), func() error {
 		limit := 2000000
 		list, fetchError = client.rawClient.ListProjects(&hubapi.GetListOptions{Limit: &limit})
 		return fetchError
@@ -250,29 +304,55 @@ func (client *Client) listAllProjects() (*hubapi.ProjectList, error) {
 	return list, fetchError
 }
 
-// ListAllCodeLocations pulls in all code locations in a single API call.
-func (client *Client) listAllCodeLocations() (*hubapi.CodeLocationList, error) {
-	var list *hubapi.CodeLocationList
-	var fetchError error
-	err := client.circuitBreaker.IssueRequest("allCodeLocations", func() error {
-		limit := 2000000
-		list, fetchError = client.rawClient.ListAllCodeLocations(&hubapi.GetListOptions{Limit: &limit})
-		if fetchError != nil {
-			log.Errorf("fetch error: %s", fetchError.Error())
+// listCodeLocationPages pages through the hub's code locations, `pageSize`
+// at a time, sorted by `sort`.  It stops early at the first code location
+// for which `isDone` returns true, which isn't included.  `totalCount` is
+// the count reported with the last page; `isStable` is false if the count
+// changed while paging, in which case code locations may have been skipped.
+func (client *Client) listCodeLocationPages(pageSize int, sort string, isDone func(cl *hubapi.CodeLocation) bool) (codeLocations []hubapi.CodeLocation, totalCount int, isStable bool, err error) {
+	codeLocations = []hubapi.CodeLocation{}
+	isStable = true
+	firstTotalCount := -1
+	for offset := 0; ; offset += pageSize {
+		var page *hubapi.CodeLocationList
+		var fetchError error
+		err = client.circuitBreaker.IssueRequest("codeLocationsPage", client.getRateLimiter(), func() error {
+			limit, pageOffset := pageSize, offset
+			page, fetchError = client.rawClient.ListAllCodeLocations(&hubapi.GetListOptions{Limit: &limit, Offset: &pageOffset, Sort: &sort})
+			if fetchError != nil {
+				log.Errorf("fetch error: %s", fetchError.Error())
+			}
+			return fetchError
+		})
+		if err == nil {
+			err = fetchError
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+		totalCount = int(page.TotalCount)
+		if firstTotalCount < 0 {
+			firstTotalCount = totalCount
+		} else if totalCount != firstTotalCount {
+			isStable = false
+		}
+		for i := range page.Items {
+			if isDone(&page.Items[i]) {
+				return codeLocations, totalCount, isStable, nil
+			}
+			codeLocations = append(codeLocations, page.Items[i])
+		}
+		if len(page.Items) < pageSize || offset+len(page.Items) >= totalCount {
+			return codeLocations, totalCount, isStable, nil
 		}
-		return fetchError
-	})
-	if err != nil {
-		return nil, err
 	}
-	return list, fetchError
 }
 
 // ListCodeLocations ...
 func (client *Client) listCodeLocations(codeLocationName string) (*hubapi.CodeLocationList, error) {
 	var list *hubapi.CodeLocationList
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("codeLocations", func() error {
+	err := client.circuitBreaker.IssueRequest("codeLocations", client.getRateLimiter(), func() error {
 		queryString := fmt.Sprintf("name:%s", codeLocationName)
 		list, fetchError = client.rawClient.ListAllCodeLocations(&hubapi.GetListOptions{Q: &queryString})
 		return fetchError
@@ -287,7 +367,7 @@ func (client *Client) listCodeLocations(codeLocationName string) (*hubapi.CodeLo
 func (client *Client) getProjectVersion(link hubapi.ResourceLink) (*hubapi.ProjectVersion, error) {
 	var pv *hubapi.ProjectVersion
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("projectVersion", func() error {
+	err := client.circuitBreaker.IssueRequest("projectVersion", client.getRateLimiter(), func() error {
 		pv, fetchError = client.rawClient.GetProjectVersion(link)
 		return fetchError
 	})
@@ -301,7 +381,7 @@ func (client *Client) getProjectVersion(link hubapi.ResourceLink) (*hubapi.Proje
 func (client *Client) getProject(link hubapi.ResourceLink) (*hubapi.Project, error) {
 	var val *hubapi.Project
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("project", func() error {
+	err := client.circuitBreaker.IssueRequest("project", client.getRateLimiter(), func() error {
 		val, fetchError = client.rawClient.GetProject(link)
 		return fetchError
 	})
@@ -315,7 +395,7 @@ func (client *Client) getProject(link hubapi.ResourceLink) (*hubapi.Project, err
 func (client *Client) getProjectVersionRiskProfile(link hubapi.ResourceLink) (*hubapi.ProjectVersionRiskProfile, error) {
 	var val *hubapi.ProjectVersionRiskProfile
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("projectVersionRiskProfile", func() error {
+	err := client.circuitBreaker.IssueRequest("projectVersionRiskProfile", client.getRateLimiter(), func() error {
 		val, fetchError = client.rawClient.GetProjectVersionRiskProfile(link)
 		return fetchError
 	})
@@ -329,7 +409,7 @@ func (client *Client) getProjectVersionRiskProfile(link hubapi.ResourceLink) (*h
 func (client *Client) getProjectVersionPolicyStatus(link hubapi.ResourceLink) (*hubapi.ProjectVersionPolicyStatus, error) {
 	var val *hubapi.ProjectVersionPolicyStatus
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("projectVersionPolicyStatus", func() error {
+	err := client.circuitBreaker.IssueRequest("projectVersionPolicyStatus", client.getRateLimiter(), func() error {
 		val, fetchError = client.rawClient.GetProjectVersionPolicyStatus(link)
 		return fetchError
 	})
@@ -343,7 +423,7 @@ func (client *Client) getProjectVersionPolicyStatus(link hubapi.ResourceLink) (*
 func (client *Client) listScanSummaries(link hubapi.ResourceLink) (*hubapi.ScanSummaryList, error) {
 	var val *hubapi.ScanSummaryList
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("scanSummaries", func() error {
+	err := client.circuitBreaker.IssueRequest("scanSummaries", client.getRateLimiter(), func() error {
 		val, fetchError = client.rawClient.ListScanSummaries(link)
 		return fetchError
 	})
@@ -356,7 +436,7 @@ func (client *Client) listScanSummaries(link hubapi.ResourceLink) (*hubapi.ScanS
 // DeleteProjectVersion ...
 func (client *Client) deleteProjectVersion(projectVersionHRef string) error {
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("deleteVersion", func() error {
+	err := client.circuitBreaker.IssueRequest("deleteVersion", client.getRateLimiter(), func() error {
 		fetchError = client.rawClient.DeleteProjectVersion(projectVersionHRef)
 		return fetchError
 	})
@@ -369,7 +449,7 @@ func (client *Client) deleteProjectVersion(projectVersionHRef string) error {
 // DeleteCodeLocation ...
 func (client *Client) deleteCodeLocation(codeLocationHRef string) error {
 	var fetchError error
-	err := client.circuitBreaker.IssueRequest("deleteCodeLocation", func() error {
+	err := client.circuitBreaker.IssueRequest("deleteCodeLocation", client.getRateLimiter(), func() error {
 		fetchError = client.rawClient.DeleteCodeLocation(codeLocationHRef)
 		return fetchError
 	})
@@ -0,0 +1,149 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blackducksoftware/hub-client-go/hubapi"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultCodeLocationPageSize is the number of code locations fetched per
+	// request if the page size isn't configured
+	DefaultCodeLocationPageSize = 500
+	// codeLocationSyncOverlap is how far before the previous sync an
+	// incremental sync looks, to allow for clock skew between perceptor and
+	// the hub
+	codeLocationSyncOverlap = 5 * time.Minute
+	fullSyncSort            = "name ASC"
+	incrementalSyncSort     = "updatedAt DESC"
+)
+
+// codeLocationSync keeps track of when a hub's code locations were last
+// synced.  Syncs are only run by the fetch all scans timer, whose actions
+// never overlap; only the page size can be changed concurrently.
+type codeLocationSync struct {
+	pageSizeMutex sync.RWMutex
+	pageSize      int
+	lastSync      time.Time
+	lastFullSync  time.Time
+	needsFullSync bool
+}
+
+func newCodeLocationSync(pageSize int) *codeLocationSync {
+	cls := &codeLocationSync{needsFullSync: true}
+	cls.setPageSize(pageSize)
+	return cls
+}
+
+// setPageSize sets the page size; a size of 0 or less means the default.
+func (cls *codeLocationSync) setPageSize(pageSize int) {
+	if pageSize <= 0 {
+		pageSize = DefaultCodeLocationPageSize
+	}
+	cls.pageSizeMutex.Lock()
+	defer cls.pageSizeMutex.Unlock()
+	cls.pageSize = pageSize
+}
+
+func (cls *codeLocationSync) getPageSize() int {
+	cls.pageSizeMutex.RLock()
+	defer cls.pageSizeMutex.RUnlock()
+	return cls.pageSize
+}
+
+func (cls *codeLocationSync) isFullSyncDue(now time.Time, fullSyncPause time.Duration) bool {
+	return cls.needsFullSync || now.Sub(cls.lastFullSync) >= fullSyncPause
+}
+
+// wasUpdatedBefore returns true if `cl` was last updated before `since`.
+// Code locations whose update time can't be parsed are treated as updated.
+func wasUpdatedBefore(cl *hubapi.CodeLocation, since time.Time) bool {
+	updatedAt, err := time.Parse(time.RFC3339, cl.UpdatedAt)
+	if err != nil {
+		log.Debugf("unable to parse updatedAt of code location %s: %s", cl.Name, err.Error())
+		return false
+	}
+	return updatedAt.Before(since)
+}
+
+// syncCodeLocations brings the model up to date with the hub's code
+// locations.  The first sync is a full sync, which pages through all code
+// locations and finds the ones which were deleted from the hub.  After that,
+// incremental syncs only fetch the code locations updated since the previous
+// sync; a full sync is done every FullSyncPause, and right away if the hub's
+// count of code locations doesn't match the model's, which means that some
+// were deleted.
+func (hub *Hub) syncCodeLocations() {
+	now := time.Now()
+	state := hub.codeLocationSync
+	if state.isFullSyncDue(now, hub.getTimings().FullSyncPause) {
+		hub.recordError(fmt.Sprintf("full code location sync for hub %s", hub.host), hub.fullCodeLocationSync(now))
+		return
+	}
+	since := state.lastSync.Add(-codeLocationSyncOverlap)
+	log.Debugf("starting incremental code location sync for hub %s since %s", hub.host, since)
+	cls, totalCount, _, err := hub.client.listCodeLocationPages(state.getPageSize(), incrementalSyncSort, func(cl *hubapi.CodeLocation) bool {
+		return wasUpdatedBefore(cl, since)
+	})
+	if err != nil {
+		hub.recordError(fmt.Sprintf("incremental code location sync for hub %s", hub.host), err)
+		return
+	}
+	recordCodeLocationSync(hub.host, "incremental", len(cls))
+	state.lastSync = now
+	modelCount := hub.model.didFetchScans(cls)
+	if modelCount != totalCount {
+		log.Infof("hub %s has %d code locations, but %d are known: starting full sync", hub.host, totalCount, modelCount)
+		state.needsFullSync = true
+		hub.recordError(fmt.Sprintf("full code location sync for hub %s", hub.host), hub.fullCodeLocationSync(now))
+	}
+}
+
+func (hub *Hub) fullCodeLocationSync(now time.Time) error {
+	state := hub.codeLocationSync
+	log.Debugf("starting full code location sync for hub %s", hub.host)
+	cls, _, isStable, err := hub.client.listCodeLocationPages(state.getPageSize(), fullSyncSort, func(cl *hubapi.CodeLocation) bool {
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	recordCodeLocationSync(hub.host, "full", len(cls))
+	state.lastSync = now
+	state.lastFullSync = now
+	state.needsFullSync = false
+	hub.model.didFetchScans(cls)
+	// code locations may have been skipped if some were added or deleted
+	// while paging, so it's not safe to look for deleted ones
+	if !isStable {
+		log.Warnf("code locations of hub %s changed during full sync: not looking for deleted code locations", hub.host)
+		state.needsFullSync = true
+		return nil
+	}
+	hub.model.deleteMissingScans(cls, now)
+	return nil
+}
@@ -23,6 +23,7 @@ package hub
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/blackducksoftware/hub-client-go/hubapi"
 )
@@ -191,9 +192,11 @@ func (s ScanStage) String() string {
 
 // Scan is a wrapper around a Hub code location, and full scan results.
 // If `ScanResults` is nil, that means the ScanResults have not been fetched yet.
+// `TimeOfLastRefresh` is when `ScanResults` were last fetched.
 type Scan struct {
-	Stage       ScanStage
-	ScanResults *ScanResults
+	Stage             ScanStage
+	ScanResults       *ScanResults
+	TimeOfLastRefresh time.Time
 }
 
 // ScanResults models the results that we expect to get from the hub after
@@ -353,3 +356,11 @@ type DidRefreshScan struct {
 }
 
 func (drs *DidRefreshScan) updateMarker() {}
+
+// DidDeleteScan is published when a code location is found to have been
+// deleted from the hub.
+type DidDeleteScan struct {
+	Name string
+}
+
+func (dds *DidDeleteScan) updateMarker() {}
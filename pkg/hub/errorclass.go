@@ -0,0 +1,194 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package hub
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// defaultRetryAfter is how long to hold off after a 429 which doesn't say
+	// how long to wait -- which includes every 429 that hub-client-go sees,
+	// since it doesn't pass on response headers.
+	defaultRetryAfter = 1 * time.Minute
+)
+
+// hub-client-go reports unexpected status codes with this message
+var statusCodeRegex = regexp.MustCompile("got a (\\d{3}) response instead of a \\d{3}")
+
+// ErrorClass describes what went wrong with a hub request, which decides how
+// the circuit breaker reacts to it.
+type ErrorClass int
+
+// .....
+const (
+	ErrorClassNetwork         ErrorClass = iota
+	ErrorClassTimeout         ErrorClass = iota
+	ErrorClassUnauthorized    ErrorClass = iota
+	ErrorClassNotFound        ErrorClass = iota
+	ErrorClassClientError     ErrorClass = iota
+	ErrorClassServerError     ErrorClass = iota
+	ErrorClassTooManyRequests ErrorClass = iota
+	ErrorClassUnknown         ErrorClass = iota
+)
+
+// String .....
+func (class ErrorClass) String() string {
+	switch class {
+	case ErrorClassNetwork:
+		return "ErrorClassNetwork"
+	case ErrorClassTimeout:
+		return "ErrorClassTimeout"
+	case ErrorClassUnauthorized:
+		return "ErrorClassUnauthorized"
+	case ErrorClassNotFound:
+		return "ErrorClassNotFound"
+	case ErrorClassClientError:
+		return "ErrorClassClientError"
+	case ErrorClassServerError:
+		return "ErrorClassServerError"
+	case ErrorClassTooManyRequests:
+		return "ErrorClassTooManyRequests"
+	case ErrorClassUnknown:
+		return "ErrorClassUnknown"
+	}
+	panic(fmt.Errorf("invalid ErrorClass value: %d", class))
+}
+
+// tripsCircuitBreaker is true for errors which suggest the hub is down or
+// overloaded.  Client errors are the request's fault, so retrying other
+// requests right away is fine.  Errors which can't be classified are treated
+// as before, and trip the circuit breaker.
+func (class ErrorClass) tripsCircuitBreaker() bool {
+	switch class {
+	case ErrorClassNetwork, ErrorClassTimeout, ErrorClassServerError, ErrorClassUnknown:
+		return true
+	}
+	return false
+}
+
+// HTTPError is an unexpected response from the hub.
+type HTTPError struct {
+	StatusCode int
+	// RetryAfter is 0 unless the response had a Retry-After header
+	RetryAfter time.Duration
+	Message    string
+}
+
+// NewHTTPError creates an HTTPError from `resp`, picking up its Retry-After
+// header.
+func NewHTTPError(resp *http.Response, message string) *HTTPError {
+	return &HTTPError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Message:    message,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("got a %d response: %s", e.StatusCode, e.Message)
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date, returning 0 if it's missing or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	date, err := http.ParseTime(header)
+	if err != nil || date.Before(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
+
+// httpStatus returns the status code of `err`, and its Retry-After, if it's
+// an unexpected response from the hub.
+func httpStatus(err error) (int, time.Duration, bool) {
+	if httpErr, ok := errors.Cause(err).(*HTTPError); ok {
+		return httpErr.StatusCode, httpErr.RetryAfter, true
+	}
+	matches := statusCodeRegex.FindStringSubmatch(err.Error())
+	if len(matches) != 2 {
+		return 0, 0, false
+	}
+	statusCode, _ := strconv.Atoi(matches[1])
+	return statusCode, 0, true
+}
+
+// classifyError works out the ErrorClass of `err`, and for 429s, how long to
+// wait before issuing more requests.
+func classifyError(err error) (ErrorClass, time.Duration) {
+	if statusCode, retryAfter, ok := httpStatus(err); ok {
+		class := classifyStatusCode(statusCode)
+		if class != ErrorClassTooManyRequests {
+			return class, 0
+		}
+		if retryAfter <= 0 {
+			retryAfter = defaultRetryAfter
+		}
+		return class, retryAfter
+	}
+	if netErr, ok := errors.Cause(err).(net.Error); ok {
+		if netErr.Timeout() {
+			return ErrorClassTimeout, 0
+		}
+		return ErrorClassNetwork, 0
+	}
+	return ErrorClassUnknown, 0
+}
+
+func classifyStatusCode(statusCode int) ErrorClass {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorClassUnauthorized
+	case statusCode == http.StatusNotFound:
+		return ErrorClassNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorClassTooManyRequests
+	case statusCode >= 400 && statusCode < 500:
+		return ErrorClassClientError
+	case statusCode >= 500 && statusCode < 600:
+		return ErrorClassServerError
+	}
+	return ErrorClassUnknown
+}
+
+// isUnauthorized is true for 401s, which mean the session or bearer token
+// has expired, as opposed to 403s, which logging in again won't fix.
+func isUnauthorized(err error) bool {
+	statusCode, _, ok := httpStatus(err)
+	return ok && statusCode == http.StatusUnauthorized
+}
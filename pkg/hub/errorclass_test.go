@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package hub
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// TestClassifyError .....
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		err        error
+		class      ErrorClass
+		retryAfter time.Duration
+	}{
+		{&HTTPError{StatusCode: 401}, ErrorClassUnauthorized, 0},
+		{&HTTPError{StatusCode: 403}, ErrorClassUnauthorized, 0},
+		{&HTTPError{StatusCode: 404}, ErrorClassNotFound, 0},
+		{&HTTPError{StatusCode: 400}, ErrorClassClientError, 0},
+		{&HTTPError{StatusCode: 503}, ErrorClassServerError, 0},
+		{&HTTPError{StatusCode: 429, RetryAfter: 10 * time.Second}, ErrorClassTooManyRequests, 10 * time.Second},
+		{&HTTPError{StatusCode: 429}, ErrorClassTooManyRequests, defaultRetryAfter},
+		// the way hub-client-go reports status codes
+		{errors.Annotate(fmt.Errorf("got a 500 response instead of a 200"), "Error validating HTTP Response"), ErrorClassServerError, 0},
+		{errors.Annotate(fmt.Errorf("got a 404 response instead of a 200"), "Error validating HTTP Response"), ErrorClassNotFound, 0},
+		{errors.Annotate(fmt.Errorf("got a 429 response instead of a 200"), "Error validating HTTP Response"), ErrorClassTooManyRequests, defaultRetryAfter},
+		{errors.Annotate(&url.Error{Op: "Get", URL: "https://hub", Err: &net.DNSError{IsTimeout: true}}, "Error getting HTTP Response"), ErrorClassTimeout, 0},
+		{errors.Annotate(&url.Error{Op: "Get", URL: "https://hub", Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}}, "Error getting HTTP Response"), ErrorClassNetwork, 0},
+		{fmt.Errorf("planned failure"), ErrorClassUnknown, 0},
+	}
+	for _, testCase := range testCases {
+		class, retryAfter := classifyError(testCase.err)
+		if class != testCase.class {
+			t.Errorf("for %s, expected %s, found %s", testCase.err.Error(), testCase.class, class)
+		}
+		if retryAfter != testCase.retryAfter {
+			t.Errorf("for %s, expected retry after %s, found %s", testCase.err.Error(), testCase.retryAfter, retryAfter)
+		}
+	}
+	if !isUnauthorized(errors.Annotate(fmt.Errorf("got a 401 response instead of a 200"), "Error validating HTTP Response")) {
+		t.Errorf("expected 401 to be unauthorized")
+	}
+	if isUnauthorized(&HTTPError{StatusCode: 403}) {
+		t.Errorf("expected 403 not to be unauthorized")
+	}
+}
+
+// TestParseRetryAfter .....
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	testCases := map[string]time.Duration{
+		"":     0,
+		"120":  2 * time.Minute,
+		"-5":   0,
+		"soon": 0,
+		now.Add(time.Hour).Format(http.TimeFormat):  time.Hour,
+		now.Add(-time.Hour).Format(http.TimeFormat): 0,
+	}
+	for header, expected := range testCases {
+		actual := parseRetryAfter(header, now)
+		if actual != expected {
+			t.Errorf("for %s, expected %s, found %s", header, expected, actual)
+		}
+	}
+}
@@ -23,6 +23,7 @@ package hub
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/blackducksoftware/perceptor/pkg/api"
@@ -30,6 +31,15 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// bearerTokenRefreshMargin is how long before a bearer token expires
+	// that it's refreshed
+	bearerTokenRefreshMargin = 5 * time.Minute
+	// minBearerTokenRefreshPause keeps short-lived bearer tokens from
+	// being refreshed continuously
+	minBearerTokenRefreshPause = 30 * time.Second
+)
+
 type hubAction struct {
 	name  string
 	apply func() error
@@ -42,13 +52,21 @@ type Hub struct {
 	host                 string
 	concurrrentScanLimit int
 	status               ClientStatus
+	timeOfStatusChange   time.Time
+	// bearerToken is nil unless the hub is authenticated with an API token
+	bearerToken *BearerToken
+	// isRefreshingScans keeps refreshes of scan results from piling up
+	isRefreshingScans bool
 	// data
-	model  *Model
-	errors []error
+	model            *Model
+	errors           []error
+	codeLocationSync *codeLocationSync
+	// timings can be changed while the hub is running
+	timingsMutex sync.RWMutex
+	timings      Timings
 	// timers
 	getMetricsTimer              *util.Timer
 	loginTimer                   *util.Timer
-	refreshScansTimer            *util.Timer
 	fetchAllScansTimer           *util.Timer
 	fetchScansTimer              *util.Timer
 	checkScansForCompletionTimer *util.Timer
@@ -57,15 +75,41 @@ type Hub struct {
 	actions chan *hubAction
 }
 
+// Options are the settings of a hub.  Each of them can also be changed
+// while the hub is running.
+type Options struct {
+	Username string
+	Password string
+	// APIToken, if set, is used to authenticate instead of Username and
+	// Password
+	APIToken            string
+	ConcurrentScanLimit int
+	// Timings defaults to DefaultTimings if nil
+	Timings *Timings
+	// CircuitBreakerPolicy defaults to DefaultCircuitBreakerPolicy if nil
+	CircuitBreakerPolicy *CircuitBreakerPolicy
+	// RateLimitPolicy, if nil, means requests to the hub aren't limited
+	RateLimitPolicy *RateLimitPolicy
+	// CodeLocationPageSize defaults to DefaultCodeLocationPageSize if 0
+	CodeLocationPageSize int
+}
+
 // NewHub returns a new Black Duck.  It will not be logged in.
-func NewHub(username string, password string, host string, concurrentScanLimit int, rawClient RawClientInterface, timings *Timings) *Hub {
+func NewHub(host string, rawClient RawClientInterface, options *Options) *Hub {
+	timings := options.Timings
+	if timings == nil {
+		timings = DefaultTimings
+	}
 	hub := &Hub{
-		client:               NewClient(username, password, host, rawClient),
+		client:               NewClient(options.Username, options.Password, options.APIToken, host, rawClient, options.CircuitBreakerPolicy, options.RateLimitPolicy),
 		host:                 host,
-		concurrrentScanLimit: concurrentScanLimit,
+		concurrrentScanLimit: options.ConcurrentScanLimit,
 		status:               ClientStatusDown,
+		timeOfStatusChange:   time.Now(),
+		timings:              *timings,
 		model:                nil,
 		errors:               []error{},
+		codeLocationSync:     newCodeLocationSync(options.CodeLocationPageSize),
 		stop:                 make(chan struct{}),
 		actions:              make(chan *hubAction)}
 	// model setup
@@ -78,7 +122,20 @@ func NewHub(username string, password string, host string, concurrentScanLimit i
 	hub.fetchScansTimer = hub.startFetchUnknownScansTimer(timings.FetchUnknownScansPause)
 	hub.fetchAllScansTimer = hub.startFetchAllScansTimer(timings.FetchAllScansPause)
 	hub.loginTimer = hub.startLoginTimer(timings.LoginPause)
-	hub.refreshScansTimer = hub.startRefreshScansTimer(timings.RefreshScanThreshold)
+	// log in again right away if the session or bearer token has expired,
+	// rather than waiting for the login timer
+	go func() {
+		unauthorized := hub.client.circuitBreaker.Unauthorized()
+		for {
+			select {
+			case <-hub.stop:
+				return
+			case <-unauthorized:
+				log.Infof("request to hub %s was unauthorized, logging in again", hub.host)
+				hub.login()
+			}
+		}
+	}()
 	// action processing
 	go func() {
 		for {
@@ -128,38 +185,65 @@ func (hub *Hub) apiModel() *api.ModelBlackDuck {
 	apiModel.Errors = errors
 	apiModel.Status = hub.status.String()
 	apiModel.CircuitBreaker = hub.client.circuitBreaker.Model()
+	apiModel.RateLimiter = hub.client.getRateLimiter().Model()
 	return apiModel
 }
 
-// login logins to the Black Duck instance
+// send hands `action` to the action loop.  Once the hub is stopped the
+// action is dropped and send returns false; getters then close their channel
+// instead of answering, so that callers holding on to a stopped hub -- for
+// example, one removed from the HubManager -- receive a zero value rather than
+// blocking forever.
+func (hub *Hub) send(action *hubAction) bool {
+	select {
+	case <-hub.stop:
+		return false
+	case hub.actions <- action:
+		return true
+	}
+}
+
+// login logins to the Black Duck instance.  Hubs which authenticate with an
+// API token log in again shortly before their bearer token expires; others,
+// and hubs which failed to log in, log in again after the login pause.
 func (hub *Hub) login() {
 	log.Debugf("starting to login to hub %s", hub.host)
-	err := hub.client.login()
-	hub.actions <- &hubAction{"didLogin", func() error {
+	bearerToken, err := hub.client.login()
+	hub.send(&hubAction{"didLogin", func() error {
 		hub.recordError(fmt.Sprintf("login to hub %s", hub.host), err)
+		if err == nil && bearerToken != nil {
+			hub.bearerToken = bearerToken
+			refreshPause := bearerTokenRefreshPause(bearerToken, time.Now())
+			log.Debugf("refreshing bearer token for hub %s in %s", hub.host, refreshPause)
+			hub.loginTimer.SetDelay(refreshPause)
+		} else {
+			hub.loginTimer.SetDelay(hub.getTimings().LoginPause)
+		}
 		if err != nil && hub.status == ClientStatusUp {
 			hub.status = ClientStatusDown
+			hub.timeOfStatusChange = time.Now()
 			hub.recordError(fmt.Sprintf("pause check scans for completion timer %s", hub.host), hub.checkScansForCompletionTimer.Pause())
 			hub.recordError(fmt.Sprintf("pause fetch scans timer %s", hub.host), hub.fetchScansTimer.Pause())
 			hub.recordError(fmt.Sprintf("pause fetch all scans timer %s", hub.host), hub.fetchAllScansTimer.Pause())
-			hub.recordError(fmt.Sprintf("pause refresh scans timer %s", hub.host), hub.refreshScansTimer.Pause())
 		} else if err == nil && hub.status == ClientStatusDown {
 			hub.status = ClientStatusUp
+			hub.timeOfStatusChange = time.Now()
 			hub.recordError(fmt.Sprintf("resume check scans for completion timer %s", hub.host), hub.checkScansForCompletionTimer.Resume(true))
 			hub.recordError(fmt.Sprintf("resume fetch scans timer  %s", hub.host), hub.fetchScansTimer.Resume(true))
 			hub.recordError(fmt.Sprintf("resume fetch all scans timer  %s", hub.host), hub.fetchAllScansTimer.Resume(true))
-			hub.recordError(fmt.Sprintf("resume refresh scans timer  %s", hub.host), hub.refreshScansTimer.Resume(true))
 		}
 		return nil
-	}}
+	}})
 }
 
-// fetchAllScans fetches all Black Duck scans
-func (hub *Hub) fetchAllScans() {
-	log.Debugf("starting to fetch all scans")
-	cls, err := hub.client.listAllCodeLocations()
-	hub.recordError(fmt.Sprintf("fetch all code locations for hub %s", hub.host), err)
-	hub.model.didFetchScans(cls, err)
+// bearerTokenRefreshPause returns how long to wait before refreshing
+// `bearerToken`.
+func bearerTokenRefreshPause(bearerToken *BearerToken, now time.Time) time.Duration {
+	pause := bearerToken.Expiry.Sub(now) - bearerTokenRefreshMargin
+	if pause < minBearerTokenRefreshPause {
+		return minBearerTokenRefreshPause
+	}
+	return pause
 }
 
 // fetchAllScans fetches all unknown Black Duck scans
@@ -169,13 +253,6 @@ func (hub *Hub) fetchUnknownScans() {
 }
 
 // Regular jobs
-// startRefreshScansTimer return the start refresh scan timer
-func (hub *Hub) startRefreshScansTimer(pause time.Duration) *util.Timer {
-	return util.NewTimer(fmt.Sprintf("refresh-scans-%s", hub.host), pause, hub.stop, func() {
-		// TODO implement
-	})
-}
-
 // startLoginTimer return the start login timer
 func (hub *Hub) startLoginTimer(pause time.Duration) *util.Timer {
 	return util.NewRunningTimer(fmt.Sprintf("login-%s", hub.host), pause, hub.stop, true, func() {
@@ -186,7 +263,7 @@ func (hub *Hub) startLoginTimer(pause time.Duration) *util.Timer {
 // startFetchAllScansTimer return the start fetch all scans timer
 func (hub *Hub) startFetchAllScansTimer(pause time.Duration) *util.Timer {
 	return util.NewTimer(fmt.Sprintf("fetchScans-%s", hub.host), pause, hub.stop, func() {
-		hub.fetchAllScans()
+		hub.syncCodeLocations()
 	})
 }
 
@@ -265,7 +342,122 @@ func (hub *Hub) Host() string {
 
 // ConcurrentScanLimit return the concurrent scan limit
 func (hub *Hub) ConcurrentScanLimit() int {
-	return hub.concurrrentScanLimit
+	ch := make(chan int)
+	ok := hub.send(&hubAction{"getConcurrentScanLimit", func() error {
+		ch <- hub.concurrrentScanLimit
+		return nil
+	}})
+	if !ok {
+		close(ch)
+	}
+	return <-ch
+}
+
+// SetConcurrentScanLimit changes the concurrent scan limit.  Scans already
+// in progress aren't affected.
+func (hub *Hub) SetConcurrentScanLimit(limit int) {
+	hub.send(&hubAction{"setConcurrentScanLimit", func() error {
+		log.Infof("changing concurrent scan limit of hub %s from %d to %d", hub.host, hub.concurrrentScanLimit, limit)
+		hub.concurrrentScanLimit = limit
+		return nil
+	}})
+}
+
+// SetCredentials changes the credentials the hub logs in with, and logs in
+// again right away, without waiting for the login timer.
+func (hub *Hub) SetCredentials(username string, password string, apiToken string) {
+	recordEvent(hub.host, "setCredentials")
+	hub.client.setCredentials(username, password, apiToken)
+	go hub.login()
+}
+
+// SetCircuitBreakerPolicy changes the policy of the circuit breaker
+func (hub *Hub) SetCircuitBreakerPolicy(policy *CircuitBreakerPolicy) {
+	recordEvent(hub.host, "setCircuitBreakerPolicy")
+	hub.client.circuitBreaker.SetPolicy(policy)
+}
+
+// SetRateLimitPolicy changes how requests to the hub are limited; a nil
+// policy turns the limits off.  Requests which are already waiting keep the
+// old limits.
+func (hub *Hub) SetRateLimitPolicy(policy *RateLimitPolicy) {
+	recordEvent(hub.host, "setRateLimitPolicy")
+	hub.client.setRateLimitPolicy(policy)
+}
+
+func (hub *Hub) getTimings() Timings {
+	hub.timingsMutex.RLock()
+	defer hub.timingsMutex.RUnlock()
+	return hub.timings
+}
+
+// SetTimings changes the pauses between the hub's regular jobs.  Timers whose
+// pause changed pick up the new one right away.
+func (hub *Hub) SetTimings(timings *Timings) {
+	recordEvent(hub.host, "setTimings")
+	hub.timingsMutex.Lock()
+	previous := hub.timings
+	hub.timings = *timings
+	hub.timingsMutex.Unlock()
+	if previous.ScanCompletionPause != timings.ScanCompletionPause {
+		hub.checkScansForCompletionTimer.SetDelay(timings.ScanCompletionPause)
+	}
+	if previous.FetchUnknownScansPause != timings.FetchUnknownScansPause {
+		hub.fetchScansTimer.SetDelay(timings.FetchUnknownScansPause)
+	}
+	if previous.FetchAllScansPause != timings.FetchAllScansPause {
+		hub.fetchAllScansTimer.SetDelay(timings.FetchAllScansPause)
+	}
+	if previous.GetMetricsPause != timings.GetMetricsPause {
+		hub.getMetricsTimer.SetDelay(timings.GetMetricsPause)
+	}
+	if previous.LoginPause != timings.LoginPause {
+		// with an API token, the login timer follows the bearer token's expiry
+		hub.send(&hubAction{"setLoginPause", func() error {
+			if hub.bearerToken == nil {
+				hub.loginTimer.SetDelay(timings.LoginPause)
+			}
+			return nil
+		}})
+	}
+	// the full sync pause and the refresh threshold are checked whenever
+	// they're needed
+}
+
+// RefreshScans re-fetches the results of the hub's completed scans, whose
+// images were last refreshed at least RefreshScanThreshold ago according to
+// `timesOfLastRefresh`.  Scans which the hub doesn't know about are ignored,
+// and so is the whole call if the hub is down or still busy with the
+// previous refresh.
+func (hub *Hub) RefreshScans(timesOfLastRefresh map[string]time.Time) {
+	hub.send(&hubAction{"refreshScans", func() error {
+		if hub.status != ClientStatusUp || hub.isRefreshingScans {
+			return nil
+		}
+		hub.isRefreshingScans = true
+		threshold := hub.getTimings().RefreshScanThreshold
+		go func() {
+			hub.model.refreshScans(timesOfLastRefresh, threshold)
+			hub.send(&hubAction{"didRefreshScans", func() error {
+				hub.isRefreshingScans = false
+				return nil
+			}})
+		}()
+		return nil
+	}})
+}
+
+// SetTimeout changes the timeout of the hub's HTTP requests
+func (hub *Hub) SetTimeout(timeout time.Duration) {
+	recordEvent(hub.host, "setTimeout")
+	hub.client.SetTimeout(timeout)
+}
+
+// SetCodeLocationPageSize changes how many code locations are fetched per
+// request; 0 means DefaultCodeLocationPageSize.
+func (hub *Hub) SetCodeLocationPageSize(pageSize int) {
+	recordEvent(hub.host, "setCodeLocationPageSize")
+	hub.codeLocationSync.setPageSize(pageSize)
 }
 
 // ResetCircuitBreaker resets the circuit breaker
@@ -277,10 +469,57 @@ func (hub *Hub) ResetCircuitBreaker() {
 // Model return the model
 func (hub *Hub) Model() <-chan *api.ModelBlackDuck {
 	ch := make(chan *api.ModelBlackDuck)
-	hub.actions <- &hubAction{"getModel", func() error {
+	ok := hub.send(&hubAction{"getModel", func() error {
 		ch <- hub.apiModel()
 		return nil
-	}}
+	}})
+	if !ok {
+		close(ch)
+	}
+	return ch
+}
+
+// Outage returns how long the hub has been down -- including before it first
+// logged in -- or 0 if it's up.
+func (hub *Hub) Outage() <-chan time.Duration {
+	ch := make(chan time.Duration)
+	ok := hub.send(&hubAction{"getOutage", func() error {
+		var outage time.Duration
+		if hub.status == ClientStatusDown {
+			outage = time.Since(hub.timeOfStatusChange)
+		}
+		ch <- outage
+		return nil
+	}})
+	if !ok {
+		close(ch)
+	}
+	return ch
+}
+
+// IssueBearerToken exchanges `apiToken` for a new bearer token, which is
+// handed on rather than used by the hub.
+func (hub *Hub) IssueBearerToken(apiToken string) (*BearerToken, error) {
+	recordEvent(hub.host, "issueBearerToken")
+	return hub.client.issueBearerToken(apiToken)
+}
+
+// BearerToken returns the bearer token the hub is currently authenticated
+// with, which is nil unless the hub uses an API token and is logged in.
+func (hub *Hub) BearerToken() <-chan *BearerToken {
+	ch := make(chan *BearerToken)
+	ok := hub.send(&hubAction{"getBearerToken", func() error {
+		var bearerToken *BearerToken
+		if hub.bearerToken != nil && hub.status == ClientStatusUp {
+			copied := *hub.bearerToken
+			bearerToken = &copied
+		}
+		ch <- bearerToken
+		return nil
+	}})
+	if !ok {
+		close(ch)
+	}
 	return ch
 }
 
@@ -35,11 +35,12 @@ func newClient(ignoreEvents bool) (*MockRawClient, *Hub) {
 		ScanCompletionPause:    125 * time.Millisecond,
 		FetchUnknownScansPause: 250 * time.Millisecond,
 		FetchAllScansPause:     500 * time.Millisecond,
+		FullSyncPause:          DefaultTimings.FullSyncPause,
 		GetMetricsPause:        DefaultTimings.GetMetricsPause,
 		LoginPause:             DefaultTimings.LoginPause,
 		RefreshScanThreshold:   DefaultTimings.RefreshScanThreshold,
 	}
-	hub := NewHub("sysadmin", "password", "host1", 2, rawClient, timings)
+	hub := NewHub("host1", rawClient, &Options{Username: "sysadmin", Password: "password", ConcurrentScanLimit: 2, Timings: timings})
 	if ignoreEvents {
 		go func() {
 			updates := hub.Updates()
@@ -88,5 +89,161 @@ func RunClientTests() {
 			// Expect(<-client.CodeLocations()).To(Equal(map[string]ScanStage{"c": ScanStageComplete, "abc": ScanStageComplete, "a": ScanStageComplete, "b": ScanStageComplete}))
 			// Expect(<-client.InProgressScans()).To(Equal([]string{}))
 		})
+
+		It("should page through code locations, and sync new and deleted ones", func() {
+			rawClient := NewMockRawClient(false, []string{"a", "b", "c", "d", "e"})
+			timings := &Timings{
+				ScanCompletionPause:    DefaultTimings.ScanCompletionPause,
+				FetchUnknownScansPause: 100 * time.Millisecond,
+				FetchAllScansPause:     200 * time.Millisecond,
+				FullSyncPause:          DefaultTimings.FullSyncPause,
+				GetMetricsPause:        DefaultTimings.GetMetricsPause,
+				LoginPause:             DefaultTimings.LoginPause,
+				RefreshScanThreshold:   DefaultTimings.RefreshScanThreshold,
+			}
+			client := NewHub("host1", rawClient, &Options{Username: "sysadmin", Password: "password", ConcurrentScanLimit: 2, Timings: timings, CodeLocationPageSize: 2})
+			defer client.Stop()
+			deleted := make(chan string)
+			go func() {
+				for update := range client.Updates() {
+					if didDelete, ok := update.(*DidDeleteScan); ok {
+						deleted <- didDelete.Name
+					}
+				}
+			}()
+			time.Sleep(500 * time.Millisecond)
+			Expect(getScanResults(client)).To(HaveLen(5))
+
+			// incremental syncs pick up new code locations
+			rawClient.addCodeLocation("f", ScanStageComplete)
+			time.Sleep(500 * time.Millisecond)
+			Expect(getScanResults(client)).To(HaveKey("f"))
+
+			// the count of code locations no longer matches, so a full sync
+			// finds the deleted one
+			rawClient.deleteCodeLocation("b")
+			Eventually(deleted, 2*time.Second).Should(Receive(Equal("b")))
+			Expect(getScanResults(client)).To(HaveLen(5))
+			Expect(getScanResults(client)).NotTo(HaveKey("b"))
+		})
+
+		It("should apply new timings to its running timers", func() {
+			rawClient := NewMockRawClient(false, []string{"a"})
+			timings := &Timings{
+				ScanCompletionPause:    DefaultTimings.ScanCompletionPause,
+				FetchUnknownScansPause: 100 * time.Millisecond,
+				FetchAllScansPause:     time.Hour,
+				FullSyncPause:          DefaultTimings.FullSyncPause,
+				GetMetricsPause:        DefaultTimings.GetMetricsPause,
+				LoginPause:             DefaultTimings.LoginPause,
+				RefreshScanThreshold:   DefaultTimings.RefreshScanThreshold,
+			}
+			client := NewHub("host1", rawClient, &Options{Username: "sysadmin", Password: "password", ConcurrentScanLimit: 2, Timings: timings})
+			defer client.Stop()
+			time.Sleep(250 * time.Millisecond)
+			Expect(getScanResults(client)).To(HaveLen(1))
+
+			// the next sync is an hour away
+			rawClient.addCodeLocation("b", ScanStageComplete)
+			time.Sleep(250 * time.Millisecond)
+			Expect(getScanResults(client)).To(HaveLen(1))
+
+			newTimings := *timings
+			newTimings.FetchAllScansPause = 100 * time.Millisecond
+			client.SetTimings(&newTimings)
+			time.Sleep(500 * time.Millisecond)
+			Expect(getScanResults(client)).To(HaveKey("b"))
+		})
+
+		It("should authenticate with an API token instead of a password", func() {
+			rawClient := NewMockRawClient(false, []string{"a"})
+			client := NewHub("host1", rawClient, &Options{APIToken: "api-token", ConcurrentScanLimit: 2, Timings: DefaultTimings})
+			defer client.Stop()
+			time.Sleep(250 * time.Millisecond)
+			bearerToken := <-client.BearerToken()
+			Expect(bearerToken).NotTo(BeNil())
+			Expect(bearerToken.Token).To(Equal("mock-bearer-token-1"))
+
+			_, passwordClient := newClient(true)
+			defer passwordClient.Stop()
+			time.Sleep(250 * time.Millisecond)
+			Expect(<-passwordClient.BearerToken()).To(BeNil())
+		})
+
+		It("should log in again when its credentials change", func() {
+			rawClient, client := newClient(true)
+			defer client.Stop()
+			time.Sleep(250 * time.Millisecond)
+			Expect(<-client.BearerToken()).To(BeNil())
+			client.SetCredentials("", "", "api-token")
+			time.Sleep(250 * time.Millisecond)
+			Expect(rawClient.bearerTokensIssued()).To(Equal(1))
+			Expect(<-client.BearerToken()).NotTo(BeNil())
+		})
+
+		It("should log in again right away when a request is unauthorized", func() {
+			rawClient, client := newClient(true)
+			defer client.Stop()
+			time.Sleep(250 * time.Millisecond)
+			Expect(rawClient.isLoggedIn()).To(BeTrue())
+			// the session expires
+			rawClient.setLoggedIn(false)
+			time.Sleep(1 * time.Second)
+			Expect(rawClient.isLoggedIn()).To(BeTrue())
+			circuitBreaker := (<-client.Model()).CircuitBreaker
+			Expect(circuitBreaker.State).To(Equal(CircuitBreakerStateEnabled.String()))
+			Expect(circuitBreaker.ErrorCounts[ErrorClassUnauthorized.String()]).To(BeNumerically(">", 0))
+		})
+
+		It("should refresh bearer tokens shortly before they expire", func() {
+			now := time.Now()
+			Expect(bearerTokenRefreshPause(&BearerToken{Expiry: now.Add(2 * time.Hour)}, now)).To(Equal(2*time.Hour - bearerTokenRefreshMargin))
+			Expect(bearerTokenRefreshPause(&BearerToken{Expiry: now.Add(time.Minute)}, now)).To(Equal(minBearerTokenRefreshPause))
+			Expect(bearerTokenRefreshPause(&BearerToken{Expiry: now.Add(-time.Minute)}, now)).To(Equal(minBearerTokenRefreshPause))
+		})
+
+		It("should refresh completed scans", func() {
+			rawClient := NewMockRawClient(false, []string{"a"})
+			timings := &Timings{
+				ScanCompletionPause:    DefaultTimings.ScanCompletionPause,
+				FetchUnknownScansPause: 100 * time.Millisecond,
+				FetchAllScansPause:     DefaultTimings.FetchAllScansPause,
+				FullSyncPause:          DefaultTimings.FullSyncPause,
+				GetMetricsPause:        DefaultTimings.GetMetricsPause,
+				LoginPause:             DefaultTimings.LoginPause,
+				RefreshScanThreshold:   200 * time.Millisecond,
+			}
+			client := NewHub("host1", rawClient, &Options{Username: "sysadmin", Password: "password", ConcurrentScanLimit: 2, Timings: timings})
+			defer client.Stop()
+			updates := client.Updates()
+			time.Sleep(500 * time.Millisecond)
+
+			// only scans whose images are due for a refresh are refreshed
+			client.RefreshScans(map[string]time.Time{"b": {}, "a": time.Now()})
+			Consistently(func() bool {
+				select {
+				case update := <-updates:
+					_, ok := update.(*DidRefreshScan)
+					return ok
+				default:
+					return false
+				}
+			}, 300*time.Millisecond).Should(BeFalse())
+
+			client.RefreshScans(map[string]time.Time{"b": {}, "a": time.Now().Add(-time.Second)})
+			timeout := time.After(2 * time.Second)
+			for {
+				select {
+				case update := <-updates:
+					if refresh, ok := update.(*DidRefreshScan); ok {
+						Expect(refresh.Name).To(Equal("a"))
+						Expect(refresh.Results).NotTo(BeNil())
+						return
+					}
+				case <-timeout:
+					Fail("expected a DidRefreshScan update")
+				}
+			}
+		})
 	})
 }
@@ -31,12 +31,16 @@ import (
 var hubResponse *prometheus.CounterVec
 var hubData *prometheus.CounterVec
 var hubResponseTime *prometheus.HistogramVec
+var hubQueueWaitTime *prometheus.HistogramVec
+var hubErrorClass *prometheus.CounterVec
 var circuitBreakerState *prometheus.GaugeVec
 var hubRequestIsCircuitBreakerEnabled *prometheus.CounterVec
 var circuitBreakerTransitions *prometheus.CounterVec
 var scanStageGauge *prometheus.GaugeVec
 var eventCounter *prometheus.CounterVec
 var errorCounter *prometheus.CounterVec
+var codeLocationSyncCounter *prometheus.CounterVec
+var deletedCodeLocationCounter *prometheus.CounterVec
 
 func recordHubResponse(host string, name string, isSuccessful bool) {
 	isSuccessString := fmt.Sprintf("%t", isSuccessful)
@@ -48,9 +52,17 @@ func recordHubData(host string, name string, isOkay bool) {
 	hubData.With(prometheus.Labels{"host": host, "name": name, "okay": isOkayString}).Inc()
 }
 
-func recordHubResponseTime(host string, name string, duration time.Duration) {
+// recordHubResponseTime records how long a request took, and separately how
+// long it waited for the hub's rate limiter beforehand.
+func recordHubResponseTime(host string, name string, queueWait time.Duration, duration time.Duration) {
 	milliseconds := float64(duration / time.Millisecond)
 	hubResponseTime.With(prometheus.Labels{"host": host, "name": name}).Observe(milliseconds)
+	queueWaitMilliseconds := float64(queueWait / time.Millisecond)
+	hubQueueWaitTime.With(prometheus.Labels{"host": host, "name": name}).Observe(queueWaitMilliseconds)
+}
+
+func recordHubErrorClass(host string, name string, class ErrorClass) {
+	hubErrorClass.With(prometheus.Labels{"host": host, "name": name, "class": class.String()}).Inc()
 }
 
 func recordCircuitBreakerState(host string, state CircuitBreakerState) {
@@ -85,6 +97,14 @@ func recordEvent(host string, event string) {
 	eventCounter.With(prometheus.Labels{"host": host, "event": event}).Inc()
 }
 
+func recordCodeLocationSync(host string, syncType string, codeLocationCount int) {
+	codeLocationSyncCounter.With(prometheus.Labels{"host": host, "type": syncType}).Add(float64(codeLocationCount))
+}
+
+func recordDeletedCodeLocations(host string, count int) {
+	deletedCodeLocationCounter.With(prometheus.Labels{"host": host}).Add(float64(count))
+}
+
 func recordError(host string, name string) {
 	errorCounter.With(prometheus.Labels{"host": host, "name": name}).Inc()
 }
@@ -117,6 +137,40 @@ func init() {
 	}, []string{"host", "name"})
 	prometheus.MustRegister(hubResponseTime)
 
+	hubQueueWaitTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "hub_request_queue_wait_time",
+		Help:      "tracks how long Hub requests waited for the rate limiter in milliseconds",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 20),
+	}, []string{"host", "name"})
+	prometheus.MustRegister(hubQueueWaitTime)
+
+	hubErrorClass = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "perceptor",
+		Subsystem:   "core",
+		Name:        "http_hub_request_errors",
+		Help:        "names and error classes of failed HTTP requests issued by perceptor to the hub",
+		ConstLabels: map[string]string{},
+	}, []string{"host", "name", "class"})
+	prometheus.MustRegister(hubErrorClass)
+
+	codeLocationSyncCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "hub_code_location_sync_items",
+		Help:      "code locations fetched from the hub by full and incremental syncs",
+	}, []string{"host", "type"})
+	prometheus.MustRegister(codeLocationSyncCounter)
+
+	deletedCodeLocationCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "perceptor",
+		Subsystem: "core",
+		Name:      "hub_deleted_code_locations",
+		Help:      "code locations which were found to have been deleted from the hub",
+	}, []string{"host"})
+	prometheus.MustRegister(deletedCodeLocationCounter)
+
 	circuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "perceptor",
 		Subsystem: "core",
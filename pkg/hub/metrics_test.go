@@ -32,7 +32,10 @@ import (
 func TestMetrics(t *testing.T) {
 	recordHubData("testhost", "abc", true)
 	recordHubResponse("testhost", "qrs", false)
-	recordHubResponseTime("testhost", "abc", time.Now().Sub(time.Now()))
+	recordHubResponseTime("testhost", "abc", time.Now().Sub(time.Now()), time.Now().Sub(time.Now()))
+	recordHubErrorClass("testhost", "abc", ErrorClassNotFound)
+	recordCodeLocationSync("testhost", "full", 3)
+	recordDeletedCodeLocations("testhost", 1)
 	recordCircuitBreakerState("testhost", CircuitBreakerStateDisabled)
 	recordCircuitBreakerIsEnabled("testhost", true)
 	recordCircuitBreakerTransition("testhost", CircuitBreakerStateEnabled, CircuitBreakerStateDisabled)
@@ -24,53 +24,106 @@ package hub
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blackducksoftware/hub-client-go/hubapi"
 	log "github.com/sirupsen/logrus"
 )
 
-// MockRawClient ...
+// MockRawClient ...  Its state is guarded by a mutex, since tests change
+// the mock hub while the Hub's goroutines are using it.
 type MockRawClient struct {
-	IsLoggedIn    bool
-	ShouldFail    bool
-	CodeLocations map[string]ScanStage
+	mutex               sync.Mutex
+	IsLoggedIn          bool
+	ShouldFail          bool
+	CodeLocations       map[string]ScanStage
+	BearerTokenLifetime time.Duration
+	BearerTokensIssued  int
+	updatedAt           map[string]time.Time
 }
 
 // NewMockRawClient ...
 func NewMockRawClient(shouldFail bool, initialCodeLocationNames []string) *MockRawClient {
 	codeLocations := map[string]ScanStage{}
+	updatedAt := map[string]time.Time{}
 	for _, name := range initialCodeLocationNames {
 		codeLocations[name] = ScanStageComplete
+		updatedAt[name] = time.Now()
 	}
 	return &MockRawClient{
-		IsLoggedIn:    false,
-		ShouldFail:    shouldFail,
-		CodeLocations: codeLocations,
+		IsLoggedIn:          false,
+		ShouldFail:          shouldFail,
+		CodeLocations:       codeLocations,
+		BearerTokenLifetime: 2 * time.Hour,
+		updatedAt:           updatedAt,
 	}
 }
 
 func (mhc *MockRawClient) addCodeLocation(name string, stage ScanStage) error {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if _, ok := mhc.CodeLocations[name]; ok {
 		return fmt.Errorf("code location %s already found", name)
 	}
 	mhc.CodeLocations[name] = stage
+	mhc.touchCodeLocation(name)
 	return nil
 }
 
+// touchCodeLocation must be called with the mutex held
+func (mhc *MockRawClient) touchCodeLocation(name string) {
+	if mhc.updatedAt == nil {
+		mhc.updatedAt = map[string]time.Time{}
+	}
+	mhc.updatedAt[name] = time.Now()
+}
+
+func (mhc *MockRawClient) deleteCodeLocation(name string) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
+	delete(mhc.CodeLocations, name)
+	delete(mhc.updatedAt, name)
+}
+
+func (mhc *MockRawClient) isLoggedIn() bool {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
+	return mhc.IsLoggedIn
+}
+
+func (mhc *MockRawClient) setLoggedIn(isLoggedIn bool) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
+	mhc.IsLoggedIn = isLoggedIn
+}
+
+func (mhc *MockRawClient) bearerTokensIssued() int {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
+	return mhc.BearerTokensIssued
+}
+
 func (mhc *MockRawClient) setCodeLocationStage(name string, stage ScanStage) error {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if _, ok := mhc.CodeLocations[name]; !ok {
 		return fmt.Errorf("code location %s not found", name)
 	}
 	mhc.CodeLocations[name] = stage
+	mhc.touchCodeLocation(name)
 	return nil
 }
 
 // ListAllCodeLocations ...
 func (mhc *MockRawClient) ListAllCodeLocations(options *hubapi.GetListOptions) (*hubapi.CodeLocationList, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return nil, fmt.Errorf("not logged in")
+		return nil, &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return nil, fmt.Errorf("unable to fetch code locations list")
@@ -94,21 +147,59 @@ func (mhc *MockRawClient) ListAllCodeLocations(options *hubapi.GetListOptions) (
 					},
 					Name:      name,
 					Type:      "",
-					UpdatedAt: "",
+					UpdatedAt: mhc.updatedAtString(name),
 					URL:       "",
 				})
 		}
 	}
+	totalCount := len(cls)
+	cls = sortAndPageCodeLocations(cls, options)
 	clList := &hubapi.CodeLocationList{
 		Items:      cls,
 		Meta:       hubapi.Meta{},
-		TotalCount: uint32(len(cls)),
+		TotalCount: uint32(totalCount),
 	}
 	return clList, nil
 }
 
+// updatedAtString must be called with the mutex held
+func (mhc *MockRawClient) updatedAtString(name string) string {
+	updatedAt, ok := mhc.updatedAt[name]
+	if !ok {
+		return ""
+	}
+	// a fixed width, so that the strings sort in time order
+	return updatedAt.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// sortAndPageCodeLocations sorts by name, or by most recently updated if
+// `options` asks for it, and then applies the offset and limit.
+func sortAndPageCodeLocations(cls []hubapi.CodeLocation, options *hubapi.GetListOptions) []hubapi.CodeLocation {
+	sort.Slice(cls, func(i, j int) bool {
+		if options != nil && options.Sort != nil && strings.HasPrefix(*options.Sort, "updatedAt") && cls[i].UpdatedAt != cls[j].UpdatedAt {
+			return cls[i].UpdatedAt > cls[j].UpdatedAt
+		}
+		return cls[i].Name < cls[j].Name
+	})
+	if options == nil {
+		return cls
+	}
+	if options.Offset != nil {
+		if *options.Offset >= len(cls) {
+			return []hubapi.CodeLocation{}
+		}
+		cls = cls[*options.Offset:]
+	}
+	if options.Limit != nil && *options.Limit < len(cls) {
+		cls = cls[:*options.Limit]
+	}
+	return cls
+}
+
 // CurrentVersion ...
 func (mhc *MockRawClient) CurrentVersion() (*hubapi.CurrentVersion, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if mhc.ShouldFail {
 		return nil, fmt.Errorf("unable to fetch current version")
 	}
@@ -120,8 +211,10 @@ func (mhc *MockRawClient) CurrentVersion() (*hubapi.CurrentVersion, error) {
 
 // ListProjects ...
 func (mhc *MockRawClient) ListProjects(options *hubapi.GetListOptions) (*hubapi.ProjectList, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return nil, fmt.Errorf("not logged in")
+		return nil, &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return nil, fmt.Errorf("unable to fetch project list")
@@ -131,8 +224,10 @@ func (mhc *MockRawClient) ListProjects(options *hubapi.GetListOptions) (*hubapi.
 
 // DeleteCodeLocation ...
 func (mhc *MockRawClient) DeleteCodeLocation(scanName string) error {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return fmt.Errorf("not logged in")
+		return &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return fmt.Errorf("unable to delete code location %s", scanName)
@@ -142,8 +237,10 @@ func (mhc *MockRawClient) DeleteCodeLocation(scanName string) error {
 
 // DeleteProjectVersion ...
 func (mhc *MockRawClient) DeleteProjectVersion(name string) error {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return fmt.Errorf("not logged in")
+		return &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return fmt.Errorf("unable to delete project %s", name)
@@ -153,8 +250,10 @@ func (mhc *MockRawClient) DeleteProjectVersion(name string) error {
 
 // GetProject ...
 func (mhc *MockRawClient) GetProject(link hubapi.ResourceLink) (*hubapi.Project, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return nil, fmt.Errorf("not logged in")
+		return nil, &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return nil, fmt.Errorf("unable to fetch project")
@@ -164,8 +263,10 @@ func (mhc *MockRawClient) GetProject(link hubapi.ResourceLink) (*hubapi.Project,
 
 // GetProjectVersion ...
 func (mhc *MockRawClient) GetProjectVersion(link hubapi.ResourceLink) (*hubapi.ProjectVersion, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return nil, fmt.Errorf("not logged in")
+		return nil, &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return nil, fmt.Errorf("unable to fetch project version")
@@ -189,8 +290,10 @@ func (mhc *MockRawClient) GetProjectVersion(link hubapi.ResourceLink) (*hubapi.P
 
 // ListScanSummaries ...
 func (mhc *MockRawClient) ListScanSummaries(link hubapi.ResourceLink) (*hubapi.ScanSummaryList, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return nil, fmt.Errorf("not logged in")
+		return nil, &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return nil, fmt.Errorf("unable to fetch scan summary list")
@@ -212,6 +315,8 @@ func (mhc *MockRawClient) ListScanSummaries(link hubapi.ResourceLink) (*hubapi.S
 
 // Login ...
 func (mhc *MockRawClient) Login(username string, password string) error {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if mhc.ShouldFail {
 		mhc.IsLoggedIn = false
 		return fmt.Errorf("unable to login")
@@ -220,13 +325,45 @@ func (mhc *MockRawClient) Login(username string, password string) error {
 	return nil
 }
 
+// AuthenticateWithAPIToken ...
+func (mhc *MockRawClient) AuthenticateWithAPIToken(apiToken string) (*BearerToken, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
+	if mhc.ShouldFail {
+		mhc.IsLoggedIn = false
+		return nil, fmt.Errorf("unable to authenticate with API token")
+	}
+	mhc.IsLoggedIn = true
+	mhc.BearerTokensIssued++
+	return &BearerToken{
+		Token:  fmt.Sprintf("mock-bearer-token-%d", mhc.BearerTokensIssued),
+		Expiry: time.Now().Add(mhc.BearerTokenLifetime),
+	}, nil
+}
+
+// IssueBearerToken ...
+func (mhc *MockRawClient) IssueBearerToken(apiToken string) (*BearerToken, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
+	if mhc.ShouldFail {
+		return nil, fmt.Errorf("unable to issue bearer token")
+	}
+	mhc.BearerTokensIssued++
+	return &BearerToken{
+		Token:  fmt.Sprintf("mock-bearer-token-%d-for-%s", mhc.BearerTokensIssued, apiToken),
+		Expiry: time.Now().Add(mhc.BearerTokenLifetime),
+	}, nil
+}
+
 // SetTimeout ...
 func (mhc *MockRawClient) SetTimeout(timeout time.Duration) {}
 
 // GetProjectVersionRiskProfile ...
 func (mhc *MockRawClient) GetProjectVersionRiskProfile(link hubapi.ResourceLink) (*hubapi.ProjectVersionRiskProfile, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return nil, fmt.Errorf("not logged in")
+		return nil, &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return nil, fmt.Errorf("unable to fetch project version risk profile")
@@ -236,8 +373,10 @@ func (mhc *MockRawClient) GetProjectVersionRiskProfile(link hubapi.ResourceLink)
 
 // GetProjectVersionPolicyStatus ...
 func (mhc *MockRawClient) GetProjectVersionPolicyStatus(link hubapi.ResourceLink) (*hubapi.ProjectVersionPolicyStatus, error) {
+	mhc.mutex.Lock()
+	defer mhc.mutex.Unlock()
 	if !mhc.IsLoggedIn {
-		return nil, fmt.Errorf("not logged in")
+		return nil, &HTTPError{StatusCode: http.StatusUnauthorized, Message: "not logged in"}
 	}
 	if mhc.ShouldFail {
 		return nil, fmt.Errorf("unable to fetch project version policy status")
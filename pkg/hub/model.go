@@ -23,6 +23,7 @@ package hub
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/blackducksoftware/hub-client-go/hubapi"
 	"github.com/blackducksoftware/perceptor/pkg/api"
@@ -43,6 +44,9 @@ type Model struct {
 	publishUpdatesCh chan Update
 	stop             <-chan struct{}
 	actions          chan *modelAction
+	// codeLocations are the names of the scans which are known to exist on
+	// the hub, as opposed to scans which are still running the scan client
+	codeLocations map[string]bool
 }
 
 // NewModel return the Black Duck model
@@ -51,6 +55,7 @@ func NewModel(host string, stop <-chan struct{}, fetchScan func(string) (*ScanRe
 		host:             host,
 		hasFetchedScans:  false,
 		scans:            map[string]*Scan{},
+		codeLocations:    map[string]bool{},
 		fetchScan:        fetchScan,
 		publishUpdatesCh: make(chan Update),
 		stop:             stop,
@@ -77,6 +82,18 @@ func NewModel(host string, stop <-chan struct{}, fetchScan func(string) (*ScanRe
 
 // Private methods
 
+// send hands `action` to the action loop.  Once the model is stopped the
+// action is dropped and send returns false, so that callers holding on to a
+// stopped hub don't block forever.
+func (model *Model) send(action *modelAction) bool {
+	select {
+	case <-model.stop:
+		return false
+	case model.actions <- action:
+		return true
+	}
+}
+
 func (model *Model) publish(update Update) {
 	go func() {
 		select {
@@ -89,14 +106,17 @@ func (model *Model) publish(update Update) {
 
 func (model *Model) getStateMetrics() {
 	ch := make(chan map[ScanStage]int)
-	model.actions <- &modelAction{"getClientStateMetrics", func() error {
+	ok := model.send(&modelAction{"getClientStateMetrics", func() error {
 		scanStageCounts := map[ScanStage]int{}
 		for _, scan := range model.scans {
 			scanStageCounts[scan.Stage]++
 		}
 		ch <- scanStageCounts
 		return nil
-	}}
+	}})
+	if !ok {
+		return
+	}
 	recordScanStageCounts(model.host, <-ch)
 }
 
@@ -120,27 +140,64 @@ func (model *Model) apiModel() *api.ModelBlackDuck {
 		HasLoadedAllCodeLocations: model.scans != nil,
 		CodeLocations:             codeLocations,
 		CircuitBreaker:            nil,
+		RateLimiter:               nil,
 		Host:                      model.host,
 	}
 }
 
-func (model *Model) didFetchScans(cls *hubapi.CodeLocationList, err error) {
-	model.actions <- &modelAction{"didFetchScans", func() error {
-		if err == nil {
-			model.hasFetchedScans = true
-			for _, cl := range cls.Items {
-				if _, ok := model.scans[cl.Name]; !ok {
-					model.scans[cl.Name] = &Scan{Stage: ScanStageUnknown, ScanResults: nil}
-				}
+// didFetchScans adds the code locations which aren't known yet, and returns
+// how many code locations are known to exist on the hub.
+func (model *Model) didFetchScans(cls []hubapi.CodeLocation) int {
+	ch := make(chan int)
+	ok := model.send(&modelAction{"didFetchScans", func() error {
+		model.hasFetchedScans = true
+		for _, cl := range cls {
+			if _, ok := model.scans[cl.Name]; !ok {
+				model.scans[cl.Name] = &Scan{Stage: ScanStageUnknown, ScanResults: nil}
+			}
+			model.codeLocations[cl.Name] = true
+		}
+		ch <- len(model.codeLocations)
+		return nil
+	}})
+	if !ok {
+		return 0
+	}
+	return <-ch
+}
+
+// deleteMissingScans removes the code locations which aren't in `cls`, the
+// complete list of the hub's code locations as of `syncStart`.  Scans which
+// are in progress, or whose results were fetched after `syncStart`, are kept.
+func (model *Model) deleteMissingScans(cls []hubapi.CodeLocation, syncStart time.Time) {
+	model.send(&modelAction{"deleteMissingScans", func() error {
+		found := map[string]bool{}
+		for _, cl := range cls {
+			found[cl.Name] = true
+		}
+		deletedCount := 0
+		for name := range model.codeLocations {
+			if found[name] {
+				continue
+			}
+			scan, ok := model.scans[name]
+			if ok && (scan.Stage == ScanStageScanClient || scan.Stage == ScanStageHubScan || scan.TimeOfLastRefresh.After(syncStart)) {
+				continue
 			}
+			log.Infof("code location %s was deleted from hub %s", name, model.host)
+			delete(model.codeLocations, name)
+			delete(model.scans, name)
+			model.publish(&DidDeleteScan{Name: name})
+			deletedCount++
 		}
+		recordDeletedCodeLocations(model.host, deletedCount)
 		return nil
-	}}
+	}})
 }
 
 func (model *Model) getUnknownScans() []string {
 	ch := make(chan []string)
-	model.actions <- &modelAction{"getUnknownScans", func() error {
+	ok := model.send(&modelAction{"getUnknownScans", func() error {
 		unknownScans := []string{}
 		for name, scan := range model.scans {
 			if scan.Stage == ScanStageUnknown {
@@ -149,12 +206,15 @@ func (model *Model) getUnknownScans() []string {
 		}
 		ch <- unknownScans
 		return nil
-	}}
+	}})
+	if !ok {
+		close(ch)
+	}
 	return <-ch
 }
 
 func (model *Model) didFetchScanResults(scanResults *ScanResults) {
-	model.actions <- &modelAction{"didFetchScanResults", func() error {
+	model.send(&modelAction{"didFetchScanResults", func() error {
 		scan, ok := model.scans[scanResults.CodeLocationName]
 		if !ok {
 			scan = &Scan{
@@ -163,6 +223,7 @@ func (model *Model) didFetchScanResults(scanResults *ScanResults) {
 			}
 			model.scans[scanResults.CodeLocationName] = scan
 		}
+		model.codeLocations[scanResults.CodeLocationName] = true
 		switch scanResults.ScanSummaryStatus() {
 		case ScanSummaryStatusSuccess:
 			scan.Stage = ScanStageComplete
@@ -172,11 +233,12 @@ func (model *Model) didFetchScanResults(scanResults *ScanResults) {
 		case ScanSummaryStatusFailure:
 			scan.Stage = ScanStageFailure
 		}
-		model.scans[scanResults.CodeLocationName].ScanResults = scanResults
+		scan.ScanResults = scanResults
+		scan.TimeOfLastRefresh = time.Now()
 		update := &DidFindScan{Name: scanResults.CodeLocationName, Results: scanResults}
 		model.publish(update)
 		return nil
-	}}
+	}})
 }
 
 func (model *Model) fetchUnknownScans() {
@@ -200,7 +262,7 @@ func (model *Model) fetchUnknownScans() {
 }
 
 func (model *Model) scanDidFinish(scanResults *ScanResults) {
-	model.actions <- &modelAction{"scanDidFinish", func() error {
+	model.send(&modelAction{"scanDidFinish", func() error {
 		scanName := scanResults.CodeLocationName
 		scan, ok := model.scans[scanName]
 		if !ok {
@@ -212,11 +274,13 @@ func (model *Model) scanDidFinish(scanResults *ScanResults) {
 		scan.Stage = ScanStageComplete
 		if scanResults != nil {
 			scan.ScanResults = scanResults
+			scan.TimeOfLastRefresh = time.Now()
+			model.codeLocations[scanName] = true
 		}
 		update := &DidFinishScan{Name: scanResults.CodeLocationName, Results: scanResults}
 		model.publish(update)
 		return nil
-	}}
+	}})
 }
 
 func (model *Model) checkScansForCompletion() {
@@ -241,19 +305,83 @@ func (model *Model) checkScansForCompletion() {
 	}
 }
 
+func (model *Model) getScansToRefresh(timesOfLastRefresh map[string]time.Time, threshold time.Duration) []string {
+	ch := make(chan []string)
+	ok := model.send(&modelAction{"getScansToRefresh", func() error {
+		scanNames := []string{}
+		now := time.Now()
+		for name, timeOfLastRefresh := range timesOfLastRefresh {
+			scan, ok := model.scans[name]
+			if ok && scan.Stage == ScanStageComplete && now.Sub(timeOfLastRefresh) >= threshold {
+				scanNames = append(scanNames, name)
+			}
+		}
+		ch <- scanNames
+		return nil
+	}})
+	if !ok {
+		close(ch)
+	}
+	return <-ch
+}
+
+func (model *Model) didRefreshScan(scanResults *ScanResults) {
+	model.send(&modelAction{"didRefreshScan", func() error {
+		scanName := scanResults.CodeLocationName
+		scan, ok := model.scans[scanName]
+		if !ok {
+			return fmt.Errorf("unable to handle didRefreshScan for %s: not found", scanName)
+		}
+		if scan.Stage != ScanStageComplete {
+			return fmt.Errorf("unable to handle didRefreshScan for %s: expected stage Complete, found %s", scanName, scan.Stage.String())
+		}
+		scan.ScanResults = scanResults
+		scan.TimeOfLastRefresh = time.Now()
+		update := &DidRefreshScan{Name: scanName, Results: scanResults}
+		model.publish(update)
+		return nil
+	}})
+}
+
+// refreshScans re-fetches the results of completed scans which, according
+// to `timesOfLastRefresh`, haven't been refreshed in at least `threshold`,
+// since the hub keeps updating vulnerabilities and policy violations after
+// a scan finishes.
+func (model *Model) refreshScans(timesOfLastRefresh map[string]time.Time, threshold time.Duration) {
+	scanNames := model.getScansToRefresh(timesOfLastRefresh, threshold)
+	log.Debugf("starting to refresh %d scans", len(scanNames))
+	for _, scanName := range scanNames {
+		scanResults, err := model.fetchScan(scanName)
+		if err != nil {
+			log.Errorf("unable to refresh scan %s: %s", scanName, err.Error())
+			continue
+		}
+		if scanResults == nil {
+			log.Debugf("nothing found for scan %s", scanName)
+			continue
+		}
+		if scanResults.ScanSummaryStatus() != ScanSummaryStatusSuccess {
+			log.Warnf("ignoring refresh of scan %s: expected status success, found %s", scanName, scanResults.ScanSummaryStatus())
+			continue
+		}
+		model.didRefreshScan(scanResults)
+	}
+	log.Debugf("finished refreshing scans")
+}
+
 // Some public API methods ...
 
 // StartScanClient starts the scan client
 func (model *Model) StartScanClient(scanName string) {
-	model.actions <- &modelAction{"startScanClient", func() error {
+	model.send(&modelAction{"startScanClient", func() error {
 		model.scans[scanName] = &Scan{Stage: ScanStageScanClient}
 		return nil
-	}}
+	}})
 }
 
 // FinishScanClient finishes the scan client
 func (model *Model) FinishScanClient(scanName string, scanErr error) {
-	model.actions <- &modelAction{"finishScanClient", func() error {
+	model.send(&modelAction{"finishScanClient", func() error {
 		scan, ok := model.scans[scanName]
 		if !ok {
 			return fmt.Errorf("unable to handle finishScanClient for %s: not found", scanName)
@@ -267,13 +395,13 @@ func (model *Model) FinishScanClient(scanName string, scanErr error) {
 			scan.Stage = ScanStageFailure
 		}
 		return nil
-	}}
+	}})
 }
 
 // ScansCount returns the scan count
 func (model *Model) ScansCount() <-chan int {
 	ch := make(chan int)
-	model.actions <- &modelAction{"getScansCount", func() error {
+	ok := model.send(&modelAction{"getScansCount", func() error {
 		count := 0
 		for _, cl := range model.scans {
 			if cl.Stage != ScanStageFailure {
@@ -282,14 +410,17 @@ func (model *Model) ScansCount() <-chan int {
 		}
 		ch <- count
 		return nil
-	}}
+	}})
+	if !ok {
+		close(ch)
+	}
 	return ch
 }
 
 // InProgressScans returns the inprogress scan count
 func (model *Model) InProgressScans() <-chan []string {
 	ch := make(chan []string)
-	model.actions <- &modelAction{"getInProgressScans", func() error {
+	ok := model.send(&modelAction{"getInProgressScans", func() error {
 		scans := []string{}
 		for scanName, scan := range model.scans {
 			if scan.Stage == ScanStageHubScan || scan.Stage == ScanStageScanClient {
@@ -298,41 +429,53 @@ func (model *Model) InProgressScans() <-chan []string {
 		}
 		ch <- scans
 		return nil
-	}}
+	}})
+	if !ok {
+		close(ch)
+	}
 	return ch
 }
 
 // ScanResults returns the scan results
 func (model *Model) ScanResults() <-chan map[string]*Scan {
 	ch := make(chan map[string]*Scan)
-	model.actions <- &modelAction{"getScanResults", func() error {
+	ok := model.send(&modelAction{"getScanResults", func() error {
 		allScanResults := map[string]*Scan{}
 		for name, scan := range model.scans {
-			allScanResults[name] = &Scan{Stage: scan.Stage, ScanResults: scan.ScanResults}
+			allScanResults[name] = &Scan{Stage: scan.Stage, ScanResults: scan.ScanResults, TimeOfLastRefresh: scan.TimeOfLastRefresh}
 		}
 		ch <- allScanResults
 		return nil
-	}}
+	}})
+	if !ok {
+		close(ch)
+	}
 	return ch
 }
 
 // Model returns the model
 func (model *Model) Model() <-chan *api.ModelBlackDuck {
 	ch := make(chan *api.ModelBlackDuck)
-	model.actions <- &modelAction{"getModel", func() error {
+	ok := model.send(&modelAction{"getModel", func() error {
 		ch <- model.apiModel()
 		return nil
-	}}
+	}})
+	if !ok {
+		close(ch)
+	}
 	return ch
 }
 
 // HasFetchedScans checks whether there is any has fetched scans
 func (model *Model) HasFetchedScans() <-chan bool {
 	ch := make(chan bool)
-	model.actions <- &modelAction{"hasFetchedScans", func() error {
+	ok := model.send(&modelAction{"hasFetchedScans", func() error {
 		ch <- model.hasFetchedScans
 		return nil
-	}}
+	}})
+	if !ok {
+		close(ch)
+	}
 	return ch
 }
 
@@ -0,0 +1,125 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package hub
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/blackducksoftware/perceptor/pkg/api"
+)
+
+// RateLimitPolicy configures how fast requests may be issued to a hub.
+type RateLimitPolicy struct {
+	// RequestsPerSecond is the rate at which the token bucket refills; 0 means
+	// no rate limit
+	RequestsPerSecond float64
+	// Burst is the size of the token bucket; it's at least 1
+	Burst int
+	// MaxInFlight caps the number of concurrent requests; 0 means no cap
+	MaxInFlight int
+}
+
+// RateLimiter is a token bucket combined with a cap on requests in flight.
+// A nil RateLimiter doesn't limit anything.
+type RateLimiter struct {
+	policy     RateLimitPolicy
+	mutex      sync.Mutex
+	tokens     float64
+	lastRefill time.Time
+	inFlight   chan struct{}
+}
+
+// NewRateLimiter returns nil if `policy` is nil, or doesn't limit anything.
+func NewRateLimiter(policy *RateLimitPolicy) *RateLimiter {
+	if policy == nil || (policy.RequestsPerSecond <= 0 && policy.MaxInFlight <= 0) {
+		return nil
+	}
+	rl := &RateLimiter{
+		policy:     *policy,
+		lastRefill: time.Now(),
+	}
+	if rl.policy.Burst < 1 {
+		rl.policy.Burst = 1
+	}
+	rl.tokens = float64(rl.policy.Burst)
+	if rl.policy.MaxInFlight > 0 {
+		rl.inFlight = make(chan struct{}, rl.policy.MaxInFlight)
+	}
+	return rl
+}
+
+// wait blocks until a request may be issued, and returns how long that took.
+// Every call to wait must be followed by a call to done.
+func (rl *RateLimiter) wait() time.Duration {
+	if rl == nil {
+		return 0
+	}
+	start := time.Now()
+	if rl.inFlight != nil {
+		rl.inFlight <- struct{}{}
+	}
+	time.Sleep(rl.reserveToken())
+	return time.Now().Sub(start)
+}
+
+// reserveToken takes a token from the bucket, and returns how long to wait
+// until the token is actually available.  The bucket can go into debt, which
+// keeps waiting requests in order.
+func (rl *RateLimiter) reserveToken() time.Duration {
+	if rl.policy.RequestsPerSecond <= 0 {
+		return 0
+	}
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	now := time.Now()
+	refill := now.Sub(rl.lastRefill).Seconds() * rl.policy.RequestsPerSecond
+	rl.tokens = math.Min(float64(rl.policy.Burst), rl.tokens+refill)
+	rl.lastRefill = now
+	rl.tokens--
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens / rl.policy.RequestsPerSecond * float64(time.Second))
+}
+
+// done frees up the request's in-flight slot.
+func (rl *RateLimiter) done() {
+	if rl == nil || rl.inFlight == nil {
+		return
+	}
+	<-rl.inFlight
+}
+
+// Model .....
+func (rl *RateLimiter) Model() *api.ModelRateLimiter {
+	if rl == nil {
+		return nil
+	}
+	return &api.ModelRateLimiter{
+		RequestsPerSecond: rl.policy.RequestsPerSecond,
+		Burst:             rl.policy.Burst,
+		MaxInFlight:       rl.policy.MaxInFlight,
+		InFlight:          len(rl.inFlight),
+	}
+}
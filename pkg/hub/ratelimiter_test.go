@@ -0,0 +1,94 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRateLimiterTokenBucket .....
+func TestRateLimiterTokenBucket(t *testing.T) {
+	if NewRateLimiter(nil) != nil || NewRateLimiter(&RateLimitPolicy{Burst: 3}) != nil {
+		t.Errorf("expected nil rate limiter for a policy which doesn't limit anything")
+	}
+	var unlimited *RateLimiter
+	if wait := unlimited.wait(); wait != 0 {
+		t.Errorf("expected nil rate limiter not to wait, waited %s", wait)
+	}
+	unlimited.done()
+
+	rl := NewRateLimiter(&RateLimitPolicy{RequestsPerSecond: 10, Burst: 2})
+	// the burst goes through right away
+	for i := 0; i < 2; i++ {
+		if wait := rl.wait(); wait > 20*time.Millisecond {
+			t.Errorf("expected request %d not to wait, waited %s", i, wait)
+		}
+		rl.done()
+	}
+	// the bucket is empty -> the next requests wait for a token each
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		rl.wait()
+		rl.done()
+	}
+	if elapsed := time.Now().Sub(start); elapsed < 180*time.Millisecond {
+		t.Errorf("expected 2 requests to wait about 200ms, waited %s", elapsed)
+	}
+}
+
+// TestRateLimiterMaxInFlight .....
+func TestRateLimiterMaxInFlight(t *testing.T) {
+	cb := NewCircuitBreaker("testhost", nil)
+	rl := NewRateLimiter(&RateLimitPolicy{MaxInFlight: 2})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go cb.IssueRequest("abc", rl, func() error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+	}
+	<-started
+	<-started
+	if inFlight := rl.Model().InFlight; inFlight != 2 {
+		t.Errorf("expected 2 requests in flight, found %d", inFlight)
+	}
+
+	// the third request waits for a slot
+	done := make(chan time.Duration)
+	go func() {
+		done <- rl.wait()
+		rl.done()
+	}()
+	select {
+	case wait := <-done:
+		t.Errorf("expected third request to wait for a slot, waited %s", wait)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	if wait := <-done; wait < 50*time.Millisecond {
+		t.Errorf("expected third request to wait at least 50ms, waited %s", wait)
+	}
+	release <- struct{}{}
+}
@@ -33,6 +33,8 @@ type RawClientInterface interface {
 	CurrentVersion() (*hubapi.CurrentVersion, error)
 	SetTimeout(timeout time.Duration)
 	Login(username string, password string) error
+	AuthenticateWithAPIToken(apiToken string) (*BearerToken, error)
+	IssueBearerToken(apiToken string) (*BearerToken, error)
 	ListAllCodeLocations(options *hubapi.GetListOptions) (*hubapi.CodeLocationList, error)
 	ListProjects(options *hubapi.GetListOptions) (*hubapi.ProjectList, error)
 	GetProject(link hubapi.ResourceLink) (*hubapi.Project, error)
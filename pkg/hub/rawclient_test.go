@@ -23,12 +23,10 @@ package hub
 
 import (
 	"testing"
-
-	"github.com/blackducksoftware/hub-client-go/hubclient"
 )
 
 func TestRawClientInterfaceImplementations(t *testing.T) {
-	consumeRawClientInterface(&hubclient.Client{})
+	consumeRawClientInterface(&TokenClient{})
 	consumeRawClientInterface(&MockRawClient{})
 }
 
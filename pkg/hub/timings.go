@@ -24,10 +24,16 @@ package hub
 import "time"
 
 // Timings ...
+// FetchAllScansPause is the pause between incremental code location syncs,
+// and FullSyncPause the pause between full syncs, which also find the code
+// locations deleted from the hub.  RefreshScanThreshold is how old the
+// results of a completed image may get before they're refreshed; the core
+// decides how often to check.
 type Timings struct {
 	ScanCompletionPause    time.Duration
 	FetchUnknownScansPause time.Duration
 	FetchAllScansPause     time.Duration
+	FullSyncPause          time.Duration
 	GetMetricsPause        time.Duration
 	LoginPause             time.Duration
 	RefreshScanThreshold   time.Duration
@@ -35,7 +41,8 @@ type Timings struct {
 
 // DefaultTimings ...
 var DefaultTimings = &Timings{
-	FetchAllScansPause:     999999 * time.Hour,
+	FetchAllScansPause:     5 * time.Minute,
+	FullSyncPause:          6 * time.Hour,
 	ScanCompletionPause:    1 * time.Minute,
 	FetchUnknownScansPause: 30 * time.Second,
 	GetMetricsPause:        15 * time.Second,
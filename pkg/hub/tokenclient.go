@@ -0,0 +1,230 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package hub
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/blackducksoftware/hub-client-go/hubapi"
+	"github.com/blackducksoftware/hub-client-go/hubclient"
+	"github.com/juju/errors"
+)
+
+// BearerToken is what Black Duck hands out in exchange for an API token.
+type BearerToken struct {
+	Token  string
+	Expiry time.Time
+}
+
+type authenticateResponse struct {
+	BearerToken           string `json:"bearerToken"`
+	ExpiresInMilliseconds int64  `json:"expiresInMilliseconds"`
+}
+
+// TokenClient implements RawClientInterface on top of hub-client-go's
+// client, adding API token authentication, which hub-client-go doesn't
+// support.  Until it's authenticated with an API token, it uses a
+// session-based client, so that it can also log in with a password.  Each
+// time it exchanges the API token for a new bearer token, it switches to a
+// new hub-client-go client which uses that bearer token.  Logging in with a
+// password switches back to a session-based client.
+type TokenClient struct {
+	baseURL    string
+	debugFlags hubclient.HubClientDebug
+	transport  *http.Transport
+	mutex      sync.RWMutex
+	timeout    time.Duration
+	client     *hubclient.Client
+	// usesBearerToken is true while `client` is a bearer token client
+	usesBearerToken bool
+}
+
+// NewTokenClient .....
+func NewTokenClient(baseURL string, debugFlags hubclient.HubClientDebug, timeout time.Duration) (*TokenClient, error) {
+	client, err := hubclient.NewWithSession(baseURL, debugFlags, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenClient{
+		baseURL:    baseURL,
+		debugFlags: debugFlags,
+		// hub-client-go doesn't verify certificates either
+		transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		timeout:   timeout,
+		client:    client,
+	}, nil
+}
+
+func (tc *TokenClient) currentClient() *hubclient.Client {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+	return tc.client
+}
+
+// AuthenticateWithAPIToken exchanges `apiToken` for a bearer token, which
+// will be used for all subsequent requests.
+func (tc *TokenClient) AuthenticateWithAPIToken(apiToken string) (*BearerToken, error) {
+	bearerToken, err := tc.IssueBearerToken(apiToken)
+	if err != nil {
+		return nil, err
+	}
+	tc.mutex.RLock()
+	timeout := tc.timeout
+	tc.mutex.RUnlock()
+	client, err := hubclient.NewWithToken(tc.baseURL, bearerToken.Token, tc.debugFlags, timeout)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tc.mutex.Lock()
+	tc.client = client
+	tc.usesBearerToken = true
+	tc.mutex.Unlock()
+	return bearerToken, nil
+}
+
+// IssueBearerToken exchanges `apiToken` for a bearer token, without
+// changing what the client itself is authenticated with.  The bearer token
+// can do whatever the API token's user can do.
+func (tc *TokenClient) IssueBearerToken(apiToken string) (*BearerToken, error) {
+	tc.mutex.RLock()
+	timeout := tc.timeout
+	tc.mutex.RUnlock()
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/api/tokens/authenticate", tc.baseURL), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("token %s", apiToken))
+	request.Header.Set("Accept", "application/json")
+	start := time.Now()
+	httpClient := &http.Client{Transport: tc.transport, Timeout: timeout}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, errors.Annotate(err, "unable to authenticate with API token")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewHTTPError(resp, "unable to authenticate with API token")
+	}
+	var response authenticateResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, errors.Annotate(err, "unable to decode API token authentication response")
+	}
+	if response.BearerToken == "" {
+		return nil, errors.Errorf("API token authentication response had no bearer token")
+	}
+	return &BearerToken{
+		Token:  response.BearerToken,
+		Expiry: start.Add(time.Duration(response.ExpiresInMilliseconds) * time.Millisecond),
+	}, nil
+}
+
+// SetTimeout .....
+func (tc *TokenClient) SetTimeout(timeout time.Duration) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	tc.timeout = timeout
+	tc.client.SetTimeout(timeout)
+}
+
+// Login .....
+func (tc *TokenClient) Login(username string, password string) error {
+	client, err := tc.sessionClient()
+	if err != nil {
+		return err
+	}
+	return client.Login(username, password)
+}
+
+// sessionClient returns the current client if it's session-based.  If it
+// uses a bearer token -- which a session-based login can't replace, and
+// which would go on being sent with every request -- it's swapped for a new
+// session-based client first.
+func (tc *TokenClient) sessionClient() (*hubclient.Client, error) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	if !tc.usesBearerToken {
+		return tc.client, nil
+	}
+	client, err := hubclient.NewWithSession(tc.baseURL, tc.debugFlags, tc.timeout)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tc.client = client
+	tc.usesBearerToken = false
+	return client, nil
+}
+
+// CurrentVersion .....
+func (tc *TokenClient) CurrentVersion() (*hubapi.CurrentVersion, error) {
+	return tc.currentClient().CurrentVersion()
+}
+
+// ListAllCodeLocations .....
+func (tc *TokenClient) ListAllCodeLocations(options *hubapi.GetListOptions) (*hubapi.CodeLocationList, error) {
+	return tc.currentClient().ListAllCodeLocations(options)
+}
+
+// ListProjects .....
+func (tc *TokenClient) ListProjects(options *hubapi.GetListOptions) (*hubapi.ProjectList, error) {
+	return tc.currentClient().ListProjects(options)
+}
+
+// GetProject .....
+func (tc *TokenClient) GetProject(link hubapi.ResourceLink) (*hubapi.Project, error) {
+	return tc.currentClient().GetProject(link)
+}
+
+// GetProjectVersion .....
+func (tc *TokenClient) GetProjectVersion(link hubapi.ResourceLink) (*hubapi.ProjectVersion, error) {
+	return tc.currentClient().GetProjectVersion(link)
+}
+
+// ListScanSummaries .....
+func (tc *TokenClient) ListScanSummaries(link hubapi.ResourceLink) (*hubapi.ScanSummaryList, error) {
+	return tc.currentClient().ListScanSummaries(link)
+}
+
+// GetProjectVersionRiskProfile .....
+func (tc *TokenClient) GetProjectVersionRiskProfile(link hubapi.ResourceLink) (*hubapi.ProjectVersionRiskProfile, error) {
+	return tc.currentClient().GetProjectVersionRiskProfile(link)
+}
+
+// GetProjectVersionPolicyStatus .....
+func (tc *TokenClient) GetProjectVersionPolicyStatus(link hubapi.ResourceLink) (*hubapi.ProjectVersionPolicyStatus, error) {
+	return tc.currentClient().GetProjectVersionPolicyStatus(link)
+}
+
+// DeleteProjectVersion .....
+func (tc *TokenClient) DeleteProjectVersion(name string) error {
+	return tc.currentClient().DeleteProjectVersion(name)
+}
+
+// DeleteCodeLocation .....
+func (tc *TokenClient) DeleteCodeLocation(name string) error {
+	return tc.currentClient().DeleteCodeLocation(name)
+}
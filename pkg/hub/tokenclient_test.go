@@ -0,0 +1,70 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenClientSwitchesFromTokenToPassword(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tokens/authenticate":
+			fmt.Fprint(w, `{"bearerToken": "bearer-token", "expiresInMilliseconds": 60000}`)
+		case "/j_spring_security_check":
+			http.SetCookie(w, &http.Cookie{Name: "SESSION", Value: "session"})
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/current-version":
+			cookie, err := r.Cookie("SESSION")
+			if err != nil || cookie.Value != "session" || r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"version": "2018.12.0"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tc, err := NewTokenClient(server.URL, 0, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unable to create token client: %s", err.Error())
+	}
+	if _, err = tc.AuthenticateWithAPIToken("api-token"); err != nil {
+		t.Fatalf("unable to authenticate with API token: %s", err.Error())
+	}
+	if err = tc.Login("username", "password"); err != nil {
+		t.Fatalf("unable to log in: %s", err.Error())
+	}
+	version, err := tc.CurrentVersion()
+	if err != nil {
+		t.Fatalf("expected a session after logging in, got %s", err.Error())
+	}
+	if version.Version != "2018.12.0" {
+		t.Errorf("expected version 2018.12.0, got %s", version.Version)
+	}
+}
@@ -24,19 +24,28 @@ package util
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 type node struct {
 	key      string
 	priority int
 	value    interface{}
+	addedAt  time.Time
 }
 
 // PriorityQueue uses a max heap, and provides efficient changing of priority.
+//
+// It optionally supports aging: if the aging interval is positive, an item's
+// effective priority goes up by 1 for each aging interval it spends in the
+// queue.  Since every item ages at the same rate, the order of two items
+// never changes as time passes, so aging doesn't require re-heapifying --
+// the heap is simply ordered on `priority - addedAt / agingInterval`.
 type PriorityQueue struct {
-	items      []*node
-	size       int
-	keyToIndex map[string]int
+	items         []*node
+	size          int
+	keyToIndex    map[string]int
+	agingInterval time.Duration
 }
 
 // NewPriorityQueue .....
@@ -52,6 +61,28 @@ func newPriorityQueueWithInitialCapacity(capacity int) *PriorityQueue {
 	}
 }
 
+// PriorityQueueItem is an exported entry of a PriorityQueue, without its
+// value.
+type PriorityQueueItem struct {
+	Key      string
+	Priority int
+	AddedAt  time.Time
+}
+
+// Items exports the queue's entries in heap order.  Adding them back with
+// AddAt, in the same order, reproduces the heap exactly.
+func (pq *PriorityQueue) Items() []*PriorityQueueItem {
+	items := make([]*PriorityQueueItem, pq.size)
+	for i := 0; i < pq.size; i++ {
+		items[i] = &PriorityQueueItem{
+			Key:      pq.items[i].key,
+			Priority: pq.items[i].priority,
+			AddedAt:  pq.items[i].addedAt,
+		}
+	}
+	return items
+}
+
 // Values should only be used for debugging.
 func (pq *PriorityQueue) Values() []interface{} {
 	elems := make([]interface{}, pq.size)
@@ -100,11 +131,17 @@ func (pq *PriorityQueue) DebugString() string {
 
 // Add adds an element.  'key' must be unique.
 func (pq *PriorityQueue) Add(key string, priority int, value interface{}) error {
+	return pq.AddAt(key, priority, value, time.Now())
+}
+
+// AddAt adds an element which has been waiting since 'addedAt' -- for
+// example, when restoring a queue.  'key' must be unique.
+func (pq *PriorityQueue) AddAt(key string, priority int, value interface{}, addedAt time.Time) error {
 	if _, ok := pq.keyToIndex[key]; ok {
 		return fmt.Errorf("cannot add key %s: key already in map", key)
 	}
 	pq.resizeIfNecessary()
-	pq.items[pq.size] = &node{key: key, priority: priority, value: value}
+	pq.items[pq.size] = &node{key: key, priority: priority, value: value, addedAt: addedAt}
 	pq.keyToIndex[key] = pq.size
 	pq.siftUp(pq.size)
 	pq.size++
@@ -119,6 +156,38 @@ func (pq *PriorityQueue) Peek() interface{} {
 	return pq.items[0].value
 }
 
+// PeekMatching returns the highest priority item for which 'matches' returns
+// true, or nil if there isn't one.  Since nothing below a matching item in
+// the heap can beat it, only the items above the matches get looked at.
+func (pq *PriorityQueue) PeekMatching(matches func(value interface{}) bool) interface{} {
+	var best *node
+	indices := []int{}
+	if pq.size > 0 {
+		indices = append(indices, 0)
+	}
+	for len(indices) > 0 {
+		index := indices[len(indices)-1]
+		indices = indices[:len(indices)-1]
+		item := pq.items[index]
+		if best != nil && !pq.isHigher(item, best) {
+			continue
+		}
+		if matches(item.value) {
+			best = item
+			continue
+		}
+		for _, child := range []int{leftChild(index), rightChild(index)} {
+			if child < pq.size {
+				indices = append(indices, child)
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.value
+}
+
 // Pop removes the highest priority element, returning an error if empty.
 func (pq *PriorityQueue) Pop() (interface{}, error) {
 	if pq.size == 0 {
@@ -163,6 +232,38 @@ func (pq *PriorityQueue) Set(key string, priority int) error {
 	return nil
 }
 
+// SetAgingInterval changes how quickly items' effective priorities go up
+// while they wait.  An interval of 0 turns aging off.  This re-heapifies the
+// whole queue, in linear time.
+func (pq *PriorityQueue) SetAgingInterval(agingInterval time.Duration) {
+	if agingInterval == pq.agingInterval {
+		return
+	}
+	pq.agingInterval = agingInterval
+	for i := pq.size/2 - 1; i >= 0; i-- {
+		pq.siftDown(i)
+	}
+}
+
+// AgingInterval .....
+func (pq *PriorityQueue) AgingInterval() time.Duration {
+	return pq.agingInterval
+}
+
+// EffectivePriority returns the priority of the key, plus whatever it's
+// gained from waiting in the queue until 'now'.
+func (pq *PriorityQueue) EffectivePriority(key string, now time.Time) (int, error) {
+	index, ok := pq.keyToIndex[key]
+	if !ok {
+		return 0, fmt.Errorf("cannot get effective priority of key %s, key not found", key)
+	}
+	item := pq.items[index]
+	if pq.agingInterval <= 0 {
+		return item.priority, nil
+	}
+	return item.priority + int(now.Sub(item.addedAt)/pq.agingInterval), nil
+}
+
 // HasKey returns whether the priority queue has the key.
 func (pq *PriorityQueue) HasKey(key string) bool {
 	_, ok := pq.keyToIndex[key]
@@ -208,7 +309,7 @@ func (pq *PriorityQueue) CheckValidity() []string {
 		}
 		curr := pq.items[i]
 		left := pq.items[lc]
-		if left.priority > curr.priority {
+		if pq.isHigher(left, curr) {
 			errors = append(errors, fmt.Sprintf("parent %d(%d) has lower priority than left child %d(%d)", i, curr.priority, lc, left.priority))
 		}
 		rc := rightChild(i)
@@ -216,7 +317,7 @@ func (pq *PriorityQueue) CheckValidity() []string {
 			break
 		}
 		right := pq.items[rc]
-		if right.priority > curr.priority {
+		if pq.isHigher(right, curr) {
 			errors = append(errors, fmt.Sprintf("parent %d(%d) has lower priority than right child %d(%d)", i, curr.priority, rc, right.priority))
 		}
 	}
@@ -236,6 +337,17 @@ func (pq *PriorityQueue) CheckValidity() []string {
 
 // Implementation details:
 
+// isHigher returns whether 'a' belongs above 'b' in the heap.
+func (pq *PriorityQueue) isHigher(a *node, b *node) bool {
+	if pq.agingInterval <= 0 {
+		return a.priority > b.priority
+	}
+	interval := float64(pq.agingInterval)
+	aScore := float64(a.priority) - float64(a.addedAt.UnixNano())/interval
+	bScore := float64(b.priority) - float64(b.addedAt.UnixNano())/interval
+	return aScore > bScore
+}
+
 func (pq *PriorityQueue) resizeIfNecessary() {
 	if pq.size < len(pq.items) {
 		return
@@ -264,14 +376,14 @@ func (pq *PriorityQueue) siftDown(index int) {
 		}
 		p := pq.items[ip]
 		lc := pq.items[ilc]
-		if p.priority < lc.priority {
+		if pq.isHigher(lc, p) {
 			inext = ilc
 		}
 
 		irc := rightChild(ip)
 		if irc < pq.size {
 			rc := pq.items[irc]
-			if pq.items[inext].priority < rc.priority {
+			if pq.isHigher(rc, pq.items[inext]) {
 				inext = irc
 			}
 		}
@@ -291,7 +403,7 @@ func (pq *PriorityQueue) siftUp(index int) {
 		}
 		p := pq.items[ip]
 		c := pq.items[ic]
-		if c.priority > p.priority {
+		if pq.isHigher(c, p) {
 			pq.swap(ic, ip)
 		}
 		if ic <= 0 {
@@ -319,6 +319,100 @@ var _ = Describe("Priority queue", func() {
 			}
 		})
 	})
+
+	Describe("PeekMatching", func() {
+		It("finds the highest priority matching item", func() {
+			pq := NewPriorityQueue()
+			for i := 0; i < 100; i++ {
+				Expect(pq.Add(fmt.Sprintf("k%d", i), rand.Intn(1000), i)).To(BeNil())
+			}
+			isOdd := func(value interface{}) bool { return value.(int)%2 == 1 }
+			Expect(pq.PeekMatching(func(value interface{}) bool { return true })).To(Equal(pq.Peek()))
+			Expect(pq.PeekMatching(func(value interface{}) bool { return false })).To(BeNil())
+			for !pq.IsEmpty() {
+				best := -1
+				var expected interface{}
+				for _, item := range pq.items[:pq.size] {
+					if isOdd(item.value) && item.priority > best {
+						best = item.priority
+						expected = item.value
+					}
+				}
+				match := pq.PeekMatching(isOdd)
+				if expected == nil {
+					Expect(match).To(BeNil())
+				} else {
+					priority := pq.items[pq.keyToIndex[fmt.Sprintf("k%d", match.(int))]].priority
+					Expect(priority).To(Equal(best))
+				}
+				_, err := pq.Pop()
+				Expect(err).To(BeNil())
+			}
+		})
+	})
+
+	Describe("Items", func() {
+		It("should reproduce the heap when added back in order", func() {
+			now := time.Now()
+			pq := NewPriorityQueue()
+			for i := 0; i < 30; i++ {
+				pq.AddAt(fmt.Sprintf("k%d", i), rand.Intn(10), i, now.Add(-time.Duration(i)*time.Minute))
+			}
+			items := pq.Items()
+			Expect(len(items)).To(Equal(30))
+			restored := NewPriorityQueue()
+			for _, item := range items {
+				Expect(restored.AddAt(item.Key, item.Priority, item.Key, item.AddedAt)).To(BeNil())
+			}
+			Expect(restored.Items()).To(Equal(items))
+		})
+	})
+
+	Describe("Aging", func() {
+		It("should move items up as they wait", func() {
+			now := time.Now()
+			pq := NewPriorityQueue()
+			pq.AddAt("old", 1, "v-old", now.Add(-30*time.Minute))
+			pq.AddAt("new", 3, "v-new", now)
+			pq.AddAt("middle", 2, "v-middle", now.Add(-5*time.Minute))
+			Expect(pq.Peek()).To(Equal("v-new"))
+
+			pq.SetAgingInterval(10 * time.Minute)
+			Expect(pq.CheckValidity()).To(Equal([]string{}))
+			Expect(pq.Peek()).To(Equal("v-old"))
+			Expect(pq.EffectivePriority("old", now)).To(Equal(4))
+			Expect(pq.EffectivePriority("middle", now)).To(Equal(2))
+
+			pq.SetAgingInterval(0)
+			Expect(pq.CheckValidity()).To(Equal([]string{}))
+			Expect(pq.Peek()).To(Equal("v-new"))
+			Expect(pq.EffectivePriority("old", now)).To(Equal(1))
+		})
+		It("should keep the heap valid when priorities change", func() {
+			now := time.Now()
+			pq := NewPriorityQueue()
+			pq.SetAgingInterval(time.Minute)
+			for i := 0; i < 50; i++ {
+				pq.AddAt(fmt.Sprintf("k%d", i), rand.Intn(20), i, now.Add(-time.Duration(rand.Intn(600))*time.Second))
+			}
+			for i := 0; i < 50; i += 3 {
+				Expect(pq.Set(fmt.Sprintf("k%d", i), rand.Intn(20))).To(BeNil())
+			}
+			Expect(pq.CheckValidity()).To(Equal([]string{}))
+			previous := 1000
+			for !pq.IsEmpty() {
+				index := pq.keyToIndex[pq.items[0].key]
+				Expect(index).To(Equal(0))
+				priority, err := pq.EffectivePriority(pq.items[0].key, now)
+				Expect(err).To(BeNil())
+				// effective priorities are rounded down, so can be off by one
+				Expect(priority).To(BeNumerically("<=", previous+1))
+				previous = priority
+				_, err = pq.Pop()
+				Expect(err).To(BeNil())
+			}
+		})
+	})
 })
 
 func checkArrayForSortedness(arr []int) [][3]int {
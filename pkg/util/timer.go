@@ -195,6 +195,12 @@ func (timer *Timer) start() {
 		case delay := <-timer.setDelay:
 			//			log.Debugf("timer %s: setDelay", timer.name)
 			timer.delay = delay
+			// restart the ticker, so that the new delay applies to the
+			// next invocation rather than only after pausing and resuming
+			if c != nil {
+				stopTimer()
+				startTimer()
+			}
 		}
 	}
 }
@@ -214,7 +220,12 @@ func (timer *Timer) Resume(runImmediately bool) error {
 	return <-action.err
 }
 
-// SetDelay sets the delay
+// SetDelay sets the delay.  If the timer is running, the next invocation
+// will be `delay` from now.
 func (timer *Timer) SetDelay(delay time.Duration) {
+	if delay <= 0 {
+		log.Errorf("ignoring invalid delay for timer %s: must be positive, was %s", timer.name, delay)
+		return
+	}
 	timer.setDelay <- delay
 }
@@ -116,6 +116,17 @@ var _ = Describe("Timer", func() {
 		Expect(y).To(Equal(2))
 	})
 
+	It("applies a new delay to the next invocation", func() {
+		stop := make(chan struct{})
+		defer close(stop)
+		x := 0
+		timer := NewRunningTimer("test10", 1*time.Hour, stop, false, func() { x++ })
+		timer.SetDelay(250 * time.Millisecond)
+		time.Sleep(400 * time.Millisecond)
+		Expect(timer.Pause()).To(BeNil())
+		Expect(x).To(Equal(1))
+	})
+
 	It("state during action execution is 'running action'", func() {
 		stop := make(chan struct{})
 		defer close(stop)